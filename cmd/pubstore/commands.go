@@ -0,0 +1,212 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/edrlab/pubstore/pkg/bulk"
+	"github.com/edrlab/pubstore/pkg/ingest"
+	"github.com/edrlab/pubstore/pkg/onix"
+)
+
+const usage = `Usage: pubstore [command] [arguments]
+
+Without command, pubstore runs the server. Commands:
+  ingest [-copies n] file...   create publications from EPUB, PDF, audiobook or Divina packages
+  onix file...                 create or update publications from ONIX 3.0 messages, by ISBN
+  import [-format f] [-dry-run] publications|users file
+                               create or update publications or users from a JSON Lines or CSV file
+  export [-format f] [-after uuid] [-hashes] publications|users [file]
+                               export publications or users as JSON Lines or CSV, to a file or stdout
+`
+
+// runCommand runs a command line subcommand and returns the exit code of the process
+func (s *Server) runCommand(name string, args []string) int {
+
+	switch name {
+	case "ingest":
+		return s.ingestCommand(args)
+	case "onix":
+		return s.onixCommand(args)
+	case "import":
+		return s.importCommand(args)
+	case "export":
+		return s.exportCommand(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", name, usage)
+		return 2
+	}
+}
+
+// ingestCommand creates a publication from each package given as argument
+func (s *Server) ingestCommand(args []string) int {
+
+	flags := flag.NewFlagSet("ingest", flag.ContinueOnError)
+	copies := flags.Int("copies", 0, "number of copies which can be lent simultaneously, 0 meaning unlimited")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	ingester := ingest.New(s.Config, s.Store)
+	code := 0
+	for _, path := range flags.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+			code = 1
+			continue
+		}
+		publication, err := ingester.Ingest(context.Background(), f, path)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+			code = 1
+			continue
+		}
+		if *copies > 0 {
+			publication.Copies = *copies
+			if err = s.Store.UpdatePublication(publication); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+				code = 1
+			}
+		}
+		fmt.Printf("%s: created publication %s \"%s\"\n", path, publication.UUID, publication.Title)
+	}
+	return code
+}
+
+// onixCommand imports the products of each ONIX message given as argument and prints a report
+func (s *Server) onixCommand(args []string) int {
+
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+
+	importer := onix.New(s.Config, s.Store)
+	code := 0
+	for _, path := range args {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+			code = 1
+			continue
+		}
+		report, err := importer.Import(context.Background(), f)
+		f.Close()
+		for _, record := range report.Records {
+			if record.Status == onix.StatusRejected {
+				fmt.Printf("%s: %s %s \"%s\" rejected: %s\n", path, record.Reference, record.ISBN, record.Title, record.Error)
+			} else {
+				fmt.Printf("%s: %s %s \"%s\" %s as publication %s\n", path, record.Reference, record.ISBN, record.Title, record.Status, record.UUID)
+			}
+		}
+		fmt.Printf("%s: %d created, %d updated, %d rejected\n", path, report.Created, report.Updated, report.Rejected)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+			code = 1
+		} else if report.Rejected > 0 {
+			code = 1
+		}
+	}
+	return code
+}
+
+// importCommand imports publications or users from a JSON Lines or CSV file and prints a report
+func (s *Server) importCommand(args []string) int {
+
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "", "jsonl or csv, inferred from the file extension by default")
+	dryRun := flags.Bool("dry-run", false, "check every line without saving anything")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 2 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	entity, path := flags.Arg(0), flags.Arg(1)
+	if *format == "" {
+		*format = bulk.FormatFromName(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+		return 1
+	}
+	defer f.Close()
+
+	report, err := bulk.Import(context.Background(), s.Store, entity, *format, f, *dryRun)
+	if report != nil {
+		for _, e := range report.Errors {
+			fmt.Printf("%s:%d: %s\n", path, e.Line, e.Error)
+		}
+		verb := "imported"
+		if report.DryRun {
+			verb = "valid (dry run)"
+		}
+		fmt.Printf("%s: %d lines, %d %s, %d rejected\n", path, report.Lines, report.Imported, verb, report.Rejected)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+		return 1
+	}
+	if report.Rejected > 0 {
+		return 1
+	}
+	return 0
+}
+
+// exportCommand exports publications or users as JSON Lines or CSV
+func (s *Server) exportCommand(args []string) int {
+
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "", "jsonl or csv, inferred from the file extension by default")
+	after := flags.String("after", "", "UUID of the last record already exported, to resume an export")
+	hashes := flags.Bool("hashes", false, "export users with the hashes of their password and passphrase")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 1 || flags.NArg() > 2 {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	entity := flags.Arg(0)
+
+	out := os.Stdout
+	if path := flags.Arg(1); path != "" {
+		if *format == "" {
+			*format = bulk.FormatFromName(path)
+		}
+		f, err := os.Create(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err.Error())
+			return 1
+		}
+		defer f.Close()
+		out = f
+	}
+	if *format == "" {
+		*format = bulk.FormatJSONL
+	}
+
+	if err := bulk.Export(context.Background(), s.Store, entity, *format, out, *after, *hashes); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
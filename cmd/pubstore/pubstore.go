@@ -17,6 +17,7 @@ import (
 
 	"github.com/edrlab/pubstore/pkg/api"
 	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/lcp"
 	"github.com/edrlab/pubstore/pkg/opds"
 	"github.com/edrlab/pubstore/pkg/stor"
 	"github.com/edrlab/pubstore/pkg/view"
@@ -37,6 +38,11 @@ func main() {
 	s := Server{}
 	s.Initialize()
 
+	// run a command line subcommand instead of the server
+	if len(os.Args) > 1 {
+		os.Exit(s.runCommand(os.Args[1], os.Args[2:]))
+	}
+
 	// create an HTTP Server
 	server := &http.Server{Addr: fmt.Sprintf("0.0.0.0:%d", s.Config.Port), Handler: s.Router}
 
@@ -68,6 +74,9 @@ func main() {
 		serverStop()
 	}()
 
+	// promote holds periodically, as copies become available when loans expire
+	go s.processHolds(serverCtx, time.Hour)
+
 	// run the server
 	log.Println("Server starting on port " + strconv.Itoa(s.Config.Port))
 	err := server.ListenAndServe()
@@ -100,11 +109,30 @@ func (s *Server) Initialize() {
 	}
 	s.Store = &str
 
+	// Replace the search engine of the database by an embedded Bleve index, if configured
+	if cfg.SearchIndex != "" {
+		engine, err := stor.NewBleveEngine(cfg.SearchIndex)
+		if err == nil {
+			err = str.SetSearchEngine(engine)
+		}
+		if err != nil {
+			log.Println("Search index setup failed: " + err.Error())
+			os.Exit(1)
+		}
+	}
+
+	// Initialize the license provider
+	lp, err := lcp.NewLicenseProvider(s.Config)
+	if err != nil {
+		log.Println("License provider setup failed: " + err.Error())
+		os.Exit(1)
+	}
+
 	// Initialize packages
-	_api := api.Init(s.Config, s.Store)
-	_view := view.Init(s.Config, s.Store)
-	_web := web.Init(s.Config, s.Store, &_view)
-	_opds := opds.Init(s.Config, s.Store)
+	_api := api.Init(s.Config, s.Store, lp)
+	_view := view.Init(s.Config, s.Store, lp)
+	_web := web.Init(s.Config, s.Store, &_view, lp)
+	_opds := opds.Init(s.Config, s.Store, lp)
 
 	// Initialize api routes
 	r := chi.NewRouter()
@@ -118,3 +146,20 @@ func (s *Server) Initialize() {
 
 	s.Router = r
 }
+
+// processHolds promotes the holds of all publications at each interval, until the context is done
+func (s *Server) processHolds(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Store.ProcessHolds(s.Config.HoldReadyPeriod()); err != nil {
+				log.Println("Holds processing failed: " + err.Error())
+			}
+		}
+	}
+}
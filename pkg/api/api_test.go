@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/lcp"
 	"github.com/edrlab/pubstore/pkg/stor"
 )
 
@@ -20,7 +21,12 @@ func TestMain(m *testing.M) {
 		panic("Database setup failed.")
 	}
 
-	testapi = Init(&config, &store)
+	lp, err := lcp.NewFake("http://localhost:8080")
+	if err != nil {
+		panic("License provider setup failed.")
+	}
+
+	testapi = Init(&config, &store, lp)
 
 	// Run the tests
 	exitCode := m.Run()
@@ -0,0 +1,98 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/edrlab/pubstore/pkg/bulk"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+)
+
+// @Summary Export publications or users
+// @Description Stream every publication or user as JSON Lines or CSV, in creation order.
+// @Description An interrupted export is resumed after the record whose UUID is given as "after".
+// @Tags bulk
+// @Produce application/x-ndjson
+// @Produce text/csv
+// @Param entity path string true "publications or users"
+// @Param format query string false "jsonl (default) or csv"
+// @Param after query string false "UUID of the last record already exported"
+// @Param hashes query bool false "export users with the hashes of their password and passphrase"
+// @Success 200 "Stream of records"
+// @Failure 400 {object} ErrorResponse "Unknown entity, format or cursor"
+// @Router /bulk/{entity} [get]
+
+// exportBulk streams publications or users.
+func (a *Api) exportBulk(w http.ResponseWriter, r *http.Request) {
+
+	entity := chi.URLParam(r, "entity")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = bulk.FormatJSONL
+	}
+
+	hashes, _ := strconv.ParseBool(r.URL.Query().Get("hashes"))
+
+	w.Header().Set("Content-Type", bulk.ContentType(format))
+	err := bulk.Export(r.Context(), a.Store, entity, format, w, r.URL.Query().Get("after"), hashes)
+	if errors.Is(err, bulk.ErrUnknownEntity) || errors.Is(err, bulk.ErrUnknownFormat) || errors.Is(err, bulk.ErrUnknownCursor) {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err != nil {
+		// the response may be partially sent, the error is only logged
+		fmt.Println("Export of " + entity + " failed: " + err.Error())
+	}
+}
+
+// @Summary Import publications or users
+// @Description Create or update publications or users, identified by their UUID, from JSON Lines or CSV.
+// @Description Records are saved in batches; invalid lines are reported and do not prevent the import of other lines.
+// @Tags bulk
+// @Accept application/x-ndjson
+// @Accept text/csv
+// @Produce json
+// @Param entity path string true "publications or users"
+// @Param format query string false "jsonl or csv, inferred from the Content-Type by default"
+// @Param dry_run query bool false "check every line without saving anything"
+// @Success 200 {object} bulk.Report "Imported and rejected lines"
+// @Failure 400 {object} ErrorResponse "Unknown entity or format, or unreadable stream"
+// @Router /bulk/{entity} [post]
+
+// importBulk creates or updates publications or users from a stream.
+func (a *Api) importBulk(w http.ResponseWriter, r *http.Request) {
+
+	entity := chi.URLParam(r, "entity")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = bulk.FormatFromContentType(r.Header.Get("Content-Type"))
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+
+	report, err := bulk.Import(r.Context(), a.Store, entity, format, r.Body, dryRun)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	fmt.Printf("Import of %s: %d lines, %d imported, %d rejected\n", entity, report.Lines, report.Imported, report.Rejected)
+
+	if err := render.Render(w, r, &BulkReportResponse{Report: report}); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+type BulkReportResponse struct {
+	*bulk.Report
+}
+
+func (rep *BulkReportResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
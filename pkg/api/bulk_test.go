@@ -0,0 +1,165 @@
+package api
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkHandler(t *testing.T) {
+
+	// bulk routes use the basic auth credentials of the administrator
+	userName, password := testapi.Config.UserName, testapi.Config.Password
+	testapi.Config.UserName, testapi.Config.Password = "notifier", "notify"
+	testapi.Config.AdminUserName, testapi.Config.AdminPassword = "admin", "secret"
+	defer func() {
+		testapi.Config.UserName, testapi.Config.Password = userName, password
+		testapi.Config.AdminUserName, testapi.Config.AdminPassword = "", ""
+	}()
+
+	// Initialize the router
+	r := chi.NewRouter()
+	r.Group(testapi.Router)
+
+	send := func(method, target, contentType, body string, auth bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if auth {
+			req.SetBasicAuth("admin", "secret")
+		}
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		return recorder
+	}
+	type report struct {
+		DryRun   bool `json:"dry_run"`
+		Lines    int  `json:"lines"`
+		Imported int  `json:"imported"`
+		Rejected int  `json:"rejected"`
+		Errors   []struct {
+			Line  int    `json:"line"`
+			Error string `json:"error"`
+		} `json:"errors"`
+	}
+
+	uuid1, uuid2 := gofakeit.UUID(), gofakeit.UUID()
+	publications := `{"uuid":"` + uuid1 + `","title":"Bulk Publication 1","content_type":"application/epub+zip","author":["Bulk Author"],"language":["en"]}
+
+{"uuid":"` + uuid2 + `","title":"Bulk Publication 2","content_type":"application/pdf+lcp","copies":2}
+{"uuid":"invalid","title":"Invalid uuid"}
+{"title": 
+`
+
+	// try importing with no credentials, then with the credentials used to notify new publications
+	recorder := send("POST", "/api/bulk/publications", "application/x-ndjson", publications, false)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+	req := httptest.NewRequest("GET", "/api/bulk/users", nil)
+	req.SetBasicAuth("notifier", "notify")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	// an unknown entity is rejected
+	recorder = send("POST", "/api/bulk/transactions", "application/x-ndjson", publications, true)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// a dry run reports errors per line and saves nothing
+	recorder = send("POST", "/api/bulk/publications?dry_run=true", "application/x-ndjson", publications, true)
+	if !assert.Equal(t, http.StatusOK, recorder.Code) {
+		t.FailNow()
+	}
+	var rep report
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &rep))
+	assert.True(t, rep.DryRun)
+	assert.Equal(t, 4, rep.Lines)
+	assert.Equal(t, 2, rep.Imported)
+	assert.Equal(t, 2, rep.Rejected)
+	if assert.Len(t, rep.Errors, 2) {
+		assert.Equal(t, 4, rep.Errors[0].Line)
+		assert.Equal(t, 5, rep.Errors[1].Line)
+	}
+	_, err := testapi.Store.GetPublication(uuid1)
+	assert.Error(t, err)
+
+	// import the publications
+	recorder = send("POST", "/api/bulk/publications", "application/x-ndjson", publications, true)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &rep))
+	assert.Equal(t, 2, rep.Imported)
+	publication1, err := testapi.Store.GetPublication(uuid1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Bulk Publication 1", publication1.Title)
+		assert.Len(t, publication1.Author, 1)
+	}
+	publication2, err := testapi.Store.GetPublication(uuid2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, publication2.Copies)
+	}
+
+	// export the publications as JSON Lines, after the first one
+	recorder = send("GET", "/api/bulk/publications?after="+uuid1, "", "", true)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "application/x-ndjson", recorder.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	if assert.Len(t, lines, 1) {
+		assert.Contains(t, lines[0], uuid2)
+	}
+
+	// an unknown cursor is rejected
+	recorder = send("GET", "/api/bulk/publications?after="+gofakeit.UUID(), "", "", true)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// import users as CSV, columns in any order
+	email := gofakeit.Email()
+	users := "email,name,password,passphrase,text_hint\n" +
+		email + ",Bulk User,password,passphrase,hint\n" +
+		",User without email,password,passphrase,hint\n"
+	recorder = send("POST", "/api/bulk/users", "text/csv", users, true)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &rep))
+	assert.Equal(t, 1, rep.Imported)
+	if assert.Len(t, rep.Errors, 1) {
+		assert.Equal(t, 3, rep.Errors[0].Line)
+	}
+	user, err := testapi.Store.GetUserByEmail(email)
+	assert.NoError(t, err)
+
+	// export the users as CSV, without then with the hashes of their passwords
+	for _, hashes := range []bool{false, true} {
+		recorder = send("GET", "/api/bulk/users?format=csv&hashes="+strconv.FormatBool(hashes), "", "", true)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		rows, err := csv.NewReader(recorder.Body).ReadAll()
+		assert.NoError(t, err)
+		found := false
+		for _, row := range rows[1:] {
+			if row[0] == user.UUID {
+				found = true
+				if hashes {
+					assert.Equal(t, user.HPassword, row[4])
+				} else {
+					assert.Empty(t, row[4])
+					assert.Empty(t, row[7])
+				}
+			}
+		}
+		assert.True(t, found)
+	}
+
+	// clean up test data
+	err = testapi.Store.DeletePublication(publication1)
+	assert.NoError(t, err)
+	err = testapi.Store.DeletePublication(publication2)
+	assert.NoError(t, err)
+	err = testapi.Store.DeleteUser(user)
+	assert.NoError(t, err)
+}
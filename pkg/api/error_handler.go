@@ -64,6 +64,16 @@ func ErrServer(err error) render.Renderer {
 	}
 }
 
+func ErrConflict(err error) render.Renderer {
+	return &ErrResponse{
+		Err:            err,
+		HTTPStatusCode: 409,
+		Type:           "about:blank",
+		Title:          "Conflict with the current state of the resource",
+		Detail:         err.Error(),
+	}
+}
+
 var ErrNotFound = &ErrResponse{
 	HTTPStatusCode: 404,
 	Type:           "about:blank",
@@ -6,10 +6,13 @@ package api
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"net/http"
+	"time"
 
 	"github.com/edrlab/pubstore/pkg/lcp"
+	"github.com/go-chi/render"
 )
 
 // @Summary Get a fresh license
@@ -24,7 +27,7 @@ func (a *Api) getFreshLicense(w http.ResponseWriter, r *http.Request) {
 
 	transaction := fromTransContext(r.Context())
 
-	licenceBytes, err := lcp.GetFreshLicense(a.Config.LCPServer, transaction)
+	licenceBytes, err := a.LCP.GetFreshLicense(r.Context(), transaction)
 	if err != nil {
 		http.Error(w, http.StatusText(500), 500)
 		return
@@ -34,3 +37,66 @@ func (a *Api) getFreshLicense(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, bytes.NewReader(licenceBytes))
 
 }
+
+// RevocationRequest is the optional request payload of a license revocation.
+type RevocationRequest struct {
+	Message string `json:"message"`
+}
+
+// @Summary Revoke a license
+// @Description Revoke a license on the License Server and mark the related transaction as revoked. Requires the Basic Auth credentials of the administrator.
+// @Tags licences
+// @Accept json
+// @Produce json
+// @Param id path string true "License ID"
+// @Param revocation body RevocationRequest false "Revocation message"
+// @Success 200 {object} TransactionResponse "License revoked"
+// @Failure 400 {object} ErrorResponse "Invalid request payload"
+// @Failure 404 {object} ErrorResponse "License not found"
+// @Failure 500 {object} ErrorResponse "Revocation failed"
+// @Router /licenses/{id} [put]
+func (a *Api) revokeLicense(w http.ResponseWriter, r *http.Request) {
+
+	transaction := fromTransContext(r.Context())
+
+	// the payload is optional
+	data := &RevocationRequest{}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(data); err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+	}
+	if data.Message == "" {
+		data.Message = "License revoked by the publication store"
+	}
+
+	lsd, err := a.LCP.RevokeLicense(r.Context(), transaction, data.Message)
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+
+	// the license rights end now
+	now := time.Now().UTC().Truncate(time.Second)
+	transaction.EndDate = &now
+	transaction.Status = lcp.StatusRevoked
+	if lsd.Status != "" {
+		transaction.Status = lsd.Status
+	}
+	if err := a.Store.UpdateTransaction(transaction); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+
+	// the copy goes to the next hold in line
+	if _, err := a.Store.PromoteHolds(transaction.PublicationID, a.Config.HoldReadyPeriod()); err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+
+	if err := render.Render(w, r, NewTransactionResponse(transaction)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
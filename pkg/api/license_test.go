@@ -0,0 +1,229 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/lcp"
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevokeLicense(t *testing.T) {
+	// license revocation uses the basic auth credentials of the administrator
+	testapi.Config.AdminUserName, testapi.Config.AdminPassword = "admin", "secret"
+	defer func() {
+		testapi.Config.AdminUserName, testapi.Config.AdminPassword = "", ""
+	}()
+
+	// Initialize the router
+	r := chi.NewRouter()
+	r.Group(testapi.Router)
+
+	// fake License Server v2, which also acts as a Status Server
+	status := lcp.StatusActive
+	lcpServer := chi.NewRouter()
+	lsv := httptest.NewServer(lcpServer)
+	defer lsv.Close()
+	lcpServer.Post("/licenses/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		w.Write([]byte(`{"id":"` + id + `","links":[{"rel":"status","href":"` + lsv.URL + `/status/` + id + `"}]}`))
+	})
+	lcpServer.Put("/revoke/{id}", func(w http.ResponseWriter, r *http.Request) {
+		status = lcp.StatusRevoked
+	})
+	lcpServer.Get("/status/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"` + chi.URLParam(r, "id") + `","status":"` + status + `"}`))
+	})
+
+	lp := testapi.LCP
+	testapi.LCP = lcp.NewClient(conf.LCPServerAccess{Url: lsv.URL, Version: "v2"})
+	defer func() { testapi.LCP = lp }()
+
+	// init a user, a publication and a loan, directly in the store
+	user := &stor.User{
+		Name:       "Marie Curie",
+		Email:      gofakeit.Email(),
+		Password:   "password",
+		TextHint:   "hint",
+		Passphrase: "passphrase",
+	}
+	err := testapi.Store.CreateUser(user)
+	assert.NoError(t, err)
+
+	publication := &stor.Publication{
+		UUID:  gofakeit.UUID(),
+		Title: "Test Publication",
+	}
+	err = testapi.Store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	loan := &stor.Transaction{
+		UserID:        user.ID,
+		PublicationID: publication.ID,
+		LicenceId:     gofakeit.UUID(),
+		Type:          stor.TransactionTypeLoan,
+		Status:        lcp.StatusActive,
+	}
+	err = testapi.Store.CreateTransaction(loan)
+	assert.NoError(t, err)
+
+	revokeURL := "/api/licenses/" + loan.LicenceId
+
+	// try revoking the license with no credentials
+	req := httptest.NewRequest("PUT", revokeURL, nil)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	// a patron cannot revoke a license, even with a bearer token
+	tokenData := url.Values{
+		"grant_type": {"password"},
+		"username":   {user.Email},
+		"password":   {user.Password},
+	}
+	tokenReq := httptest.NewRequest("POST", "/api/token", strings.NewReader(tokenData.Encode()))
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenRecorder := httptest.NewRecorder()
+	r.ServeHTTP(tokenRecorder, tokenReq)
+	if !assert.Equal(t, http.StatusOK, tokenRecorder.Code) {
+		t.FailNow()
+	}
+	var tokenResp struct {
+		Token string `json:"access_token"`
+	}
+	err = json.Unmarshal(tokenRecorder.Body.Bytes(), &tokenResp)
+	assert.NoError(t, err)
+
+	req = httptest.NewRequest("PUT", revokeURL, nil)
+	req.Header.Set("Authorization", "Bearer "+tokenResp.Token)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	req = httptest.NewRequest("PUT", revokeURL, nil)
+	req.SetBasicAuth("admin", "wrong")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	// an unknown license is not found
+	req = httptest.NewRequest("PUT", "/api/licenses/"+gofakeit.UUID(), nil)
+	req.SetBasicAuth("admin", "secret")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	// revoke the license
+	req = httptest.NewRequest("PUT", revokeURL, strings.NewReader(`{"message":"test revocation"}`))
+	req.SetBasicAuth("admin", "secret")
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	if !assert.Equal(t, http.StatusOK, recorder.Code) {
+		t.FailNow()
+	}
+
+	var revoked struct {
+		LicenceId string `json:"licence_id"`
+		Status    string `json:"status"`
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &revoked)
+	assert.NoError(t, err)
+	assert.Equal(t, loan.LicenceId, revoked.LicenceId)
+	assert.Equal(t, lcp.StatusRevoked, revoked.Status)
+
+	// the transaction is marked as revoked in the store
+	storedLoan, err := testapi.Store.GetTransactionByLicence(loan.LicenceId)
+	assert.NoError(t, err)
+	assert.Equal(t, lcp.StatusRevoked, storedLoan.Status)
+	assert.NotNil(t, storedLoan.EndDate)
+
+	// clean up test data
+	err = testapi.Store.DeleteTransaction(loan)
+	assert.NoError(t, err)
+	err = testapi.Store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = testapi.Store.DeleteUser(user)
+	assert.NoError(t, err)
+}
+
+func TestGetFreshLicense(t *testing.T) {
+	// Initialize the router
+	r := chi.NewRouter()
+	r.Group(testapi.Router)
+
+	// init a user and a publication directly in the store
+	user := &stor.User{
+		Name:       "Ada Lovelace",
+		Email:      gofakeit.Email(),
+		Password:   "password",
+		TextHint:   "hint",
+		Passphrase: "passphrase",
+	}
+	err := testapi.Store.CreateUser(user)
+	assert.NoError(t, err)
+
+	publication := &stor.Publication{
+		UUID:  gofakeit.UUID(),
+		Title: "Test Publication",
+	}
+	err = testapi.Store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	// purchase the publication from the fake license provider
+	licenseReq := lcp.NewLicenseRequest(publication.UUID, user, 10, 1000, time.Time{}, time.Time{})
+	licence, err := testapi.LCP.GenerateLicense(context.Background(), licenseReq)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	purchase := &stor.Transaction{
+		UserID:        user.ID,
+		PublicationID: publication.ID,
+		Type:          stor.TransactionTypePurchase,
+	}
+	err = lcp.RecordLicense(purchase, licence)
+	assert.NoError(t, err)
+	err = testapi.Store.CreateTransaction(purchase)
+	assert.NoError(t, err)
+
+	// an unknown license is not found
+	req := httptest.NewRequest("GET", "/licenses/"+gofakeit.UUID(), nil)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	// get a fresh license
+	req = httptest.NewRequest("GET", "/licenses/"+purchase.LicenceId, nil)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	if !assert.Equal(t, http.StatusOK, recorder.Code) {
+		t.FailNow()
+	}
+	fresh, err := lcp.ParseLicense(recorder.Body.Bytes())
+	assert.NoError(t, err)
+	assert.NoError(t, fresh.Validate())
+	assert.Equal(t, purchase.LicenceId, fresh.ID)
+	assert.Equal(t, 10, *fresh.Rights.Print)
+	assert.NoError(t, lcp.VerifySignature(recorder.Body.Bytes(), nil))
+
+	// clean up test data
+	err = testapi.Store.DeleteTransaction(purchase)
+	assert.NoError(t, err)
+	err = testapi.Store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = testapi.Store.DeleteUser(user)
+	assert.NoError(t, err)
+}
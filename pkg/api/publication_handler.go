@@ -7,8 +7,14 @@ package api
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"github.com/edrlab/pubstore/pkg/cover"
+	"github.com/edrlab/pubstore/pkg/ingest"
+	"github.com/edrlab/pubstore/pkg/onix"
 	"github.com/edrlab/pubstore/pkg/stor"
 	"github.com/go-chi/render"
 )
@@ -43,6 +49,54 @@ func (a *Api) createPublication(w http.ResponseWriter, r *http.Request) {
 	}
 	fmt.Println("Publication created successfully")
 
+	a.fetchCover(r, publication)
+
+	render.Status(r, http.StatusCreated)
+	if err := render.Render(w, r, NewPublicationResponse(publication)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// @Summary Upload a publication package
+// @Description Create a publication from an EPUB, PDF, audiobook or Divina package, sent as the "file" field of a multipart form.
+// @Description The metadata and cover are extracted from the package, which is then encrypted.
+// @Tags publications
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "Publication package"
+// @Success 201 {object} Publication "Publication created successfully"
+// @Failure 400 {object} ErrorResponse "Missing or unsupported package"
+// @Failure 409 {object} ErrorResponse "The publication already exists"
+// @Failure 500 {object} ErrorResponse "Failed to ingest the package"
+// @Router /publications/upload [post]
+
+// uploadPublication creates a Publication from an uploaded package.
+func (a *Api) uploadPublication(w http.ResponseWriter, r *http.Request) {
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.Config.Ingest.MaxSize<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	defer file.Close()
+
+	publication, err := a.Ingester.Ingest(r.Context(), file, header.Filename)
+	if errors.Is(err, ingest.ErrUnsupportedPackage) {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if errors.Is(err, ingest.ErrPublicationExists) {
+		render.Render(w, r, ErrConflict(err))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	fmt.Println("Publication " + publication.UUID + " ingested successfully")
+
 	render.Status(r, http.StatusCreated)
 	if err := render.Render(w, r, NewPublicationResponse(publication)); err != nil {
 		render.Render(w, r, ErrRender(err))
@@ -50,6 +104,45 @@ func (a *Api) createPublication(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// @Summary Import an ONIX feed
+// @Description Create or update a publication for each product of an ONIX 3.0 message, identified by its ISBN.
+// @Description The message is the request body, or the "file" field of a multipart form.
+// @Tags publications
+// @Accept xml
+// @Accept multipart/form-data
+// @Produce json
+// @Success 200 {object} onix.Report "Created, updated and rejected records"
+// @Failure 400 {object} ErrorResponse "Malformed ONIX message"
+// @Router /publications/onix [post]
+
+// importOnix creates or updates Publications from an ONIX message.
+func (a *Api) importOnix(w http.ResponseWriter, r *http.Request) {
+
+	r.Body = http.MaxBytesReader(w, r.Body, a.Config.Ingest.MaxSize<<20)
+	var body io.Reader = r.Body
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			render.Render(w, r, ErrInvalidRequest(err))
+			return
+		}
+		defer file.Close()
+		body = file
+	}
+
+	report, err := a.Onix.Import(r.Context(), body)
+	if err != nil {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	fmt.Printf("ONIX import: %d created, %d updated, %d rejected\n", report.Created, report.Updated, report.Rejected)
+
+	if err := render.Render(w, r, &OnixReportResponse{Report: report}); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
 // @Summary Get a publication by ID
 // @Description Retrieve a publication by its ID
 // @Tags publications
@@ -107,6 +200,8 @@ func (a *Api) updatePublication(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	a.fetchCover(r, publication)
+
 	if err := render.Render(w, r, NewPublicationResponse(publication)); err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
@@ -118,50 +213,122 @@ func (a *Api) listPublications(w http.ResponseWriter, r *http.Request) {
 
 	pg := fromPaginateContext(r.Context())
 
+	if r.URL.Query().Has("cursor") {
+		a.findPublicationsByCursor(w, r, &stor.PublicationQuery{Order: r.URL.Query().Get("order")}, pg)
+		return
+	}
+
 	publications, err := a.Store.ListPublications(pg.Page, pg.PageSize)
 	if err != nil {
 		render.Render(w, r, ErrServer(err))
 		return
 	}
+	total, err := a.Store.CountPublications()
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	a.setPaginationHeaders(w, r, pg, total)
 	if err := render.RenderList(w, r, NewPublicationListResponse(publications)); err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
 	}
 }
 
-// searchPublications searches publications corresponding to a specific criteria.
+// contentTypes maps the formats accepted as search criteria to content types
+var contentTypes = map[string]string{
+	"epub":      "application/epub+zip",
+	"pdf":       "application/pdf+lcp",
+	"audiobook": "application/audiobook+lcp",
+	"divina":    "application/divina+lcp",
+}
+
+// @Summary Search publications
+// @Description Search publications matching every given criterion, sorted and paginated.
+// @Description The total number of matching publications is returned in X-Total-Count, links to other pages in Link.
+// @Tags publications
+// @Produce json
+// @Param q query string false "full-text search in titles, authors, subjects and descriptions"
+// @Param format query string false "epub, pdf, audiobook or divina"
+// @Param author query string false "author name"
+// @Param publisher query string false "publisher name"
+// @Param language query string false "language code"
+// @Param category query string false "category name"
+// @Param title query string false "part of the title"
+// @Param sort query string false "title, date_published or updated_at; relevance (with q) or updated_at by default"
+// @Param order query string false "asc or desc; asc for titles and desc for dates by default, asc with a cursor"
+// @Param page query int false "page number, from 1"
+// @Param pageSize query int false "page size"
+// @Param cursor query string false "position from which to list publications, sorted by updated_at in ascending order by default; empty for the first page"
+// @Success 200 {array} stor.Publication "Publications"
+// @Failure 400 {object} ErrorResponse "Invalid format, sort, order or cursor"
+// @Router /publications/search [get]
+
+// searchPublications searches publications matching every given criterion.
 func (a *Api) searchPublications(w http.ResponseWriter, r *http.Request) {
-	var publications []stor.Publication
-	var err error
 
 	pg := fromPaginateContext(r.Context())
-
-	// by format
-	if format := r.URL.Query().Get("format"); format != "" {
-		var contentType string
-		switch format {
-		case "epub":
-			contentType = "application/epub+zip"
-		case "pdf":
-			contentType = "application/pdf+lcp"
-		case "audiobook":
-			contentType = "application/audiobook+lcp"
-		case "divina":
-			contentType = "application/divina+lcp"
-		default:
-			err = errors.New("invalid content type query string parameter")
-		}
-		if contentType != "" {
-			publications, err = a.Store.FindPublicationsByType(contentType, pg.Page, pg.PageSize)
+	params := r.URL.Query()
+
+	query := &stor.PublicationQuery{
+		Author:    params.Get("author"),
+		Publisher: params.Get("publisher"),
+		Language:  params.Get("language"),
+		Category:  params.Get("category"),
+		Title:     params.Get("title"),
+		Text:      params.Get("q"),
+		Sort:      params.Get("sort"),
+		Order:     params.Get("order"),
+	}
+	if format := params.Get("format"); format != "" {
+		var ok bool
+		if query.ContentType, ok = contentTypes[format]; !ok {
+			render.Render(w, r, ErrInvalidRequest(errors.New("invalid format: expected epub, pdf, audiobook or divina")))
+			return
 		}
-	} else {
-		render.Render(w, r, ErrNotFound)
+	}
+
+	if params.Has("cursor") {
+		a.findPublicationsByCursor(w, r, query, pg)
+		return
+	}
+
+	result, err := a.Store.QueryPublications(query, pg.Page, pg.PageSize)
+	if errors.Is(err, stor.ErrInvalidSort) {
+		render.Render(w, r, ErrInvalidRequest(err))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	a.setPaginationHeaders(w, r, pg, result.Total)
+	if err := render.RenderList(w, r, NewPublicationListResponse(result.Publications)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// findPublicationsByCursor renders the publications matching a query from the position of the cursor query parameter,
+// sorted by last update in ascending order unless the query says otherwise, with a link to the next page in the Link header.
+func (a *Api) findPublicationsByCursor(w http.ResponseWriter, r *http.Request, query *stor.PublicationQuery, pg *Pagination) {
+
+	publications, next, err := a.Store.FindPublicationsByCursor(query, r.URL.Query().Get("cursor"), pg.PageSize)
+	if errors.Is(err, stor.ErrInvalidCursor) || errors.Is(err, stor.ErrInvalidSort) {
+		render.Render(w, r, ErrInvalidRequest(err))
 		return
 	}
 	if err != nil {
-		render.Render(w, r, ErrNotFound)
+		render.Render(w, r, ErrServer(err))
 		return
 	}
+	if next != "" {
+		params := r.URL.Query()
+		params.Set("cursor", next)
+		params.Set("pageSize", strconv.Itoa(pg.PageSize))
+		params.Del("page")
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, a.Config.PublicBaseUrl+r.URL.Path+"?"+params.Encode()))
+	}
 	if err := render.RenderList(w, r, NewPublicationListResponse(publications)); err != nil {
 		render.Render(w, r, ErrRender(err))
 		return
@@ -190,11 +357,22 @@ func (a *Api) deletePublication(w http.ResponseWriter, r *http.Request) {
 		render.Render(w, r, ErrServer(err))
 		return
 	}
+	if err = cover.Remove(a.Config.Resources, publication.UUID); err != nil {
+		fmt.Println("Failed to remove the covers of " + publication.UUID + ": " + err.Error())
+	}
 
 	// return a simple ok status
 	w.WriteHeader(http.StatusOK)
 }
 
+// fetchCover stores the cover of a publication referenced by an external URL.
+// If the cover cannot be fetched, the external URL is kept.
+func (a *Api) fetchCover(r *http.Request, publication *stor.Publication) {
+	if err := cover.Fetch(r.Context(), a.Config, a.Store, publication); err != nil {
+		fmt.Println("Failed to store the cover of " + publication.UUID + ": " + err.Error())
+	}
+}
+
 // --
 // Request and Response payloads for the REST api.
 // --
@@ -216,6 +394,15 @@ type PublicationResponse struct {
 	DeletedAt omit `json:"DeletedAt,omitempty"`
 }
 
+// OnixReportResponse is the response payload of an ONIX import.
+type OnixReportResponse struct {
+	*onix.Report
+}
+
+func (rep *OnixReportResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
+
 // NewPublicationListResponse creates a rendered list of publications
 func NewPublicationListResponse(publications []stor.Publication) []render.Renderer {
 	list := []render.Renderer{}
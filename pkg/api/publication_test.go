@@ -5,11 +5,18 @@
 package api
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/json"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -182,6 +189,39 @@ func TestPublicationHandler(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 2, len(retrievedPubs))
 
+	// list publications from a cursor
+	req = httptest.NewRequest("GET", "/api/publications?cursor=&pageSize=1", nil)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	if !assert.Equal(t, http.StatusOK, recorder.Code) {
+		t.FailNow()
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &retrievedPubs)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(retrievedPubs))
+	link := recorder.Header().Get("Link")
+	if assert.Contains(t, link, `rel="next"`) {
+		next, err := url.Parse(strings.TrimPrefix(strings.Split(link, ">")[0], "<"))
+		assert.NoError(t, err)
+		req = httptest.NewRequest("GET", "/api/publications?"+next.RawQuery, nil)
+		recorder = httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		assert.Empty(t, recorder.Header().Get("Link"))
+		var nextPubs []stor.Publication
+		err = json.Unmarshal(recorder.Body.Bytes(), &nextPubs)
+		assert.NoError(t, err)
+		if assert.Equal(t, 1, len(nextPubs)) {
+			assert.NotEqual(t, retrievedPubs[0].UUID, nextPubs[0].UUID)
+		}
+	}
+
+	// invalid cursor
+	req = httptest.NewRequest("GET", "/api/publications?cursor=invalid", nil)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
 	// search publications
 	req = httptest.NewRequest("GET", "/api/publications/search?format=epub&page=1&pageSize=5", bytes.NewBuffer([]byte(pubBytes)))
 	req.Header.Set("Authorization", "Bearer "+tokenResp.Token)
@@ -195,6 +235,45 @@ func TestPublicationHandler(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, 1, len(retrievedPubs))
 
+	// full-text search publications
+	req = httptest.NewRequest("GET", "/api/publications/search?q=UPDATE+tes&page=1&pageSize=5", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenResp.Token)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	if !assert.Equal(t, http.StatusOK, recorder.Code) {
+		t.FailNow()
+	}
+
+	err = json.Unmarshal(recorder.Body.Bytes(), &retrievedPubs)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(retrievedPubs)) {
+		assert.Equal(t, "Update Test Publication", retrievedPubs[0].Title)
+	}
+
+	// search with several criteria, sorted, with pagination headers
+	req = httptest.NewRequest("GET", "/api/publications/search?q=test&title=publication&sort=title&order=desc&page=1&pageSize=1", nil)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	if !assert.Equal(t, http.StatusOK, recorder.Code) {
+		t.FailNow()
+	}
+	assert.Equal(t, "2", recorder.Header().Get("X-Total-Count"))
+	assert.Contains(t, recorder.Header().Get("Link"), `page=2&pageSize=1&q=test&sort=title&title=publication>; rel="next"`)
+	assert.Contains(t, recorder.Header().Get("Link"), `rel="last"`)
+	assert.NotContains(t, recorder.Header().Get("Link"), `rel="prev"`)
+
+	err = json.Unmarshal(recorder.Body.Bytes(), &retrievedPubs)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(retrievedPubs)) {
+		assert.Equal(t, "Update Test Publication", retrievedPubs[0].Title)
+	}
+
+	// invalid sort
+	req = httptest.NewRequest("GET", "/api/publications/search?sort=copies", nil)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
 	// delete the publication
 	deleteUserURL := "/api/publications/" + newPublication.UUID
 	req = httptest.NewRequest("DELETE", deleteUserURL, nil)
@@ -211,3 +290,375 @@ func TestPublicationHandler(t *testing.T) {
 	err = testapi.Store.DeleteUser(newUser)
 	assert.NoError(t, err)
 }
+
+func TestUploadPublication(t *testing.T) {
+	// Initialize the router
+	r := chi.NewRouter()
+	r.Group(testapi.Router)
+
+	uploadDir := testapi.Config.Ingest.UploadDir
+	testapi.Config.Ingest.UploadDir = t.TempDir()
+	testapi.Config.Ingest.MaxSize = 10
+	resources := testapi.Config.Resources
+	testapi.Config.Resources = t.TempDir()
+	defer func() {
+		testapi.Config.Ingest.UploadDir = uploadDir
+		testapi.Config.Resources = resources
+	}()
+
+	// a 400x800 JPEG cover
+	img := image.NewRGBA(image.Rect(0, 0, 400, 800))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{200, 50, 50, 255}), image.Point{}, draw.Src)
+	var coverImage bytes.Buffer
+	assert.NoError(t, jpeg.Encode(&coverImage, img, nil))
+
+	// build an EPUB 3 package
+	pubUUID := gofakeit.UUID()
+	var epub bytes.Buffer
+	zw := zip.NewWriter(&epub)
+	files := map[string]string{
+		"mimetype": "application/epub+zip",
+		"META-INF/container.xml": `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="uid">urn:uuid:` + pubUUID + `</dc:identifier>
+    <dc:title>Uploaded Publication</dc:title>
+    <dc:creator id="author">Author U</dc:creator>
+    <meta refines="#author" property="role" scheme="marc:relators">aut</meta>
+    <dc:creator id="illustrator">Illustrator U</dc:creator>
+    <meta refines="#illustrator" property="role" scheme="marc:relators">ill</meta>
+    <dc:publisher>Publisher U</dc:publisher>
+    <dc:language>en-GB</dc:language>
+    <dc:subject>Subject U</dc:subject>
+    <dc:description>Uploaded description</dc:description>
+    <dc:date>2023-05-01</dc:date>
+  </metadata>
+  <manifest>
+    <item id="cover" href="images/cover.jpg" media-type="image/jpeg" properties="cover-image"/>
+  </manifest>
+</package>`,
+		"OEBPS/images/cover.jpg": coverImage.String(),
+	}
+	for name, content := range files {
+		fw, err := zw.Create(name)
+		assert.NoError(t, err)
+		_, err = fw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zw.Close())
+
+	upload := func(token string, content []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, err := mw.CreateFormFile("file", "book.epub")
+		assert.NoError(t, err)
+		_, err = fw.Write(content)
+		assert.NoError(t, err)
+		assert.NoError(t, mw.Close())
+
+		req := httptest.NewRequest("POST", "/api/publications/upload", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	// try uploading with no token
+	recorder := upload("", epub.Bytes())
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	// generate a bearer token
+	user := &stor.User{
+		Name:       "Uploader",
+		Email:      gofakeit.Email(),
+		Password:   "password",
+		TextHint:   "hint",
+		Passphrase: "passphrase",
+	}
+	err := testapi.CreateUser(user)
+	assert.NoError(t, err)
+	tokenData := url.Values{
+		"grant_type": {"password"},
+		"username":   {user.Email},
+		"password":   {user.Password},
+	}
+	tokenReq := httptest.NewRequest("POST", "/api/token", strings.NewReader(tokenData.Encode()))
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenRecorder := httptest.NewRecorder()
+	r.ServeHTTP(tokenRecorder, tokenReq)
+	var tokenResp struct {
+		Token string `json:"access_token"`
+	}
+	err = json.Unmarshal(tokenRecorder.Body.Bytes(), &tokenResp)
+	assert.NoError(t, err)
+
+	// a file which is not a package is rejected
+	recorder = upload(tokenResp.Token, []byte("not a package"))
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// upload the EPUB
+	recorder = upload(tokenResp.Token, epub.Bytes())
+	if !assert.Equal(t, http.StatusCreated, recorder.Code) {
+		t.FailNow()
+	}
+
+	publication, err := testapi.Store.GetPublication(pubUUID)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "Uploaded Publication", publication.Title)
+	assert.Equal(t, "application/epub+zip", publication.ContentType)
+	assert.Equal(t, "Uploaded description", publication.Description)
+	assert.Equal(t, "2023-05-01", publication.DatePublished)
+	if assert.Len(t, publication.Author, 1) {
+		assert.Equal(t, "Author U", publication.Author[0].Name)
+	}
+	if assert.Len(t, publication.Language, 1) {
+		assert.Equal(t, "en", publication.Language[0].Code)
+	}
+	if assert.Len(t, publication.Publisher, 1) {
+		assert.Equal(t, "Publisher U", publication.Publisher[0].Name)
+	}
+	if assert.Len(t, publication.Category, 1) {
+		assert.Equal(t, "Subject U", publication.Category[0].Name)
+	}
+
+	// the package is kept in the upload directory
+	assert.FileExists(t, filepath.Join(testapi.Config.Ingest.UploadDir, pubUUID+".epub"))
+
+	// the cover is stored in the resources directory, with a medium and a thumbnail size
+	assert.True(t, strings.HasPrefix(publication.CoverUrl, testapi.Config.PublicBaseUrl+"/resources/"+pubUUID+"/"))
+	if assert.Len(t, publication.Covers, 3) {
+		sizes := map[string][2]int{}
+		for _, c := range publication.Covers {
+			sizes[c.Size] = [2]int{c.Width, c.Height}
+			assert.FileExists(t, filepath.Join(testapi.Config.Resources, filepath.FromSlash(c.Path)))
+		}
+		assert.Equal(t, [2]int{400, 800}, sizes[stor.CoverSizeOriginal])
+		assert.Equal(t, [2]int{300, 600}, sizes[stor.CoverSizeMedium])
+		assert.Equal(t, [2]int{120, 240}, sizes[stor.CoverSizeThumbnail])
+	}
+
+	// the same package cannot be uploaded twice
+	recorder = upload(tokenResp.Token, epub.Bytes())
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+
+	// clean up test data
+	err = testapi.Store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = testapi.Store.DeleteUser(user)
+	assert.NoError(t, err)
+}
+
+func TestImportOnix(t *testing.T) {
+	// Initialize the router
+	r := chi.NewRouter()
+	r.Group(testapi.Router)
+	testapi.Config.Ingest.MaxSize = 10
+
+	// generate a bearer token
+	user := &stor.User{
+		Name:       "Importer",
+		Email:      gofakeit.Email(),
+		Password:   "password",
+		TextHint:   "hint",
+		Passphrase: "passphrase",
+	}
+	err := testapi.CreateUser(user)
+	assert.NoError(t, err)
+	tokenData := url.Values{
+		"grant_type": {"password"},
+		"username":   {user.Email},
+		"password":   {user.Password},
+	}
+	tokenReq := httptest.NewRequest("POST", "/api/token", strings.NewReader(tokenData.Encode()))
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenRecorder := httptest.NewRecorder()
+	r.ServeHTTP(tokenRecorder, tokenReq)
+	var tokenResp struct {
+		Token string `json:"access_token"`
+	}
+	err = json.Unmarshal(tokenRecorder.Body.Bytes(), &tokenResp)
+	assert.NoError(t, err)
+
+	type report struct {
+		Created  int `json:"created"`
+		Updated  int `json:"updated"`
+		Rejected int `json:"rejected"`
+		Records  []struct {
+			ISBN   string `json:"isbn"`
+			UUID   string `json:"uuid"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"records"`
+	}
+	importOnix := func(message string) (*httptest.ResponseRecorder, report) {
+		req := httptest.NewRequest("POST", "/api/publications/onix", strings.NewReader(message))
+		req.Header.Set("Content-Type", "application/xml")
+		req.Header.Set("Authorization", "Bearer "+tokenResp.Token)
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		var rep report
+		if recorder.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &rep))
+		}
+		return recorder, rep
+	}
+
+	// an ONIX message with reference tags: an EPUB, a hardback and a product with an invalid ISBN
+	isbn := "9780306406157"
+	message := `<?xml version="1.0" encoding="UTF-8"?>
+<ONIXMessage release="3.0" xmlns="http://ns.editeur.org/onix/3.0/reference">
+  <Header><Sender><SenderName>Publisher O</SenderName></Sender></Header>
+  <Product>
+    <RecordReference>ref-1</RecordReference>
+    <NotificationType>03</NotificationType>
+    <ProductIdentifier><ProductIDType>15</ProductIDType><IDValue>` + isbn + `</IDValue></ProductIdentifier>
+    <DescriptiveDetail>
+      <ProductComposition>00</ProductComposition>
+      <ProductForm>ED</ProductForm>
+      <ProductFormDetail>E101</ProductFormDetail>
+      <TitleDetail>
+        <TitleType>01</TitleType>
+        <TitleElement><TitleElementLevel>01</TitleElementLevel><TitleText>Imported Publication</TitleText><Subtitle>A Novel</Subtitle></TitleElement>
+      </TitleDetail>
+      <Contributor><SequenceNumber>2</SequenceNumber><ContributorRole>B06</ContributorRole><PersonName>Translator O</PersonName></Contributor>
+      <Contributor><SequenceNumber>1</SequenceNumber><ContributorRole>A01</ContributorRole><NamesBeforeKey>Author</NamesBeforeKey><KeyNames>O</KeyNames></Contributor>
+      <Language><LanguageRole>01</LanguageRole><LanguageCode>fre</LanguageCode></Language>
+      <Subject><MainSubject/><SubjectSchemeIdentifier>10</SubjectSchemeIdentifier><SubjectCode>FIC000000</SubjectCode><SubjectHeadingText>FICTION / General</SubjectHeadingText></Subject>
+      <Subject><SubjectSchemeIdentifier>93</SubjectSchemeIdentifier><SubjectCode>FBA</SubjectCode></Subject>
+      <Subject><SubjectSchemeIdentifier>20</SubjectSchemeIdentifier><SubjectHeadingText>keyword</SubjectHeadingText></Subject>
+    </DescriptiveDetail>
+    <CollateralDetail>
+      <TextContent><TextType>03</TextType><ContentAudience>00</ContentAudience>
+        <Text textformat="05"><p xmlns="http://www.w3.org/1999/xhtml">First <em>paragraph</em>.</p><p xmlns="http://www.w3.org/1999/xhtml">Second paragraph.</p></Text>
+      </TextContent>
+    </CollateralDetail>
+    <PublishingDetail>
+      <Imprint><ImprintName>Imprint O</ImprintName></Imprint>
+      <Publisher><PublishingRole>01</PublishingRole><PublisherName>Publisher O</PublisherName></Publisher>
+      <PublishingDate><PublishingDateRole>01</PublishingDateRole><Date dateformat="00">20230501</Date></PublishingDate>
+    </PublishingDetail>
+  </Product>
+  <Product>
+    <RecordReference>ref-2</RecordReference>
+    <NotificationType>03</NotificationType>
+    <ProductIdentifier><ProductIDType>15</ProductIDType><IDValue>9781861972712</IDValue></ProductIdentifier>
+    <DescriptiveDetail>
+      <ProductForm>BB</ProductForm>
+      <TitleDetail><TitleType>01</TitleType><TitleElement><TitleElementLevel>01</TitleElementLevel><TitleText>Hardback</TitleText></TitleElement></TitleDetail>
+    </DescriptiveDetail>
+  </Product>
+  <Product>
+    <RecordReference>ref-3</RecordReference>
+    <NotificationType>03</NotificationType>
+    <ProductIdentifier><ProductIDType>15</ProductIDType><IDValue>9780306406158</IDValue></ProductIdentifier>
+    <DescriptiveDetail>
+      <ProductForm>ED</ProductForm>
+      <TitleDetail><TitleType>01</TitleType><TitleElement><TitleElementLevel>01</TitleElementLevel><TitleText>Invalid ISBN</TitleText></TitleElement></TitleDetail>
+    </DescriptiveDetail>
+  </Product>
+</ONIXMessage>`
+
+	// try importing with no token
+	req := httptest.NewRequest("POST", "/api/publications/onix", strings.NewReader(message))
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	// import the message
+	recorder, rep := importOnix(message)
+	if !assert.Equal(t, http.StatusOK, recorder.Code) {
+		t.FailNow()
+	}
+	assert.Equal(t, 1, rep.Created)
+	assert.Equal(t, 0, rep.Updated)
+	assert.Equal(t, 2, rep.Rejected)
+	if assert.Len(t, rep.Records, 3) {
+		assert.Equal(t, "created", rep.Records[0].Status)
+		assert.Equal(t, "rejected", rep.Records[1].Status)
+		assert.Contains(t, rep.Records[1].Error, "not a digital product")
+		assert.Equal(t, "rejected", rep.Records[2].Status)
+	}
+
+	publication, err := testapi.Store.GetPublicationByISBN(isbn)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, rep.Records[0].UUID, publication.UUID)
+	assert.Equal(t, "Imported Publication: A Novel", publication.Title)
+	assert.Equal(t, "application/epub+zip", publication.ContentType)
+	assert.Equal(t, "First paragraph.\nSecond paragraph.", publication.Description)
+	assert.Equal(t, "2023-05-01", publication.DatePublished)
+	if assert.Len(t, publication.Author, 1) {
+		assert.Equal(t, "Author O", publication.Author[0].Name)
+	}
+	if assert.Len(t, publication.Language, 1) {
+		assert.Equal(t, "fr", publication.Language[0].Code)
+	}
+	if assert.Len(t, publication.Publisher, 1) {
+		assert.Equal(t, "Publisher O", publication.Publisher[0].Name)
+	}
+	assert.Len(t, publication.Category, 2)
+
+	// the same ISBN, with short tags, updates the publication and replaces its authors
+	message = `<?xml version="1.0" encoding="UTF-8"?>
+<ONIXmessage release="3.0" xmlns="http://ns.editeur.org/onix/3.0/short">
+  <product>
+    <a001>ref-1</a001>
+    <a002>04</a002>
+    <productidentifier><b221>15</b221><b244>` + isbn + `</b244></productidentifier>
+    <descriptivedetail>
+      <b012>ED</b012>
+      <titledetail><b202>01</b202><titleelement><x409>01</x409><b203>Updated Publication</b203></titleelement></titledetail>
+      <contributor><b034>1</b034><b035>A01</b035><b036>Author P</b036></contributor>
+      <language><b253>01</b253><b252>eng</b252></language>
+    </descriptivedetail>
+    <collateraldetail>
+      <textcontent><x426>03</x426><d104 textformat="02">&lt;p&gt;Updated &amp;amp; escaped.&lt;/p&gt;</d104></textcontent>
+    </collateraldetail>
+    <publishingdetail>
+      <publisher><b291>01</b291><b081>Publisher O</b081></publisher>
+    </publishingdetail>
+  </product>
+</ONIXmessage>`
+	recorder, rep = importOnix(message)
+	if !assert.Equal(t, http.StatusOK, recorder.Code) {
+		t.FailNow()
+	}
+	assert.Equal(t, 0, rep.Created)
+	assert.Equal(t, 1, rep.Updated)
+
+	updated, err := testapi.Store.GetPublicationByISBN(isbn)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, publication.UUID, updated.UUID)
+	assert.Equal(t, "Updated Publication", updated.Title)
+	assert.Equal(t, "application/epub+zip", updated.ContentType)
+	assert.Equal(t, "Updated & escaped.", updated.Description)
+	if assert.Len(t, updated.Author, 1) {
+		assert.Equal(t, "Author P", updated.Author[0].Name)
+	}
+	if assert.Len(t, updated.Language, 1) {
+		assert.Equal(t, "en", updated.Language[0].Code)
+	}
+	assert.Len(t, updated.Category, 0)
+
+	// a malformed message is rejected
+	recorder, _ = importOnix("<ONIXMessage><Product><RecordReference>")
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// clean up test data
+	err = testapi.Store.DeletePublication(updated)
+	assert.NoError(t, err)
+	err = testapi.Store.DeleteUser(user)
+	assert.NoError(t, err)
+}
@@ -7,13 +7,18 @@ package api
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/edrlab/pubstore/pkg/conf"
 	_ "github.com/edrlab/pubstore/pkg/docs"
+	"github.com/edrlab/pubstore/pkg/ingest"
 	"github.com/edrlab/pubstore/pkg/internal/auth"
+	"github.com/edrlab/pubstore/pkg/lcp"
+	"github.com/edrlab/pubstore/pkg/onix"
 	"github.com/edrlab/pubstore/pkg/stor"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -26,12 +31,18 @@ import (
 type Api struct {
 	*conf.Config
 	*stor.Store
+	LCP      lcp.LicenseProvider
+	Ingester *ingest.Ingester
+	Onix     *onix.Importer
 }
 
-func Init(c *conf.Config, s *stor.Store) Api {
+func Init(c *conf.Config, s *stor.Store, lp lcp.LicenseProvider) Api {
 	return Api{
-		Config: c,
-		Store:  s,
+		Config:   c,
+		Store:    s,
+		LCP:      lp,
+		Ingester: ingest.New(c, s),
+		Onix:     onix.New(c, s),
 	}
 }
 
@@ -64,7 +75,7 @@ func (a *Api) Router(r chi.Router) {
 		AllowedOrigins:   []string{"*"},
 		AllowedMethods:   []string{"GET", "PUT", "POST", "DELETE", "HEAD", "OPTION"},
 		AllowedHeaders:   []string{"User-Agent", "Content-Type", "Accept", "Accept-Encoding", "Accept-Language", "Cache-Control", "Connection", "DNT", "Host", "Origin", "Pragma", "Referer"},
-		ExposedHeaders:   []string{"Link"},
+		ExposedHeaders:   []string{"Link", "X-Total-Count"},
 		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
@@ -87,6 +98,24 @@ func (a *Api) Router(r chi.Router) {
 		r.Post("/", a.createPublication)
 	})
 
+	// Bulk import and export of publications and users, using the basic auth credentials of the administrator,
+	// as users may be exported and imported with the hashes of their password and passphrase.
+	// License revocation is also restricted to the administrator.
+	if a.Config.AdminUserName != "" && a.Config.AdminPassword != "" {
+		adminCredentials := map[string]string{a.Config.AdminUserName: a.Config.AdminPassword}
+		r.Route("/api/bulk/{entity}", func(r chi.Router) {
+			r.Use(middleware.BasicAuth("admin", adminCredentials))
+			r.Get("/", a.exportBulk)
+			r.Post("/", a.importBulk)
+		})
+		r.Route("/api/licenses/{id}", func(r chi.Router) {
+			r.Use(middleware.BasicAuth("admin", adminCredentials))
+			r.Use(render.SetContentType(render.ContentTypeJSON))
+			r.Use(a.licenseId)
+			r.Put("/", a.revokeLicense)
+		})
+	}
+
 	/*
 		 Generate a token using username & password
 			POST http://localhost:8080/api/token
@@ -109,6 +138,8 @@ func (a *Api) Router(r chi.Router) {
 		r.Group(func(r chi.Router) {
 			r.Use(oauth.Authorize(a.Config.OAuthSeed, nil))
 			r.Post("/", a.createPublication)
+			r.Post("/upload", a.uploadPublication)
+			r.Post("/onix", a.importOnix)
 		})
 		r.Route("/{id}", func(r chi.Router) {
 			r.Use(a.publicationId)
@@ -138,6 +169,12 @@ func (a *Api) Router(r chi.Router) {
 		})
 	})
 
+	r.Route("/api/transactions", func(r chi.Router) {
+		r.Use(render.SetContentType(render.ContentTypeJSON))
+		r.Use(oauth.Authorize(a.Config.OAuthSeed, nil))
+		r.With(paginate).Get("/", a.listTransactions)
+	})
+
 	// License gateway
 	r.Route("/licenses", func(r chi.Router) {
 		r.Use(render.SetContentType(render.ContentTypeJSON))
@@ -200,6 +237,34 @@ type Pagination struct {
 	PageSize int
 }
 
+// setPaginationHeaders sets the total number of items of a paginated response in the X-Total-Count header,
+// and links to the first, previous, next and last pages in the Link header (RFC 8288)
+func (a *Api) setPaginationHeaders(w http.ResponseWriter, r *http.Request, pg *Pagination, total int64) {
+
+	lastPage := int((total + int64(pg.PageSize) - 1) / int64(pg.PageSize))
+	if lastPage == 0 {
+		lastPage = 1
+	}
+	href := func(page int) string {
+		query := r.URL.Query()
+		query.Set("page", strconv.Itoa(page))
+		query.Set("pageSize", strconv.Itoa(pg.PageSize))
+		return a.Config.PublicBaseUrl + r.URL.Path + "?" + query.Encode()
+	}
+
+	links := []string{fmt.Sprintf(`<%s>; rel="first"`, href(1))}
+	if pg.Page > 1 {
+		links = append(links, fmt.Sprintf(`<%s>; rel="prev"`, href(min(pg.Page-1, lastPage))))
+	}
+	if pg.Page < lastPage {
+		links = append(links, fmt.Sprintf(`<%s>; rel="next"`, href(pg.Page+1)))
+	}
+	links = append(links, fmt.Sprintf(`<%s>; rel="last"`, href(lastPage)))
+
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
+
 // paginate middleware,
 func paginate(next http.Handler) http.Handler {
 
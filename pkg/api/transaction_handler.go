@@ -0,0 +1,86 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/go-chi/render"
+)
+
+// @Summary List transactions
+// @Description List transactions, optionally filtered by type (purchase, loan or subscription)
+// @Tags transactions
+// @Accept json
+// @Produce json
+// @Param type query string false "Transaction type"
+// @Success 200 {object} TransactionResponse
+// @Failure 400 {object} ErrorResponse "Invalid transaction type"
+// @Failure 500 {object} ErrorResponse "Internal server error"
+// @Router /transactions [get]
+func (a *Api) listTransactions(w http.ResponseWriter, r *http.Request) {
+	var transactions []stor.Transaction
+	var err error
+
+	pg := fromPaginateContext(r.Context())
+
+	switch transactionType := r.URL.Query().Get("type"); transactionType {
+	case "":
+		transactions, err = a.Store.ListTransactions(pg.Page, pg.PageSize)
+	case stor.TransactionTypePurchase, stor.TransactionTypeLoan, stor.TransactionTypeSubscription:
+		transactions, err = a.Store.FindTransactionsByType(transactionType, pg.Page, pg.PageSize)
+	default:
+		render.Render(w, r, ErrInvalidRequest(errors.New("invalid transaction type")))
+		return
+	}
+	if err != nil {
+		render.Render(w, r, ErrServer(err))
+		return
+	}
+	if err := render.RenderList(w, r, NewTransactionListResponse(transactions)); err != nil {
+		render.Render(w, r, ErrRender(err))
+		return
+	}
+}
+
+// --
+// Request and Response payloads for the REST api.
+// --
+
+// TransactionResponse is the response transaction payload.
+type TransactionResponse struct {
+	*stor.Transaction
+	UserUUID        string `json:"user_uuid"`
+	PublicationUUID string `json:"publication_uuid"`
+	// do not serialize the following properties
+	ID        omit `json:"ID,omitempty"`
+	UpdatedAt omit `json:"UpdatedAt,omitempty"`
+	DeletedAt omit `json:"DeletedAt,omitempty"`
+}
+
+// NewTransactionListResponse creates a rendered list of transactions
+func NewTransactionListResponse(transactions []stor.Transaction) []render.Renderer {
+	list := []render.Renderer{}
+	for i := 0; i < len(transactions); i++ {
+		list = append(list, NewTransactionResponse(&transactions[i]))
+	}
+	return list
+}
+
+// NewTransactionResponse creates a rendered transaction.
+func NewTransactionResponse(transaction *stor.Transaction) *TransactionResponse {
+	return &TransactionResponse{
+		Transaction:     transaction,
+		UserUUID:        transaction.User.UUID,
+		PublicationUUID: transaction.Publication.UUID,
+	}
+}
+
+// Render processes responses before marshalling.
+func (t *TransactionResponse) Render(w http.ResponseWriter, r *http.Request) error {
+	return nil
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionHandler(t *testing.T) {
+	// Initialize the router
+	r := chi.NewRouter()
+	r.Group(testapi.Router)
+
+	// init a user, a publication and two transactions, directly in the store
+	user := &stor.User{
+		Name:       "Louis XIV",
+		Email:      gofakeit.Email(),
+		Password:   "password",
+		TextHint:   "hint",
+		Passphrase: "passphrase",
+	}
+	err := testapi.Store.CreateUser(user)
+	assert.NoError(t, err)
+
+	publication := &stor.Publication{
+		UUID:  gofakeit.UUID(),
+		Title: "Test Publication",
+	}
+	err = testapi.Store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	purchase := &stor.Transaction{
+		UserID:        user.ID,
+		PublicationID: publication.ID,
+		LicenceId:     gofakeit.UUID(),
+		Type:          stor.TransactionTypePurchase,
+	}
+	err = testapi.Store.CreateTransaction(purchase)
+	assert.NoError(t, err)
+
+	loan := &stor.Transaction{
+		UserID:        user.ID,
+		PublicationID: publication.ID,
+		LicenceId:     gofakeit.UUID(),
+		Type:          stor.TransactionTypeLoan,
+	}
+	err = testapi.Store.CreateTransaction(loan)
+	assert.NoError(t, err)
+
+	// try listing transactions with no token
+	req := httptest.NewRequest("GET", "/api/transactions", nil)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	// generate a bearer token
+	tokenData := url.Values{
+		"grant_type": {"password"},
+		"username":   {user.Email},
+		"password":   {user.Password},
+	}
+	tokenReq := httptest.NewRequest("POST", "/api/token", strings.NewReader(tokenData.Encode()))
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	tokenRecorder := httptest.NewRecorder()
+	r.ServeHTTP(tokenRecorder, tokenReq)
+	if !assert.Equal(t, http.StatusOK, tokenRecorder.Code) {
+		t.FailNow()
+	}
+	var tokenResp struct {
+		Token string `json:"access_token"`
+	}
+	err = json.Unmarshal(tokenRecorder.Body.Bytes(), &tokenResp)
+	assert.NoError(t, err)
+
+	// list loans
+	req = httptest.NewRequest("GET", "/api/transactions?type=loan", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenResp.Token)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	if !assert.Equal(t, http.StatusOK, recorder.Code) {
+		t.FailNow()
+	}
+
+	var retrievedTransactions []struct {
+		LicenceId       string `json:"licence_id"`
+		Type            string `json:"type"`
+		UserUUID        string `json:"user_uuid"`
+		PublicationUUID string `json:"publication_uuid"`
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &retrievedTransactions)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(retrievedTransactions)) {
+		assert.Equal(t, loan.LicenceId, retrievedTransactions[0].LicenceId)
+		assert.Equal(t, stor.TransactionTypeLoan, retrievedTransactions[0].Type)
+		assert.Equal(t, user.UUID, retrievedTransactions[0].UserUUID)
+		assert.Equal(t, publication.UUID, retrievedTransactions[0].PublicationUUID)
+	}
+
+	// list all transactions
+	req = httptest.NewRequest("GET", "/api/transactions", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenResp.Token)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	err = json.Unmarshal(recorder.Body.Bytes(), &retrievedTransactions)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(retrievedTransactions))
+
+	// an invalid type is rejected
+	req = httptest.NewRequest("GET", "/api/transactions?type=gift", nil)
+	req.Header.Set("Authorization", "Bearer "+tokenResp.Token)
+	recorder = httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+
+	// clean up test data
+	err = testapi.Store.DeleteTransaction(purchase)
+	assert.NoError(t, err)
+	err = testapi.Store.DeleteTransaction(loan)
+	assert.NoError(t, err)
+	err = testapi.Store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = testapi.Store.DeleteUser(user)
+	assert.NoError(t, err)
+}
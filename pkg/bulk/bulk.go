@@ -0,0 +1,365 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package bulk imports and exports publications and users as JSON Lines or CSV.
+package bulk
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/edrlab/pubstore/pkg/stor"
+)
+
+// Formats of bulk files
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// Entities which can be imported and exported
+const (
+	EntityPublications = "publications"
+	EntityUsers        = "users"
+)
+
+// ErrUnknownFormat is returned for a format other than jsonl or csv
+var ErrUnknownFormat = errors.New("unknown format: expected jsonl or csv")
+
+// ErrUnknownCursor is returned when an export is resumed after an unknown record
+var ErrUnknownCursor = errors.New("unknown cursor")
+
+// ErrUnknownEntity is returned for an entity other than publications or users
+var ErrUnknownEntity = errors.New("unknown entity: expected publications or users")
+
+// listSeparator separates the values of a list in a CSV field, e.g. the authors of a publication
+const listSeparator = "|"
+
+// ContentType returns the media type of a format
+func ContentType(format string) string {
+	if format == FormatCSV {
+		return "text/csv; charset=utf-8"
+	}
+	return "application/x-ndjson"
+}
+
+// FormatFromContentType returns the format of a media type, jsonl by default
+func FormatFromContentType(contentType string) string {
+	if t, _, err := mime.ParseMediaType(contentType); err == nil && t == "text/csv" {
+		return FormatCSV
+	}
+	return FormatJSONL
+}
+
+// FormatFromName returns the format of a file from its extension, jsonl by default
+func FormatFromName(name string) string {
+	if strings.EqualFold(filepath.Ext(name), ".csv") {
+		return FormatCSV
+	}
+	return FormatJSONL
+}
+
+// LineError is the error of a line of an imported file
+type LineError struct {
+	Line  int    `json:"line"`
+	UUID  string `json:"uuid,omitempty"`
+	Error string `json:"error"`
+}
+
+// Report is the result of an import
+type Report struct {
+	DryRun   bool        `json:"dry_run"`
+	Lines    int         `json:"lines"`
+	Imported int         `json:"imported"`
+	Rejected int         `json:"rejected"`
+	Errors   []LineError `json:"errors"`
+}
+
+// Import creates or updates publications or users from a JSON Lines or CSV stream, in batches.
+// Lines which cannot be parsed or saved are rejected and reported, the others are imported.
+// If dryRun is true, every line is checked but nothing is saved.
+func Import(ctx context.Context, s *stor.Store, entity, format string, r io.Reader, dryRun bool) (*Report, error) {
+
+	var newRecord func() record
+	var save func(records []record) ([]error, error)
+	switch entity {
+	case EntityPublications:
+		newRecord = func() record { return &PublicationRecord{} }
+		save = func(records []record) ([]error, error) {
+			publications := make([]*stor.Publication, len(records))
+			for i, rec := range records {
+				publications[i] = rec.(*PublicationRecord).Publication()
+			}
+			return s.ImportPublications(publications, dryRun)
+		}
+	case EntityUsers:
+		newRecord = func() record { return &UserRecord{} }
+		save = func(records []record) ([]error, error) {
+			users := make([]*stor.User, len(records))
+			for i, rec := range records {
+				users[i] = rec.(*UserRecord).User()
+			}
+			return s.ImportUsers(users, dryRun)
+		}
+	default:
+		return nil, ErrUnknownEntity
+	}
+
+	var next func(rec record) (int, error)
+	switch format {
+	case FormatJSONL:
+		next = jsonlReader(r)
+	case FormatCSV:
+		next = csvReader(r)
+	default:
+		return nil, ErrUnknownFormat
+	}
+
+	report := &Report{DryRun: dryRun, Errors: []LineError{}}
+	var batch []record
+	var lines []int
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		errs, err := save(batch)
+		if err != nil {
+			return err
+		}
+		for i, e := range errs {
+			if e != nil {
+				report.Rejected++
+				report.Errors = append(report.Errors, LineError{Line: lines[i], UUID: batch[i].uuid(), Error: e.Error()})
+			} else {
+				report.Imported++
+			}
+		}
+		batch, lines = nil, nil
+		return nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		rec := newRecord()
+		line, err := next(rec)
+		if err == io.EOF {
+			break
+		}
+		var syntaxErr *lineSyntaxError
+		if errors.As(err, &syntaxErr) {
+			report.Lines++
+			report.Rejected++
+			report.Errors = append(report.Errors, LineError{Line: line, Error: syntaxErr.err.Error()})
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Lines++
+		batch = append(batch, rec)
+		lines = append(lines, line)
+		if len(batch) == stor.BatchSize {
+			if err = flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+	err := flush()
+
+	// syntax errors are reported before the errors of the batch they belong to
+	sort.SliceStable(report.Errors, func(i, j int) bool {
+		return report.Errors[i].Line < report.Errors[j].Line
+	})
+	return report, err
+}
+
+// Export writes every publication or user as JSON Lines or CSV, in creation order.
+// The export starts after the record whose UUID is after, if not empty, so that an interrupted export can be resumed.
+// Records are read and written in batches; w is flushed after each batch if it is an http.Flusher.
+// Users are exported with the hashes of their password and passphrase only if hashes is true.
+func Export(ctx context.Context, s *stor.Store, entity, format string, w io.Writer, after string, hashes bool) error {
+
+	var list func(cursor uint) ([]record, uint, error)
+	var header []string
+	switch entity {
+	case EntityPublications:
+		header = publicationHeader
+		list = func(cursor uint) ([]record, uint, error) {
+			publications, err := s.ListPublicationsAfter(cursor, stor.BatchSize)
+			records := make([]record, len(publications))
+			for i := range publications {
+				records[i] = NewPublicationRecord(&publications[i])
+				cursor = publications[i].ID
+			}
+			return records, cursor, err
+		}
+	case EntityUsers:
+		header = userHeader
+		list = func(cursor uint) ([]record, uint, error) {
+			users, err := s.ListUsersAfter(cursor, stor.BatchSize)
+			records := make([]record, len(users))
+			for i := range users {
+				records[i] = NewUserRecord(&users[i], hashes)
+				cursor = users[i].ID
+			}
+			return records, cursor, err
+		}
+	default:
+		return ErrUnknownEntity
+	}
+	if format != FormatJSONL && format != FormatCSV {
+		return ErrUnknownFormat
+	}
+
+	// the cursor is the ID of the last exported record
+	var cursor uint
+	if after != "" {
+		var err error
+		if cursor, err = cursorOf(s, entity, after); err != nil {
+			return err
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	cw := csv.NewWriter(bw)
+	enc := json.NewEncoder(bw)
+	if format == FormatCSV {
+		if err := cw.Write(header); err != nil {
+			return err
+		}
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		records, next, err := list(cursor)
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			if format == FormatCSV {
+				err = cw.Write(rec.csvRow())
+			} else {
+				err = enc.Encode(rec)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		if err = cw.Error(); err != nil {
+			return err
+		}
+		if err = bw.Flush(); err != nil {
+			return err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if len(records) < stor.BatchSize {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// cursorOf returns the ID of the publication or user identified by a UUID
+func cursorOf(s *stor.Store, entity, id string) (uint, error) {
+	if entity == EntityUsers {
+		user, err := s.GetUser(id)
+		if err != nil {
+			return 0, fmt.Errorf("%w %s: %s", ErrUnknownCursor, id, err.Error())
+		}
+		return user.ID, nil
+	}
+	publication, err := s.GetPublication(id)
+	if err != nil {
+		return 0, fmt.Errorf("%w %s: %s", ErrUnknownCursor, id, err.Error())
+	}
+	return publication.ID, nil
+}
+
+// lineSyntaxError is an error in a line of an imported file, which does not prevent reading the next lines
+type lineSyntaxError struct {
+	err error
+}
+
+func (e *lineSyntaxError) Error() string {
+	return e.err.Error()
+}
+
+// jsonlReader returns a function which reads the next record of a JSON Lines stream and returns its line number
+func jsonlReader(r io.Reader) func(rec record) (int, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16<<20)
+	line := 0
+	return func(rec record) (int, error) {
+		for sc.Scan() {
+			line++
+			data := strings.TrimSpace(sc.Text())
+			if data == "" {
+				continue
+			}
+			if err := json.Unmarshal([]byte(data), rec); err != nil {
+				return line, &lineSyntaxError{err}
+			}
+			return line, nil
+		}
+		if err := sc.Err(); err != nil {
+			return line, err
+		}
+		return line, io.EOF
+	}
+}
+
+// csvReader returns a function which reads the next record of a CSV stream with a header line
+// and returns its line number. Columns are identified by the header and may be in any order.
+func csvReader(r io.Reader) func(rec record) (int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	var header []string
+	return func(rec record) (int, error) {
+		if header == nil {
+			var err error
+			if header, err = cr.Read(); err != nil {
+				return 1, err
+			}
+			for i := range header {
+				header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+			}
+		}
+		row, err := cr.Read()
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				return parseErr.StartLine, &lineSyntaxError{parseErr.Err}
+			}
+			return 0, err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(row) != len(header) {
+			return line, &lineSyntaxError{fmt.Errorf("%d fields, expected %d", len(row), len(header))}
+		}
+		fields := make(map[string]string, len(header))
+		for i, name := range header {
+			fields[name] = row[i]
+		}
+		if err = rec.setCSV(fields); err != nil {
+			return line, &lineSyntaxError{err}
+		}
+		return line, nil
+	}
+}
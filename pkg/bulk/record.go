@@ -0,0 +1,206 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package bulk
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/edrlab/pubstore/pkg/stor"
+)
+
+// record is a publication or user, as a line of a bulk file
+type record interface {
+	uuid() string
+	csvRow() []string
+	setCSV(fields map[string]string) error
+}
+
+// PublicationRecord is a publication, as a line of a bulk file
+type PublicationRecord struct {
+	UUID          string   `json:"uuid"`
+	ISBN          string   `json:"isbn,omitempty"`
+	Title         string   `json:"title"`
+	ContentType   string   `json:"content_type"`
+	DatePublished string   `json:"date_published,omitempty"`
+	Description   string   `json:"description,omitempty"`
+	CoverUrl      string   `json:"cover_url,omitempty"`
+	Copies        int      `json:"copies"`
+	Language      []string `json:"language,omitempty"`
+	Publisher     []string `json:"publisher,omitempty"`
+	Author        []string `json:"author,omitempty"`
+	Category      []string `json:"category,omitempty"`
+}
+
+var publicationHeader = []string{"uuid", "isbn", "title", "content_type", "date_published", "description", "cover_url", "copies", "language", "publisher", "author", "category"}
+
+// NewPublicationRecord returns the record of a publication
+func NewPublicationRecord(p *stor.Publication) *PublicationRecord {
+	rec := &PublicationRecord{
+		UUID:          p.UUID,
+		ISBN:          p.ISBN,
+		Title:         p.Title,
+		ContentType:   p.ContentType,
+		DatePublished: p.DatePublished,
+		Description:   p.Description,
+		CoverUrl:      p.CoverUrl,
+		Copies:        p.Copies,
+	}
+	for _, l := range p.Language {
+		rec.Language = append(rec.Language, l.Code)
+	}
+	for _, pu := range p.Publisher {
+		rec.Publisher = append(rec.Publisher, pu.Name)
+	}
+	for _, a := range p.Author {
+		rec.Author = append(rec.Author, a.Name)
+	}
+	for _, c := range p.Category {
+		rec.Category = append(rec.Category, c.Name)
+	}
+	return rec
+}
+
+// Publication returns the publication of a record
+func (rec *PublicationRecord) Publication() *stor.Publication {
+	p := &stor.Publication{
+		UUID:          strings.TrimSpace(rec.UUID),
+		ISBN:          strings.TrimSpace(rec.ISBN),
+		Title:         rec.Title,
+		ContentType:   rec.ContentType,
+		DatePublished: rec.DatePublished,
+		Description:   rec.Description,
+		CoverUrl:      rec.CoverUrl,
+		Copies:        rec.Copies,
+	}
+	for _, code := range rec.Language {
+		p.Language = append(p.Language, stor.Language{Code: code})
+	}
+	for _, name := range rec.Publisher {
+		p.Publisher = append(p.Publisher, stor.Publisher{Name: name})
+	}
+	for _, name := range rec.Author {
+		p.Author = append(p.Author, stor.Author{Name: name})
+	}
+	for _, name := range rec.Category {
+		p.Category = append(p.Category, stor.Category{Name: name})
+	}
+	return p
+}
+
+func (rec *PublicationRecord) uuid() string {
+	return rec.UUID
+}
+
+func (rec *PublicationRecord) csvRow() []string {
+	return []string{
+		rec.UUID, rec.ISBN, rec.Title, rec.ContentType, rec.DatePublished, rec.Description, rec.CoverUrl,
+		strconv.Itoa(rec.Copies),
+		joinList(rec.Language), joinList(rec.Publisher), joinList(rec.Author), joinList(rec.Category),
+	}
+}
+
+func (rec *PublicationRecord) setCSV(fields map[string]string) error {
+	rec.UUID = fields["uuid"]
+	rec.ISBN = fields["isbn"]
+	rec.Title = fields["title"]
+	rec.ContentType = fields["content_type"]
+	rec.DatePublished = fields["date_published"]
+	rec.Description = fields["description"]
+	rec.CoverUrl = fields["cover_url"]
+	if copies := strings.TrimSpace(fields["copies"]); copies != "" {
+		n, err := strconv.Atoi(copies)
+		if err != nil {
+			return fmt.Errorf("invalid copies %q", copies)
+		}
+		rec.Copies = n
+	}
+	rec.Language = splitList(fields["language"])
+	rec.Publisher = splitList(fields["publisher"])
+	rec.Author = splitList(fields["author"])
+	rec.Category = splitList(fields["category"])
+	return nil
+}
+
+// UserRecord is a user, as a line of a bulk file.
+// Users may be exported and imported with or without the hashes of their password and passphrase.
+type UserRecord struct {
+	UUID        string `json:"uuid"`
+	Name        string `json:"name"`
+	Email       string `json:"email"`
+	Password    string `json:"password,omitempty"`
+	HPassword   string `json:"hpassword,omitempty"`
+	TextHint    string `json:"text_hint"`
+	Passphrase  string `json:"passphrase,omitempty"`
+	HPassphrase string `json:"hpassphrase,omitempty"`
+}
+
+var userHeader = []string{"uuid", "name", "email", "password", "hpassword", "text_hint", "passphrase", "hpassphrase"}
+
+// NewUserRecord returns the record of a user, with the hashes of their password and passphrase if hashes is true
+func NewUserRecord(u *stor.User, hashes bool) *UserRecord {
+	rec := &UserRecord{
+		UUID:     u.UUID,
+		Name:     u.Name,
+		Email:    u.Email,
+		TextHint: u.TextHint,
+	}
+	if hashes {
+		rec.HPassword = u.HPassword
+		rec.HPassphrase = u.HPassphrase
+	}
+	return rec
+}
+
+// User returns the user of a record
+func (rec *UserRecord) User() *stor.User {
+	return &stor.User{
+		UUID:        strings.TrimSpace(rec.UUID),
+		Name:        rec.Name,
+		Email:       strings.TrimSpace(rec.Email),
+		Password:    rec.Password,
+		HPassword:   rec.HPassword,
+		TextHint:    rec.TextHint,
+		Passphrase:  rec.Passphrase,
+		HPassphrase: rec.HPassphrase,
+	}
+}
+
+func (rec *UserRecord) uuid() string {
+	return rec.UUID
+}
+
+func (rec *UserRecord) csvRow() []string {
+	return []string{rec.UUID, rec.Name, rec.Email, rec.Password, rec.HPassword, rec.TextHint, rec.Passphrase, rec.HPassphrase}
+}
+
+func (rec *UserRecord) setCSV(fields map[string]string) error {
+	rec.UUID = fields["uuid"]
+	rec.Name = fields["name"]
+	rec.Email = fields["email"]
+	rec.Password = fields["password"]
+	rec.HPassword = fields["hpassword"]
+	rec.TextHint = fields["text_hint"]
+	rec.Passphrase = fields["passphrase"]
+	rec.HPassphrase = fields["hpassphrase"]
+	return nil
+}
+
+// joinList joins the values of a list in a CSV field
+func joinList(values []string) string {
+	return strings.Join(values, listSeparator)
+}
+
+// splitList splits a CSV field into a list of values
+func splitList(field string) []string {
+	var values []string
+	for _, v := range strings.Split(field, listSeparator) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
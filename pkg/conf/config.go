@@ -3,6 +3,7 @@ package conf
 import (
 	"log"
 	"os"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 	"gopkg.in/yaml.v2"
@@ -22,17 +23,62 @@ type Config struct {
 	// Path to static files and views
 	RootDir string `yaml:"root_dir" split_words:"true"`
 	// Path to resources, especially cover images
-	//Resources string `yaml:"resources"`
+	Resources string `yaml:"resources"`
+	// Path to an embedded Bleve index; if set, it replaces the search engine of the database
+	SearchIndex string `yaml:"search_index" split_words:"true"`
 	// Page size used in the REST API and Web interface
 	PageSize int `yaml:"page_size"  split_words:"true"`
 	// LCP print and copy limits set in LCP licenses generated from the associated LCP Server
 	PrintLimit int `yaml:"print_limit"  split_words:"true"`
 	CopyLimit  int `yaml:"copy_limit"  split_words:"true"`
+	// Default rights per type of transaction
+	Rights RightsConfig `yaml:"rights"`
+	// Number of days during which a copy is kept for a user whose hold is ready
+	HoldDuration int `yaml:"hold_duration" split_words:"true"`
 	// Basic Auth credentials used by the LCP encryption tool to notify Pubstore of a new encrypted publication
 	UserName string `yaml:"username"`
 	Password string `yaml:"password"`
+	// Basic Auth credentials of the administrator, required by the bulk import and export of publications and users
+	// and by the revocation of licenses.
+	// These routes are disabled if the credentials are not set.
+	AdminUserName string `yaml:"admin_username" envconfig:"ADMIN_USERNAME"`
+	AdminPassword string `yaml:"admin_password" envconfig:"ADMIN_PASSWORD"`
 	// LCP Server
 	LCPServer LCPServerAccess `yaml:"lcp_server"`
+	// Ingestion of publication packages
+	Ingest IngestConfig `yaml:"ingest"`
+}
+
+// Ingestion of publication packages
+type IngestConfig struct {
+	// Directory in which uploaded packages are stored
+	UploadDir string `yaml:"upload_dir" split_words:"true"`
+	// Maximum size of an uploaded package, in megabytes
+	MaxSize int64 `yaml:"max_size" split_words:"true"`
+	// Path to the LCP encryption tool (lcpencrypt); if empty, packages are not encrypted
+	Encrypt string `yaml:"encrypt"`
+	// Additional arguments passed to the LCP encryption tool, e.g. its storage parameters
+	EncryptArgs []string `yaml:"encrypt_args" split_words:"true"`
+}
+
+// Default rights per type of transaction
+type RightsConfig struct {
+	Purchase     TransactionRights `yaml:"purchase"`
+	Loan         TransactionRights `yaml:"loan"`
+	Subscription TransactionRights `yaml:"subscription"`
+}
+
+// Default rights of a type of transaction.
+// Unset print and copy limits default to the global limits.
+type TransactionRights struct {
+	PrintLimit *int `yaml:"print_limit" split_words:"true"`
+	CopyLimit  *int `yaml:"copy_limit" split_words:"true"`
+	// Duration of the license in days, 0 meaning unlimited.
+	// Unset defaults to 30 days for loans and subscriptions, unlimited for purchases.
+	Duration *int `yaml:"duration"`
+	// Maximum duration of the license in days, renewals included, 0 meaning unlimited.
+	// Unset defaults to three times the duration.
+	MaxDuration *int `yaml:"max_duration" split_words:"true"`
 }
 
 // LCP Server access parameters
@@ -41,6 +87,20 @@ type LCPServerAccess struct {
 	Version  string `yaml:"version"`
 	UserName string `yaml:"username"`
 	Password string `yaml:"password"`
+	// Number of seconds during which a status document is kept in cache before being revalidated
+	StatusCacheTTL int `yaml:"status_cache_ttl" split_words:"true"`
+	// Verify the signature of licenses returned by the License Server
+	VerifySignature bool `yaml:"verify_signature" split_words:"true"`
+	// Path to a PEM file holding the root certificates of trusted license providers
+	ProviderRoot string `yaml:"provider_root" split_words:"true"`
+	// Number of seconds after which a request to the License Server is abandoned; negative disables the timeout
+	Timeout int `yaml:"timeout"`
+	// Number of retries of a request which failed with a network error or a server error; negative disables retries
+	Retries int `yaml:"retries"`
+	// Number of consecutive failures after which requests are not sent anymore; negative disables the circuit breaker
+	BreakerThreshold int `yaml:"breaker_threshold" split_words:"true"`
+	// Number of seconds during which no request is sent once the circuit breaker is open
+	BreakerCooldown int `yaml:"breaker_cooldown" split_words:"true"`
 }
 
 func Init(configFile string) (Config, error) {
@@ -84,9 +144,97 @@ func Init(configFile string) (Config, error) {
 			return cfg, err
 		}
 	}
+	if cfg.Rights.Loan.Duration == nil {
+		duration := 30
+		cfg.Rights.Loan.Duration = &duration
+	}
+	if cfg.Rights.Subscription.Duration == nil {
+		duration := 30
+		cfg.Rights.Subscription.Duration = &duration
+	}
+	if cfg.HoldDuration == 0 {
+		cfg.HoldDuration = 3
+	}
 	if cfg.LCPServer.Version == "" {
 		cfg.LCPServer.Version = "v2"
 	}
+	if cfg.LCPServer.StatusCacheTTL == 0 {
+		cfg.LCPServer.StatusCacheTTL = 60
+	}
+	if cfg.Resources == "" {
+		cfg.Resources = "resources"
+	}
+	if cfg.Ingest.UploadDir == "" {
+		cfg.Ingest.UploadDir = "uploads"
+	}
+	if cfg.Ingest.MaxSize == 0 {
+		cfg.Ingest.MaxSize = 500
+	}
+	if cfg.LCPServer.Timeout == 0 {
+		cfg.LCPServer.Timeout = 10
+	}
+	if cfg.LCPServer.Retries == 0 {
+		cfg.LCPServer.Retries = 2
+	}
+	if cfg.LCPServer.BreakerThreshold == 0 {
+		cfg.LCPServer.BreakerThreshold = 5
+	}
+	if cfg.LCPServer.BreakerCooldown == 0 {
+		cfg.LCPServer.BreakerCooldown = 30
+	}
 
 	return cfg, nil
 }
+
+// transactionRights returns the configured rights of a type of transaction: "purchase" || "loan" || "subscription"
+func (cfg *Config) transactionRights(transactionType string) TransactionRights {
+
+	switch transactionType {
+	case "loan":
+		return cfg.Rights.Loan
+	case "subscription":
+		return cfg.Rights.Subscription
+	default:
+		return cfg.Rights.Purchase
+	}
+}
+
+// DefaultRights returns the print limit, copy limit and duration (in days) applied by default
+// to a type of transaction: "purchase" || "loan" || "subscription".
+func (cfg *Config) DefaultRights(transactionType string) (int, int, int) {
+
+	rights := cfg.transactionRights(transactionType)
+
+	print := cfg.PrintLimit
+	if rights.PrintLimit != nil {
+		print = *rights.PrintLimit
+	}
+	copy := cfg.CopyLimit
+	if rights.CopyLimit != nil {
+		copy = *rights.CopyLimit
+	}
+	return print, copy, rights.duration()
+}
+
+// MaxDuration returns the maximum duration (in days) of a type of transaction, renewals included, 0 meaning unlimited
+func (cfg *Config) MaxDuration(transactionType string) int {
+
+	rights := cfg.transactionRights(transactionType)
+	if rights.MaxDuration != nil {
+		return *rights.MaxDuration
+	}
+	return 3 * rights.duration()
+}
+
+// duration returns the duration (in days) of a type of transaction, 0 meaning unlimited
+func (rights TransactionRights) duration() int {
+	if rights.Duration == nil {
+		return 0
+	}
+	return *rights.Duration
+}
+
+// HoldReadyPeriod returns the period during which a copy is kept for a user whose hold is ready
+func (cfg *Config) HoldReadyPeriod() time.Duration {
+	return time.Duration(cfg.HoldDuration) * 24 * time.Hour
+}
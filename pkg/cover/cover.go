@@ -0,0 +1,286 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package cover stores the cover images of publications in the resources directory,
+// in their original size and as medium and thumbnail images.
+package cover
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/stor"
+)
+
+// Maximum height of the generated images, in pixels; smaller images are not upscaled
+const (
+	MediumHeight    = 600
+	ThumbnailHeight = 240
+)
+
+// MaxSize is the maximum size of a downloaded cover, in bytes
+const MaxSize = 10 << 20
+
+// MaxPixels is the maximum number of pixels of a cover which is decoded to be resized,
+// so that a small file cannot make the store allocate gigabytes
+const MaxPixels = 16 << 20
+
+// ResourcesPath is the path under which the resources directory is served
+const ResourcesPath = "/resources/"
+
+// ErrNotImage is returned when a cover is not an image
+var ErrNotImage = errors.New("the cover is not an image")
+
+// ErrTooLarge is returned when a downloaded cover exceeds MaxSize, or when a cover exceeds MaxPixels
+var ErrTooLarge = errors.New("the cover is too large")
+
+// httpClient is used to download covers
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// Store writes the cover of a publication in the resources directory, in its original size
+// and as medium and thumbnail JPEG images, and returns the description of each size.
+// File names are derived from the content of the cover, so that they can be cached indefinitely.
+// Images which cannot be decoded (e.g. SVG or WebP) are only stored in their original size.
+// Images of more than MaxPixels pixels are rejected.
+func Store(dir, pubUUID string, data []byte, mediaType string) ([]stor.CoverImage, error) {
+
+	mediaType, err := imageType(data, mediaType)
+	if err != nil {
+		return nil, err
+	}
+	// the dimensions of an image are checked before it is decoded
+	config, _, configErr := image.DecodeConfig(bytes.NewReader(data))
+	if configErr == nil && int64(config.Width)*int64(config.Height) > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	// covers previously stored for the publication are replaced
+	if err = Remove(dir, pubUUID); err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Join(dir, pubUUID), 0o755); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:8])
+
+	original := stor.CoverImage{
+		Size: stor.CoverSizeOriginal,
+		Path: path.Join(pubUUID, "original-"+hash+Extension(mediaType)),
+		Type: mediaType,
+	}
+	if err = os.WriteFile(filepath.Join(dir, filepath.FromSlash(original.Path)), data, 0o644); err != nil {
+		return nil, err
+	}
+	covers := []stor.CoverImage{original}
+
+	if configErr != nil {
+		// the format is not supported by the image decoders
+		return covers, nil
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return covers, nil
+	}
+	src := flatten(img)
+	covers[0].Width, covers[0].Height = src.Bounds().Dx(), src.Bounds().Dy()
+
+	for _, size := range []struct {
+		name   string
+		height int
+	}{{stor.CoverSizeMedium, MediumHeight}, {stor.CoverSizeThumbnail, ThumbnailHeight}} {
+		resized := resize(src, size.height)
+		var buf bytes.Buffer
+		if err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+		cover := stor.CoverImage{
+			Size:   size.name,
+			Path:   path.Join(pubUUID, size.name+"-"+hash+".jpg"),
+			Type:   "image/jpeg",
+			Width:  resized.Bounds().Dx(),
+			Height: resized.Bounds().Dy(),
+		}
+		if err = os.WriteFile(filepath.Join(dir, filepath.FromSlash(cover.Path)), buf.Bytes(), 0o644); err != nil {
+			return nil, err
+		}
+		covers = append(covers, cover)
+	}
+	return covers, nil
+}
+
+// Remove deletes the covers of a publication from the resources directory
+func Remove(dir, pubUUID string) error {
+	if pubUUID == "" {
+		return nil
+	}
+	return os.RemoveAll(filepath.Join(dir, pubUUID))
+}
+
+// Download fetches a cover image and returns its content and media type
+func Download(ctx context.Context, url string) ([]byte, string, error) {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to download the cover %s: %s", url, resp.Status)
+	}
+	if resp.ContentLength > MaxSize {
+		return nil, "", ErrTooLarge
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxSize+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if len(data) > MaxSize {
+		return nil, "", ErrTooLarge
+	}
+	mediaType, err := imageType(data, resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, "", err
+	}
+	return data, mediaType, nil
+}
+
+// Href returns the absolute URL of a cover stored in the resources directory
+func Href(publicBaseUrl, coverPath string) string {
+	return strings.TrimSuffix(publicBaseUrl, "/") + ResourcesPath + coverPath
+}
+
+// IsStored returns true if a cover URL references the resources directory
+func IsStored(publicBaseUrl, coverUrl string) bool {
+	return strings.HasPrefix(coverUrl, strings.TrimSuffix(publicBaseUrl, "/")+ResourcesPath)
+}
+
+// Save stores the cover of a publication and references it from the publication
+func Save(c *conf.Config, s *stor.Store, publication *stor.Publication, data []byte, mediaType string) error {
+
+	covers, err := Store(c.Resources, publication.UUID, data, mediaType)
+	if err != nil {
+		return err
+	}
+	return s.SetCovers(publication, Href(c.PublicBaseUrl, covers[0].Path), covers)
+}
+
+// Fetch downloads the cover referenced by an external URL and stores it.
+// It does nothing if the cover URL is empty or already references the resources directory.
+func Fetch(ctx context.Context, c *conf.Config, s *stor.Store, publication *stor.Publication) error {
+
+	if publication.CoverUrl == "" || IsStored(c.PublicBaseUrl, publication.CoverUrl) {
+		return nil
+	}
+	data, mediaType, err := Download(ctx, publication.CoverUrl)
+	if err != nil {
+		return err
+	}
+	return Save(c, s, publication, data, mediaType)
+}
+
+// Extension returns the file extension of an image media type
+func Extension(mediaType string) string {
+	switch mediaType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "image/svg+xml":
+		return ".svg"
+	default:
+		return ".jpg"
+	}
+}
+
+// imageType returns the media type of an image, from the declared type or its content
+func imageType(data []byte, declared string) (string, error) {
+	if t, _, err := mime.ParseMediaType(declared); err == nil && strings.HasPrefix(t, "image/") {
+		return t, nil
+	}
+	if t := http.DetectContentType(data); strings.HasPrefix(t, "image/") {
+		return t, nil
+	}
+	return "", ErrNotImage
+}
+
+// flatten returns an opaque RGBA copy of an image, whose transparent areas are rendered on a white background
+func flatten(img image.Image) *image.RGBA {
+
+	bounds := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Over)
+	return dst
+}
+
+// resize scales a flattened image down to a maximum height with a box filter.
+// The image itself is returned if it is not higher than maxHeight.
+func resize(src *image.RGBA, maxHeight int) *image.RGBA {
+
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	if sh <= maxHeight || sh == 0 {
+		return src
+	}
+	dh := maxHeight
+	dw := sw * dh / sh
+	if dw < 1 {
+		dw = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		if y1 == y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+			if x1 == x0 {
+				x1 = x0 + 1
+			}
+			var r, g, b, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					b += int(row[sx*4+2])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = 0xff
+		}
+	}
+	return dst
+}
@@ -0,0 +1,171 @@
+package cover
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/stretchr/testify/assert"
+)
+
+// encodePNG returns a PNG image of a given size, transparent on its left half
+func encodePNG(t *testing.T, width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := width / 2; x < width; x++ {
+			img.Set(x, y, color.NRGBA{R: 0xff, A: 0xff})
+		}
+	}
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	assert.NoError(t, err)
+	return buf.Bytes()
+}
+
+func TestStore(t *testing.T) {
+
+	dir := t.TempDir()
+	data := encodePNG(t, 400, 1200)
+
+	covers, err := Store(dir, "pub", data, "")
+	if !assert.NoError(t, err) || !assert.Len(t, covers, 3) {
+		return
+	}
+	expected := []struct {
+		size          string
+		mediaType     string
+		width, height int
+	}{
+		{stor.CoverSizeOriginal, "image/png", 400, 1200},
+		{stor.CoverSizeMedium, "image/jpeg", 200, MediumHeight},
+		{stor.CoverSizeThumbnail, "image/jpeg", 80, ThumbnailHeight},
+	}
+	for i, e := range expected {
+		assert.Equal(t, e.size, covers[i].Size)
+		assert.Equal(t, e.mediaType, covers[i].Type)
+		assert.Equal(t, e.width, covers[i].Width)
+		assert.Equal(t, e.height, covers[i].Height)
+		_, err = os.Stat(filepath.Join(dir, filepath.FromSlash(covers[i].Path)))
+		assert.NoError(t, err)
+	}
+
+	// a new cover replaces the previous ones
+	replaced, err := Store(dir, "pub", encodePNG(t, 100, 100), "image/png")
+	if assert.NoError(t, err) && assert.Len(t, replaced, 3) {
+		assert.NotEqual(t, covers[0].Path, replaced[0].Path)
+		_, err = os.Stat(filepath.Join(dir, filepath.FromSlash(covers[0].Path)))
+		assert.True(t, os.IsNotExist(err))
+		// small images are not upscaled
+		assert.Equal(t, 100, replaced[1].Height)
+	}
+
+	// images which cannot be decoded are stored in their original size only
+	svg := []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="10" height="10"></svg>`)
+	covers, err = Store(dir, "svg", svg, "image/svg+xml")
+	if assert.NoError(t, err) && assert.Len(t, covers, 1) {
+		assert.Equal(t, "svg/original-", covers[0].Path[:len("svg/original-")])
+		assert.Equal(t, ".svg", filepath.Ext(covers[0].Path))
+	}
+
+	_, err = Store(dir, "text", []byte("not an image"), "text/plain")
+	assert.ErrorIs(t, err, ErrNotImage)
+}
+
+func TestStoreTooManyPixels(t *testing.T) {
+
+	dir := t.TempDir()
+
+	// a tiny GIF whose header declares a huge logical screen
+	var buf bytes.Buffer
+	err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.White}), nil)
+	assert.NoError(t, err)
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint16(data[6:8], 30000)
+	binary.LittleEndian.PutUint16(data[8:10], 30000)
+
+	_, err = Store(dir, "huge", data, "image/gif")
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = os.Stat(filepath.Join(dir, "huge"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestResize(t *testing.T) {
+
+	img, err := png.Decode(bytes.NewReader(encodePNG(t, 100, 300)))
+	if !assert.NoError(t, err) {
+		return
+	}
+	src := flatten(img)
+
+	resized := resize(src, 30)
+	assert.Equal(t, image.Rect(0, 0, 10, 30), resized.Bounds())
+	// transparent areas are rendered on a white background
+	assert.Equal(t, color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}, resized.RGBAAt(0, 0))
+	assert.Equal(t, color.RGBA{R: 0xff, A: 0xff}, resized.RGBAAt(9, 29))
+
+	// images are not upscaled
+	assert.Same(t, src, resize(src, 600))
+
+	// very narrow images keep a width of at least one pixel
+	narrow := flatten(image.NewRGBA(image.Rect(0, 0, 1, 1000)))
+	assert.Equal(t, image.Rect(0, 0, 1, 10), resize(narrow, 10).Bounds())
+}
+
+func TestDownload(t *testing.T) {
+
+	cover := encodePNG(t, 10, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cover":
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Write(cover)
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Write([]byte("not an image"))
+		case "/large":
+			w.Header().Set("Content-Length", strconv.Itoa(MaxSize+1))
+			w.Write(make([]byte, MaxSize+1))
+		case "/chunked":
+			// no Content-Length is sent when the body is flushed in chunks
+			for i := 0; i < 11; i++ {
+				w.Write(make([]byte, 1<<20))
+				w.(http.Flusher).Flush()
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+
+	// the media type is detected from the content
+	data, mediaType, err := Download(ctx, server.URL+"/cover")
+	if assert.NoError(t, err) {
+		assert.Equal(t, cover, data)
+		assert.Equal(t, "image/png", mediaType)
+	}
+
+	_, _, err = Download(ctx, server.URL+"/text")
+	assert.ErrorIs(t, err, ErrNotImage)
+
+	_, _, err = Download(ctx, server.URL+"/large")
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	_, _, err = Download(ctx, server.URL+"/chunked")
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	_, _, err = Download(ctx, server.URL+"/missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,60 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package ingest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/stor"
+)
+
+// Encrypter encrypts a package and makes its content key known to the License Server
+type Encrypter interface {
+	Encrypt(ctx context.Context, path string, publication *stor.Publication) error
+}
+
+// NoEncryption leaves packages unencrypted, when encryption is performed outside of pubstore
+type NoEncryption struct{}
+
+func (NoEncryption) Encrypt(ctx context.Context, path string, publication *stor.Publication) error {
+	fmt.Println("No encryption tool configured, " + path + " is left unencrypted")
+	return nil
+}
+
+// LcpEncrypt encrypts packages with the LCP encryption tool, which notifies the License Server.
+// The publication is created by pubstore, therefore the tool must not notify pubstore.
+type LcpEncrypt struct {
+	*conf.Config
+}
+
+func (e *LcpEncrypt) Encrypt(ctx context.Context, path string, publication *stor.Publication) error {
+
+	lcpsv := e.Config.LCPServer
+	args := []string{
+		"-input", path,
+		"-contentid", publication.UUID,
+		"-lcpsv", lcpsv.Url,
+		"-login", lcpsv.UserName,
+		"-password", lcpsv.Password,
+	}
+	if lcpsv.Version == "v2" {
+		args = append(args, "-v2")
+	}
+	args = append(args, e.Config.Ingest.EncryptArgs...)
+
+	cmd := exec.CommandContext(ctx, e.Config.Ingest.Encrypt, args...)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
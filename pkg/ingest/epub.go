@@ -0,0 +1,177 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package ingest
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/edrlab/pubstore/pkg/stor"
+)
+
+// container is the META-INF/container.xml file of an EPUB
+type container struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// opfPackage is the package document of an EPUB 2 or EPUB 3
+type opfPackage struct {
+	UniqueIdentifier string      `xml:"unique-identifier,attr"`
+	Metadata         opfMetadata `xml:"metadata"`
+	Manifest         []opfItem   `xml:"manifest>item"`
+}
+
+type opfMetadata struct {
+	Titles       []string        `xml:"http://purl.org/dc/elements/1.1/ title"`
+	Creators     []opfCreator    `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Publishers   []string        `xml:"http://purl.org/dc/elements/1.1/ publisher"`
+	Languages    []string        `xml:"http://purl.org/dc/elements/1.1/ language"`
+	Subjects     []string        `xml:"http://purl.org/dc/elements/1.1/ subject"`
+	Descriptions []string        `xml:"http://purl.org/dc/elements/1.1/ description"`
+	Dates        []string        `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Identifiers  []opfIdentifier `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+	Metas        []opfMeta       `xml:"meta"`
+}
+
+type opfCreator struct {
+	ID   string `xml:"id,attr"`
+	Role string `xml:"http://www.idpf.org/2007/opf role,attr"`
+	Name string `xml:",chardata"`
+}
+
+type opfIdentifier struct {
+	ID    string `xml:"id,attr"`
+	Value string `xml:",chardata"`
+}
+
+// opfMeta is an EPUB 2 meta (name, content) or an EPUB 3 meta (property, refines, value)
+type opfMeta struct {
+	Name     string `xml:"name,attr"`
+	Content  string `xml:"content,attr"`
+	Property string `xml:"property,attr"`
+	Refines  string `xml:"refines,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type opfItem struct {
+	ID         string `xml:"id,attr"`
+	Href       string `xml:"href,attr"`
+	MediaType  string `xml:"media-type,attr"`
+	Properties string `xml:"properties,attr"`
+}
+
+// parseEPUB extracts the metadata and cover of an EPUB from its package document
+func parseEPUB(zr *zip.Reader) (*Package, error) {
+
+	data, err := readFile(zr, "META-INF/container.xml")
+	if err != nil {
+		return nil, err
+	}
+	var c container
+	if err = xml.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if len(c.Rootfiles) == 0 {
+		return nil, errors.New("no package document in the EPUB container")
+	}
+	opfPath := c.Rootfiles[0].FullPath
+
+	data, err = readFile(zr, opfPath)
+	if err != nil {
+		return nil, err
+	}
+	var opf opfPackage
+	if err = xml.Unmarshal(data, &opf); err != nil {
+		return nil, err
+	}
+	md := opf.Metadata
+
+	pkg := &Package{}
+	pub := &pkg.Publication
+	pub.ContentType = ContentTypeEPUB
+	if len(md.Titles) > 0 {
+		pub.Title = strings.TrimSpace(md.Titles[0])
+	}
+	if len(md.Descriptions) > 0 {
+		pub.Description = strings.TrimSpace(md.Descriptions[0])
+	}
+	if len(md.Dates) > 0 {
+		pub.DatePublished = strings.TrimSpace(md.Dates[0])
+	}
+	for _, id := range md.Identifiers {
+		if id.ID == opf.UniqueIdentifier {
+			pub.UUID = strings.TrimPrefix(strings.TrimSpace(id.Value), "urn:uuid:")
+		}
+	}
+
+	// EPUB 3 roles are set by meta elements refining the creators
+	roles := make(map[string]string)
+	for _, meta := range md.Metas {
+		if meta.Property == "role" && strings.HasPrefix(meta.Refines, "#") {
+			roles[strings.TrimPrefix(meta.Refines, "#")] = strings.TrimSpace(meta.Value)
+		}
+	}
+	for _, creator := range md.Creators {
+		role := creator.Role
+		if r, ok := roles[creator.ID]; ok {
+			role = r
+		}
+		name := strings.TrimSpace(creator.Name)
+		if name != "" && (role == "" || role == "aut") {
+			pub.Author = append(pub.Author, stor.Author{Name: name})
+		}
+	}
+	for _, publisher := range md.Publishers {
+		if name := strings.TrimSpace(publisher); name != "" {
+			pub.Publisher = append(pub.Publisher, stor.Publisher{Name: name})
+		}
+	}
+	for _, language := range md.Languages {
+		addLanguage(pub, language)
+	}
+	for _, subject := range md.Subjects {
+		if name := strings.TrimSpace(subject); name != "" {
+			pub.Category = append(pub.Category, stor.Category{Name: name})
+		}
+	}
+
+	// the cover is declared by a manifest property in EPUB 3, by a meta element in EPUB 2
+	var cover *opfItem
+	for i, item := range opf.Manifest {
+		if strings.Contains(" "+item.Properties+" ", " cover-image ") {
+			cover = &opf.Manifest[i]
+		}
+	}
+	if cover == nil {
+		for _, meta := range md.Metas {
+			if meta.Name != "cover" {
+				continue
+			}
+			for i, item := range opf.Manifest {
+				if item.ID == meta.Content {
+					cover = &opf.Manifest[i]
+				}
+			}
+		}
+	}
+	if cover != nil && strings.HasPrefix(cover.MediaType, "image/") {
+		href, err := url.PathUnescape(cover.Href)
+		if err != nil {
+			href = cover.Href
+		}
+		if pkg.Cover, err = readFile(zr, path.Join(path.Dir(opfPath), href)); err == nil {
+			pkg.CoverType = cover.MediaType
+		}
+	}
+
+	return pkg, nil
+}
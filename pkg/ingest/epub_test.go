@@ -0,0 +1,171 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+const containerXML = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles>
+    <rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/>
+  </rootfiles>
+</container>`
+
+const epub3OPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="pub-id">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="isbn">9780000000000</dc:identifier>
+    <dc:identifier id="pub-id">urn:uuid:5c9a2a8e-43b3-4a3c-9a1b-2f5f1c6c1e0d</dc:identifier>
+    <dc:title> Moby Dick </dc:title>
+    <dc:title>or, The Whale</dc:title>
+    <dc:creator id="author">Herman Melville</dc:creator>
+    <dc:creator id="illustrator">Rockwell Kent</dc:creator>
+    <meta refines="#author" property="role" scheme="marc:relators">aut</meta>
+    <meta refines="#illustrator" property="role" scheme="marc:relators">ill</meta>
+    <dc:publisher>Harper &amp; Brothers</dc:publisher>
+    <dc:language>en-US</dc:language>
+    <dc:language>eng</dc:language>
+    <dc:subject>Sea stories</dc:subject>
+    <dc:subject>Whaling</dc:subject>
+    <dc:description>The voyage of the Pequod.</dc:description>
+    <dc:date>1851-10-18</dc:date>
+  </metadata>
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="cover" href="images/cover%20art.jpg" media-type="image/jpeg" properties="cover-image"/>
+  </manifest>
+</package>`
+
+const epub2OPF = `<?xml version="1.0" encoding="UTF-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" xmlns:opf="http://www.idpf.org/2007/opf" version="2.0" unique-identifier="BookId">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:identifier id="BookId">isbn:9780000000001</dc:identifier>
+    <dc:title>Le Tour du monde en quatre-vingts jours</dc:title>
+    <dc:creator opf:role="aut">Jules Verne</dc:creator>
+    <dc:creator opf:role="edt">Pierre-Jules Hetzel</dc:creator>
+    <dc:language>fr</dc:language>
+    <meta name="cover" content="cover-img"/>
+  </metadata>
+  <manifest>
+    <item id="cover-img" href="cover.png" media-type="image/png"/>
+  </manifest>
+</package>`
+
+func TestParseEPUB3(t *testing.T) {
+
+	pkg, err := parse(t, map[string][]byte{
+		"mimetype":                   []byte("application/epub+zip"),
+		"META-INF/container.xml":     []byte(containerXML),
+		"OEBPS/content.opf":          []byte(epub3OPF),
+		"OEBPS/images/cover art.jpg": []byte("jpeg"),
+		"OEBPS/nav.xhtml":            []byte("<html/>"),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	pub := pkg.Publication
+	assert.Equal(t, ContentTypeEPUB, pub.ContentType)
+	assert.Equal(t, "5c9a2a8e-43b3-4a3c-9a1b-2f5f1c6c1e0d", pub.UUID)
+	assert.Equal(t, "Moby Dick", pub.Title)
+	assert.Equal(t, "The voyage of the Pequod.", pub.Description)
+	assert.Equal(t, "1851-10-18", pub.DatePublished)
+
+	// only the authors are kept, their role being set by refining meta elements
+	if assert.Len(t, pub.Author, 1) {
+		assert.Equal(t, "Herman Melville", pub.Author[0].Name)
+	}
+	if assert.Len(t, pub.Publisher, 1) {
+		assert.Equal(t, "Harper & Brothers", pub.Publisher[0].Name)
+	}
+	if assert.Len(t, pub.Language, 1) {
+		assert.Equal(t, "en", pub.Language[0].Code)
+	}
+	if assert.Len(t, pub.Category, 2) {
+		assert.Equal(t, "Sea stories", pub.Category[0].Name)
+		assert.Equal(t, "Whaling", pub.Category[1].Name)
+	}
+
+	// the cover href is relative to the package document and percent-encoded
+	assert.Equal(t, "jpeg", string(pkg.Cover))
+	assert.Equal(t, "image/jpeg", pkg.CoverType)
+}
+
+func TestParseEPUB2(t *testing.T) {
+
+	pkg, err := parse(t, map[string][]byte{
+		"META-INF/container.xml": []byte(containerXML),
+		"OEBPS/content.opf":      []byte(epub2OPF),
+		"OEBPS/cover.png":        []byte("png"),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	pub := pkg.Publication
+	assert.Equal(t, "Le Tour du monde en quatre-vingts jours", pub.Title)
+
+	// the identifier is not a UUID, a new one is generated
+	assert.NotEqual(t, "isbn:9780000000001", pub.UUID)
+	_, err = uuid.Parse(pub.UUID)
+	assert.NoError(t, err)
+
+	// the role is an attribute of the creator
+	if assert.Len(t, pub.Author, 1) {
+		assert.Equal(t, "Jules Verne", pub.Author[0].Name)
+	}
+	if assert.Len(t, pub.Language, 1) {
+		assert.Equal(t, "fr", pub.Language[0].Code)
+	}
+
+	// the cover is declared by a meta element
+	assert.Equal(t, "png", string(pkg.Cover))
+	assert.Equal(t, "image/png", pkg.CoverType)
+}
+
+func TestParseEPUBErrors(t *testing.T) {
+
+	// the cover is optional
+	pkg, err := parse(t, map[string][]byte{
+		"META-INF/container.xml": []byte(containerXML),
+		"OEBPS/content.opf":      []byte(epub2OPF),
+	})
+	if assert.NoError(t, err) {
+		assert.Empty(t, pkg.Cover)
+		assert.Empty(t, pkg.CoverType)
+	}
+
+	// the container has no package document
+	_, err = parse(t, map[string][]byte{
+		"META-INF/container.xml": []byte(`<container><rootfiles></rootfiles></container>`),
+	})
+	assert.Error(t, err)
+
+	// the package document is missing
+	_, err = parse(t, map[string][]byte{
+		"META-INF/container.xml": []byte(containerXML),
+	})
+	assert.Error(t, err)
+
+	// the package document is not well-formed
+	_, err = parse(t, map[string][]byte{
+		"META-INF/container.xml": []byte(containerXML),
+		"OEBPS/content.opf":      []byte("<package><metadata>"),
+	})
+	assert.Error(t, err)
+
+	// the package has no title
+	_, err = parse(t, map[string][]byte{
+		"META-INF/container.xml": []byte(containerXML),
+		"OEBPS/content.opf":      []byte(`<package xmlns="http://www.idpf.org/2007/opf"><metadata/></package>`),
+	})
+	assert.Error(t, err)
+
+	// the package document is too large to be read
+	_, err = parse(t, map[string][]byte{
+		"META-INF/container.xml": []byte(containerXML),
+		"OEBPS/content.opf":      make([]byte, maxFileSize+1),
+	})
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+}
@@ -0,0 +1,227 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package ingest creates publications from EPUB, PDF, audiobook and Divina packages.
+package ingest
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/cover"
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/google/uuid"
+)
+
+// Content types of the publications created from packages, once encrypted
+const (
+	ContentTypeEPUB      = "application/epub+zip"
+	ContentTypePDF       = "application/pdf+lcp"
+	ContentTypeAudiobook = "application/audiobook+lcp"
+	ContentTypeDivina    = "application/divina+lcp"
+)
+
+// ErrUnsupportedPackage is returned for a file which is neither an EPUB nor a Readium package
+var ErrUnsupportedPackage = errors.New("unsupported package: expected an EPUB, PDF, audiobook or Divina package")
+
+// ErrFileTooLarge is returned when a file of a package exceeds maxFileSize once uncompressed
+var ErrFileTooLarge = errors.New("a file of the package is too large")
+
+// maxFileSize is the maximum uncompressed size of a file read from a package (package document, manifest or cover)
+const maxFileSize = 20 << 20
+
+// ErrPublicationExists is returned when the identifier of a package is already used by a publication
+var ErrPublicationExists = errors.New("a publication with the same identifier already exists")
+
+// Package is a publication package and the metadata extracted from it
+type Package struct {
+	Publication stor.Publication
+	// Cover image and its media type, empty if the package has no cover
+	Cover     []byte
+	CoverType string
+}
+
+// Ingester creates publications from packages
+type Ingester struct {
+	*conf.Config
+	*stor.Store
+	Encrypter Encrypter
+}
+
+// New returns an ingester; packages are encrypted with the tool set in the configuration, if any
+func New(c *conf.Config, s *stor.Store) *Ingester {
+	var encrypter Encrypter = NoEncryption{}
+	if c.Ingest.Encrypt != "" {
+		encrypter = &LcpEncrypt{Config: c}
+	}
+	return &Ingester{Config: c, Store: s, Encrypter: encrypter}
+}
+
+// Parse extracts the metadata and cover of a package; its format is inferred from its content.
+// The publication gets the identifier of the package if it is a UUID, a new UUID otherwise.
+func Parse(r io.ReaderAt, size int64) (*Package, error) {
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, ErrUnsupportedPackage
+	}
+
+	var pkg *Package
+	if findFile(zr, "META-INF/container.xml") != nil {
+		pkg, err = parseEPUB(zr)
+	} else if findFile(zr, "manifest.json") != nil {
+		pkg, err = parseManifest(zr)
+	} else {
+		return nil, ErrUnsupportedPackage
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if pkg.Publication.Title == "" {
+		return nil, errors.New("the package has no title")
+	}
+	if _, err := uuid.Parse(pkg.Publication.UUID); err != nil || pkg.Publication.Validate() != nil {
+		pkg.Publication.UUID = uuid.New().String()
+	}
+	return pkg, nil
+}
+
+// IngestFile parses the package stored at a path, hands it to the encryption step,
+// then creates the related publication and stores its cover in the resources directory.
+func (i *Ingester) IngestFile(ctx context.Context, filePath string) (*stor.Publication, error) {
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	pkg, err := Parse(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	publication := &pkg.Publication
+
+	if _, err = i.Store.GetPublication(publication.UUID); err == nil {
+		return nil, ErrPublicationExists
+	}
+
+	if err = i.Encrypter.Encrypt(ctx, filePath, publication); err != nil {
+		return nil, fmt.Errorf("encryption failed: %w", err)
+	}
+
+	if err = i.Store.CreatePublication(publication); err != nil {
+		return nil, err
+	}
+
+	if len(pkg.Cover) > 0 {
+		if err = cover.Save(i.Config, i.Store, publication, pkg.Cover, pkg.CoverType); err != nil {
+			fmt.Println("Failed to store the cover: " + err.Error())
+		}
+	}
+	return publication, nil
+}
+
+// Ingest stores an uploaded package in the upload directory, then ingests it.
+// filename is the name of the uploaded file; only its extension is kept.
+func (i *Ingester) Ingest(ctx context.Context, r io.Reader, filename string) (*stor.Publication, error) {
+
+	if err := os.MkdirAll(i.Config.Ingest.UploadDir, 0o755); err != nil {
+		return nil, err
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	f, err := os.CreateTemp(i.Config.Ingest.UploadDir, "upload-*"+ext)
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	publication, err := i.IngestFile(ctx, f.Name())
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	// the package is kept under the identifier of the publication
+	if err = os.Rename(f.Name(), filepath.Join(i.Config.Ingest.UploadDir, publication.UUID+ext)); err != nil {
+		fmt.Println("Failed to rename the package: " + err.Error())
+	}
+	return publication, nil
+}
+
+// findFile returns a file of a zip archive, nil if not found
+func findFile(zr *zip.Reader, name string) *zip.File {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	for _, f := range zr.File {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// readFile returns the content of a file of a zip archive
+func readFile(zr *zip.Reader, name string) ([]byte, error) {
+	f := findFile(zr, name)
+	if f == nil {
+		return nil, fmt.Errorf("%s not found in the package", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	// the size declared in the zip header cannot be trusted, the read itself is limited
+	data, err := io.ReadAll(io.LimitReader(rc, maxFileSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxFileSize {
+		return nil, fmt.Errorf("%s: %w", name, ErrFileTooLarge)
+	}
+	return data, nil
+}
+
+// languageCode returns the two letter code of a BCP 47 language tag, or an empty string
+func languageCode(tag string) string {
+	code := strings.ToLower(strings.TrimSpace(strings.SplitN(tag, "-", 2)[0]))
+	if len(code) != 2 {
+		return ""
+	}
+	return code
+}
+
+// addLanguage appends a language to a publication, ignoring duplicates and codes of more than two letters
+func addLanguage(publication *stor.Publication, tag string) {
+	code := languageCode(tag)
+	if code == "" {
+		return
+	}
+	for _, l := range publication.Language {
+		if l.Code == code {
+			return
+		}
+	}
+	publication.Language = append(publication.Language, stor.Language{Code: code})
+}
@@ -0,0 +1,79 @@
+package ingest
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newPackage returns a zip archive holding files indexed by their path
+func newPackage(t *testing.T, files map[string][]byte) *bytes.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		_, err = w.Write(content)
+		assert.NoError(t, err)
+	}
+	if !assert.NoError(t, zw.Close()) {
+		t.FailNow()
+	}
+	return bytes.NewReader(buf.Bytes())
+}
+
+// parse parses a package built from files
+func parse(t *testing.T, files map[string][]byte) (*Package, error) {
+	r := newPackage(t, files)
+	return Parse(r, r.Size())
+}
+
+func TestParseUnsupported(t *testing.T) {
+
+	// not a zip archive
+	data := []byte("%PDF-1.7")
+	_, err := Parse(bytes.NewReader(data), int64(len(data)))
+	assert.ErrorIs(t, err, ErrUnsupportedPackage)
+
+	// neither an EPUB container nor a manifest
+	_, err = parse(t, map[string][]byte{"mimetype": []byte("application/zip")})
+	assert.ErrorIs(t, err, ErrUnsupportedPackage)
+}
+
+func TestReadFileLimit(t *testing.T) {
+
+	r := newPackage(t, map[string][]byte{
+		"small.txt": []byte("content"),
+		"large.bin": make([]byte, maxFileSize+1),
+		"limit.bin": make([]byte, maxFileSize),
+	})
+	zr, err := zip.NewReader(r, r.Size())
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	data, err := readFile(zr, "/small.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	data, err = readFile(zr, "limit.bin")
+	assert.NoError(t, err)
+	assert.Len(t, data, maxFileSize)
+
+	_, err = readFile(zr, "large.bin")
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+
+	_, err = readFile(zr, "missing.txt")
+	assert.Error(t, err)
+}
+
+func TestLanguageCode(t *testing.T) {
+	assert.Equal(t, "fr", languageCode("fr"))
+	assert.Equal(t, "en", languageCode(" EN-us "))
+	assert.Equal(t, "", languageCode("fra"))
+	assert.Equal(t, "", languageCode(""))
+}
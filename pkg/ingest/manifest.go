@@ -0,0 +1,209 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package ingest
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/edrlab/pubstore/pkg/stor"
+)
+
+// Profiles of Readium Web Publication Manifests
+const (
+	profileAudiobook = "https://readium.org/webpub-manifest/profiles/audiobook"
+	profileDivina    = "https://readium.org/webpub-manifest/profiles/divina"
+	profilePDF       = "https://readium.org/webpub-manifest/profiles/pdf"
+)
+
+// manifest is the Readium Web Publication Manifest of a package
+type manifest struct {
+	Metadata struct {
+		ConformsTo  stringList   `json:"conformsTo"`
+		Identifier  string       `json:"identifier"`
+		Title       localized    `json:"title"`
+		Author      contributors `json:"author"`
+		Publisher   contributors `json:"publisher"`
+		Language    stringList   `json:"language"`
+		Subject     contributors `json:"subject"`
+		Description string       `json:"description"`
+		Published   string       `json:"published"`
+	} `json:"metadata"`
+	Links        []manifestLink `json:"links"`
+	ReadingOrder []manifestLink `json:"readingOrder"`
+	Resources    []manifestLink `json:"resources"`
+}
+
+type manifestLink struct {
+	Href string     `json:"href"`
+	Type string     `json:"type"`
+	Rel  stringList `json:"rel"`
+}
+
+// stringList is a string or an array of strings
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*l = stringList{s}
+		return nil
+	}
+	var a []string
+	if err := json.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	*l = a
+	return nil
+}
+
+// localized is a string or a map of strings per language; the english or first value is kept
+type localized string
+
+func (s *localized) UnmarshalJSON(data []byte) error {
+	var str string
+	if err := json.Unmarshal(data, &str); err == nil {
+		*s = localized(str)
+		return nil
+	}
+	var m map[string]string
+	if err := json.Unmarshal(data, &m); err != nil {
+		return err
+	}
+	if v, ok := m["en"]; ok {
+		*s = localized(v)
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(keys) > 0 {
+		*s = localized(m[keys[0]])
+	}
+	return nil
+}
+
+// contributors is a name, an object with a name, or an array of these; only names are kept
+type contributors []string
+
+func (c *contributors) UnmarshalJSON(data []byte) error {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		items = []json.RawMessage{data}
+	}
+	for _, item := range items {
+		var name string
+		if err := json.Unmarshal(item, &name); err != nil {
+			var obj struct {
+				Name localized `json:"name"`
+			}
+			if err := json.Unmarshal(item, &obj); err != nil {
+				return err
+			}
+			name = string(obj.Name)
+		}
+		if n := strings.TrimSpace(name); n != "" {
+			*c = append(*c, n)
+		}
+	}
+	return nil
+}
+
+// parseManifest extracts the metadata and cover of a Readium package (PDF, audiobook or Divina) from its manifest
+func parseManifest(zr *zip.Reader) (*Package, error) {
+
+	data, err := readFile(zr, "manifest.json")
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	md := m.Metadata
+
+	pkg := &Package{}
+	pub := &pkg.Publication
+	pub.ContentType = manifestContentType(&m)
+	if pub.ContentType == "" {
+		return nil, ErrUnsupportedPackage
+	}
+	pub.Title = strings.TrimSpace(string(md.Title))
+	pub.Description = strings.TrimSpace(md.Description)
+	pub.DatePublished = strings.TrimSpace(md.Published)
+	pub.UUID = strings.TrimPrefix(strings.TrimSpace(md.Identifier), "urn:uuid:")
+	for _, name := range md.Author {
+		pub.Author = append(pub.Author, stor.Author{Name: name})
+	}
+	for _, name := range md.Publisher {
+		pub.Publisher = append(pub.Publisher, stor.Publisher{Name: name})
+	}
+	for _, language := range md.Language {
+		addLanguage(pub, language)
+	}
+	for _, name := range md.Subject {
+		pub.Category = append(pub.Category, stor.Category{Name: name})
+	}
+
+	// the cover is a link or resource with the cover relation
+	for _, link := range append(append(m.Links, m.Resources...), m.ReadingOrder...) {
+		if !link.hasRel("cover") || !strings.HasPrefix(link.Type, "image/") {
+			continue
+		}
+		href, err := url.PathUnescape(link.Href)
+		if err != nil {
+			href = link.Href
+		}
+		if pkg.Cover, err = readFile(zr, href); err == nil {
+			pkg.CoverType = link.Type
+			break
+		}
+	}
+
+	return pkg, nil
+}
+
+func (l manifestLink) hasRel(rel string) bool {
+	for _, r := range l.Rel {
+		if r == rel {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestContentType returns the content type of the publication described by a manifest,
+// from its profile or the media type of its reading order
+func manifestContentType(m *manifest) string {
+
+	for _, profile := range m.Metadata.ConformsTo {
+		switch profile {
+		case profileAudiobook:
+			return ContentTypeAudiobook
+		case profileDivina:
+			return ContentTypeDivina
+		case profilePDF:
+			return ContentTypePDF
+		}
+	}
+	if len(m.ReadingOrder) == 0 {
+		return ""
+	}
+	mediaType := m.ReadingOrder[0].Type
+	switch {
+	case mediaType == "application/pdf":
+		return ContentTypePDF
+	case strings.HasPrefix(mediaType, "audio/"):
+		return ContentTypeAudiobook
+	case strings.HasPrefix(mediaType, "image/"):
+		return ContentTypeDivina
+	}
+	return ""
+}
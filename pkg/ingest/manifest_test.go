@@ -0,0 +1,135 @@
+package ingest
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const audiobookManifest = `{
+  "@context": "https://readium.org/webpub-manifest/context.jsonld",
+  "metadata": {
+    "conformsTo": "https://readium.org/webpub-manifest/profiles/audiobook",
+    "identifier": "urn:uuid:0b7a1c5e-7d43-4f0e-8d4b-6c7e2f3a9b10",
+    "title": {"fr": "Vingt mille lieues sous les mers", "en": "Twenty Thousand Leagues Under the Seas"},
+    "author": [{"name": "Jules Verne"}, "Anonymous reader"],
+    "publisher": {"name": {"fr": "Hetzel"}},
+    "language": ["en", "fr-FR"],
+    "subject": "Adventure",
+    "description": " A submarine voyage. ",
+    "published": "1870"
+  },
+  "links": [{"rel": "self", "href": "manifest.json", "type": "application/audiobook+json"}],
+  "readingOrder": [{"href": "audio/chapter1.mp3", "type": "audio/mpeg"}],
+  "resources": [{"rel": ["cover"], "href": "images/cover%201.jpg", "type": "image/jpeg"}]
+}`
+
+const pdfManifest = `{
+  "metadata": {
+    "identifier": "isbn:9780000000002",
+    "title": "Annual report",
+    "author": "EDRLab"
+  },
+  "readingOrder": [{"href": "report.pdf", "type": "application/pdf"}],
+  "links": [{"rel": "cover", "href": "missing.png", "type": "image/png"}]
+}`
+
+func TestParseAudiobook(t *testing.T) {
+
+	pkg, err := parse(t, map[string][]byte{
+		"manifest.json":      []byte(audiobookManifest),
+		"audio/chapter1.mp3": []byte("mp3"),
+		"images/cover 1.jpg": []byte("jpeg"),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	pub := pkg.Publication
+	assert.Equal(t, ContentTypeAudiobook, pub.ContentType)
+	assert.Equal(t, "0b7a1c5e-7d43-4f0e-8d4b-6c7e2f3a9b10", pub.UUID)
+	// the english title is preferred
+	assert.Equal(t, "Twenty Thousand Leagues Under the Seas", pub.Title)
+	assert.Equal(t, "A submarine voyage.", pub.Description)
+	assert.Equal(t, "1870", pub.DatePublished)
+	if assert.Len(t, pub.Author, 2) {
+		assert.Equal(t, "Jules Verne", pub.Author[0].Name)
+		assert.Equal(t, "Anonymous reader", pub.Author[1].Name)
+	}
+	if assert.Len(t, pub.Publisher, 1) {
+		assert.Equal(t, "Hetzel", pub.Publisher[0].Name)
+	}
+	if assert.Len(t, pub.Language, 2) {
+		assert.Equal(t, "en", pub.Language[0].Code)
+		assert.Equal(t, "fr", pub.Language[1].Code)
+	}
+	if assert.Len(t, pub.Category, 1) {
+		assert.Equal(t, "Adventure", pub.Category[0].Name)
+	}
+	assert.Equal(t, "jpeg", string(pkg.Cover))
+	assert.Equal(t, "image/jpeg", pkg.CoverType)
+}
+
+func TestParsePDF(t *testing.T) {
+
+	pkg, err := parse(t, map[string][]byte{
+		"manifest.json": []byte(pdfManifest),
+		"report.pdf":    []byte("%PDF-1.7"),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	pub := pkg.Publication
+	// without a profile, the content type is inferred from the reading order
+	assert.Equal(t, ContentTypePDF, pub.ContentType)
+	assert.Equal(t, "Annual report", pub.Title)
+	assert.NotEqual(t, "isbn:9780000000002", pub.UUID)
+	if assert.Len(t, pub.Author, 1) {
+		assert.Equal(t, "EDRLab", pub.Author[0].Name)
+	}
+	// a cover missing from the package is ignored
+	assert.Empty(t, pkg.Cover)
+	assert.Empty(t, pkg.CoverType)
+}
+
+func TestManifestContentType(t *testing.T) {
+
+	cases := []struct {
+		name     string
+		manifest string
+		expected string
+	}{
+		{"divina profile", `{"metadata": {"conformsTo": ["https://readium.org/webpub-manifest/profiles/divina"]}}`, ContentTypeDivina},
+		{"pdf profile", `{"metadata": {"conformsTo": "https://readium.org/webpub-manifest/profiles/pdf"}}`, ContentTypePDF},
+		{"images", `{"readingOrder": [{"href": "page1.jpg", "type": "image/jpeg"}]}`, ContentTypeDivina},
+		{"audio", `{"readingOrder": [{"href": "track1.ogg", "type": "audio/ogg"}]}`, ContentTypeAudiobook},
+		{"pdf", `{"readingOrder": [{"href": "doc.pdf", "type": "application/pdf"}]}`, ContentTypePDF},
+		{"html", `{"readingOrder": [{"href": "index.html", "type": "text/html"}]}`, ""},
+		{"empty", `{}`, ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var m manifest
+			if assert.NoError(t, json.Unmarshal([]byte(c.manifest), &m)) {
+				assert.Equal(t, c.expected, manifestContentType(&m))
+			}
+		})
+	}
+}
+
+func TestParseManifestErrors(t *testing.T) {
+
+	// a web publication which is neither a PDF, an audiobook nor a Divina
+	_, err := parse(t, map[string][]byte{
+		"manifest.json": []byte(`{"metadata": {"title": "Web"}, "readingOrder": [{"href": "index.html", "type": "text/html"}]}`),
+	})
+	assert.ErrorIs(t, err, ErrUnsupportedPackage)
+
+	// the manifest is not valid JSON
+	_, err = parse(t, map[string][]byte{"manifest.json": []byte(`{"metadata": `)})
+	assert.Error(t, err)
+
+	// the manifest is too large to be read
+	_, err = parse(t, map[string][]byte{"manifest.json": make([]byte, maxFileSize+1)})
+	assert.ErrorIs(t, err, ErrFileTooLarge)
+}
@@ -0,0 +1,195 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/stor"
+)
+
+// maxCacheEntries bounds the number of entries of each cache
+const maxCacheEntries = 10000
+
+// maxStatusWorkers bounds the number of concurrent requests sent to the License Status Server
+const maxStatusWorkers = 8
+
+// statusEntry is a status document kept in cache, with the validators returned by the License Status Server
+type statusEntry struct {
+	doc          StatusDoc
+	etag         string
+	lastModified string
+	fetchedAt    time.Time
+}
+
+// licenseEntry holds the rights of a license kept in cache.
+// They only change when the license is updated, which is indicated by the status document.
+type licenseEntry struct {
+	statusUrl string
+	print     int
+	copy      int
+	start     time.Time
+	end       time.Time
+	fetchedAt time.Time
+}
+
+// cache is a map of entries safe for concurrent use
+type cache[T any] struct {
+	sync.Mutex
+	entries map[string]T
+}
+
+var statusCache = &cache[statusEntry]{entries: make(map[string]statusEntry)}
+var licenseCache = &cache[licenseEntry]{entries: make(map[string]licenseEntry)}
+
+func (c *cache[T]) get(key string) (T, bool) {
+	c.Lock()
+	defer c.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *cache[T]) set(key string, entry T) {
+	c.Lock()
+	defer c.Unlock()
+	// drop arbitrary entries when the cache is full
+	for k := range c.entries {
+		if len(c.entries) < maxCacheEntries {
+			break
+		}
+		delete(c.entries, k)
+	}
+	c.entries[key] = entry
+}
+
+// getCachedStatusDoc returns the status document found at a url.
+// A cached document is returned during the cache ttl, then revalidated using its ETag and Last-Modified validators.
+// If the License Status Server is unavailable, a stale cached document is returned.
+func getCachedStatusDoc(ctx context.Context, lcpsv conf.LCPServerAccess, statusUrl string) (StatusDoc, error) {
+
+	entry, ok := statusCache.get(statusUrl)
+	if ok && time.Since(entry.fetchedAt) < time.Duration(lcpsv.StatusCacheTTL)*time.Second {
+		return entry.doc, nil
+	}
+
+	header := http.Header{}
+	if ok && entry.etag != "" {
+		header.Set("If-None-Match", entry.etag)
+	}
+	if ok && entry.lastModified != "" {
+		header.Set("If-Modified-Since", entry.lastModified)
+	}
+
+	resp, body, err := send(ctx, lcpsv, request{method: "GET", url: statusUrl, header: header, retry: true})
+	if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+		err = fmt.Errorf("the status document request failed. Status code: %d", resp.StatusCode)
+	}
+	if err != nil {
+		if ok {
+			fmt.Printf("Status document %s is stale: %s\n", statusUrl, err.Error())
+			return entry.doc, nil
+		}
+		return StatusDoc{}, err
+	}
+
+	// the cached document is still valid
+	if ok && resp.StatusCode == http.StatusNotModified {
+		entry.fetchedAt = time.Now()
+		statusCache.set(statusUrl, entry)
+		return entry.doc, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return StatusDoc{}, fmt.Errorf("the status document request failed. Status code: %d", resp.StatusCode)
+	}
+
+	doc, err := ParseStatusDoc(body)
+	if err != nil {
+		return StatusDoc{}, err
+	}
+
+	statusCache.set(statusUrl, statusEntry{
+		doc:          *doc,
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		fetchedAt:    time.Now(),
+	})
+	return *doc, nil
+}
+
+// cacheStatusDoc keeps a status document returned by an interaction with the License Status Server.
+// It has no validator, therefore it will be fetched again once its ttl is over.
+func cacheStatusDoc(statusUrl string, doc StatusDoc) {
+	statusCache.set(statusUrl, statusEntry{doc: doc, fetchedAt: time.Now()})
+}
+
+// getLicenseRights fetches a fresh license from the License Server and keeps its rights in cache
+func getLicenseRights(ctx context.Context, lcpsv conf.LCPServerAccess, transaction *stor.Transaction) (licenseEntry, error) {
+
+	licenceBytes, err := GetFreshLicense(ctx, lcpsv, transaction)
+	if err != nil {
+		return licenseEntry{}, err
+	}
+
+	license, err := ParseLicense(licenceBytes)
+	if err != nil {
+		return licenseEntry{}, err
+	}
+
+	entry := licenseEntry{fetchedAt: time.Now()}
+	if status, ok := license.Link("status"); ok {
+		entry.statusUrl = status.Href
+	}
+	if license.Rights.Print != nil {
+		entry.print = *license.Rights.Print
+	}
+	if license.Rights.Copy != nil {
+		entry.copy = *license.Rights.Copy
+	}
+	if license.Rights.Start != nil {
+		entry.start = *license.Rights.Start
+	}
+	if license.Rights.End != nil {
+		entry.end = *license.Rights.End
+	}
+	licenseCache.set(transaction.LicenceId, entry)
+	return entry, nil
+}
+
+// GetStatusDocuments returns the status of a list of transactions, fetched concurrently by a bounded pool of workers.
+// The status of a transaction is its recorded status if it could not be fetched; the returned error joins every failure.
+func GetStatusDocuments(ctx context.Context, lcpsv conf.LCPServerAccess, transactions []stor.Transaction) ([]*LsdStatus, error) {
+
+	statuses := make([]*LsdStatus, len(transactions))
+	errs := make([]error, len(transactions))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(maxStatusWorkers, len(transactions)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				statuses[i], errs[i] = GetStatusDocument(ctx, lcpsv, &transactions[i])
+				if errs[i] != nil {
+					statuses[i] = RecordedStatus(&transactions[i], errs[i])
+					errs[i] = fmt.Errorf("license %s: %w", transactions[i].LicenceId, errs[i])
+				}
+			}
+		}()
+	}
+	for i := range transactions {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return statuses, errors.Join(errs...)
+}
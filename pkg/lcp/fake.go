@@ -0,0 +1,376 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lcp
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/google/uuid"
+)
+
+// Algorithms and profile used by the fake license provider
+const (
+	BasicProfile       = "http://readium.org/lcp/basic-profile"
+	AlgorithmAES256CBC = "http://www.w3.org/2001/04/xmlenc#aes256-cbc"
+	AlgorithmSHA256    = "http://www.w3.org/2001/04/xmlenc#sha256"
+)
+
+// ErrUnknownLicense is returned by the fake license provider for a license it did not issue
+var ErrUnknownLicense = errors.New("unknown license")
+
+// Fake is an in-process license provider, meant for tests and demo deployments.
+// Its licenses and status documents are structurally valid and its licenses are signed by a self-signed certificate,
+// but no publication is encrypted and its links are not served: a reading application cannot open its licenses.
+// Licenses are kept in memory and lost when the process stops.
+type Fake struct {
+	sync.Mutex
+	baseUrl  string
+	key      *ecdsa.PrivateKey
+	cert     []byte
+	licenses map[string]*License
+	statuses map[string]*StatusDoc
+}
+
+// NewFake returns a fake license provider; baseUrl is used as provider and as prefix of the links of its documents
+func NewFake(baseUrl string) (*Fake, error) {
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: "Pubstore fake license provider"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.AddDate(10, 0, 0),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Fake{
+		baseUrl:  baseUrl,
+		key:      key,
+		cert:     cert,
+		licenses: make(map[string]*License),
+		statuses: make(map[string]*StatusDoc),
+	}, nil
+}
+
+// Certificate returns the DER encoded certificate which signs the licenses
+func (f *Fake) Certificate() []byte {
+	return f.cert
+}
+
+func (f *Fake) GenerateLicense(ctx context.Context, licenseReq LicenseRequest) ([]byte, error) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	now := time.Now().UTC().Truncate(time.Second)
+	id := uuid.New().String()
+	license := &License{
+		Provider: f.baseUrl,
+		ID:       id,
+		Issued:   now,
+		Links: []Link{
+			{Rel: "hint", Href: f.baseUrl + "/", Type: "text/html"},
+			{Rel: "publication", Href: f.baseUrl + "/fake-lcp/publications/" + licenseReq.PublicationID, Type: "application/epub+zip"},
+			{Rel: "status", Href: f.statusUrl(id), Type: "application/vnd.readium.license.status.v1.0+json"},
+		},
+		User: User{
+			ID:        licenseReq.UserID,
+			Email:     licenseReq.UserEmail,
+			Name:      licenseReq.UserName,
+			Encrypted: licenseReq.UserEncrypted,
+		},
+		Rights: Rights{
+			Print: licenseReq.Print,
+			Copy:  licenseReq.Copy,
+			Start: licenseReq.Start,
+			End:   licenseReq.End,
+		},
+	}
+	contentKey := make([]byte, 32)
+	if _, err := rand.Read(contentKey); err != nil {
+		return nil, err
+	}
+	if err := f.encrypt(license, licenseReq.TextHint, licenseReq.PassHash, contentKey); err != nil {
+		return nil, err
+	}
+
+	f.licenses[id] = license
+	f.statuses[id] = &StatusDoc{
+		ID:      id,
+		Status:  StatusReady,
+		Message: "The license is ready",
+		Updated: StatusUpdated{License: now, Status: now},
+		Links: []Link{
+			{Rel: "license", Href: f.baseUrl + "/fake-lcp/licenses/" + id, Type: "application/vnd.readium.lcp.license.v1.0+json"},
+			{Rel: "return", Href: f.statusUrl(id) + "/return{?id,name}", Templated: true},
+			{Rel: "renew", Href: f.statusUrl(id) + "/renew{?end,id,name}", Templated: true},
+		},
+	}
+	return f.sign(license)
+}
+
+func (f *Fake) GetFreshLicense(ctx context.Context, transaction *stor.Transaction) ([]byte, error) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	license, ok := f.licenses[transaction.LicenceId]
+	if !ok {
+		return nil, ErrUnknownLicense
+	}
+	// the user passphrase may have changed since the license was issued
+	if transaction.User.HPassphrase != "" {
+		contentKey := make([]byte, 32)
+		if _, err := rand.Read(contentKey); err != nil {
+			return nil, err
+		}
+		if err := f.encrypt(license, transaction.User.TextHint, transaction.User.HPassphrase, contentKey); err != nil {
+			return nil, err
+		}
+		now := time.Now().UTC().Truncate(time.Second)
+		license.Updated = &now
+	}
+	return f.sign(license)
+}
+
+func (f *Fake) GetStatusDocument(ctx context.Context, transaction *stor.Transaction) (*LsdStatus, error) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	lsd, license, err := f.status(transaction.LicenceId)
+	if err != nil {
+		return nil, err
+	}
+	status := &LsdStatus{
+		StatusMessage:      lsd.Message,
+		StatusCode:         lsd.Status,
+		EndPotentialRights: lsd.PotentialRights.End,
+	}
+	if license.Rights.Print != nil {
+		status.PrintLimit = *license.Rights.Print
+	}
+	if license.Rights.Copy != nil {
+		status.CopyLimit = *license.Rights.Copy
+	}
+	if license.Rights.Start != nil {
+		status.StartDate = *license.Rights.Start
+	}
+	if license.Rights.End != nil {
+		status.EndDate = *license.Rights.End
+	}
+	return status, nil
+}
+
+func (f *Fake) GetStatusDocuments(ctx context.Context, transactions []stor.Transaction) ([]*LsdStatus, error) {
+
+	statuses := make([]*LsdStatus, len(transactions))
+	errs := make([]error, len(transactions))
+	for i := range transactions {
+		statuses[i], errs[i] = f.GetStatusDocument(ctx, &transactions[i])
+		if errs[i] != nil {
+			statuses[i] = RecordedStatus(&transactions[i], errs[i])
+			errs[i] = fmt.Errorf("license %s: %w", transactions[i].LicenceId, errs[i])
+		}
+	}
+	return statuses, errors.Join(errs...)
+}
+
+func (f *Fake) ReturnLicense(ctx context.Context, transaction *stor.Transaction) (*StatusDoc, error) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	lsd, license, err := f.status(transaction.LicenceId)
+	if err != nil {
+		return nil, err
+	}
+	if license.Rights.End == nil || (lsd.Status != StatusReady && lsd.Status != StatusActive) {
+		return nil, errors.New("the license cannot be returned")
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	license.Rights.End = &now
+	license.Updated = &now
+	f.update(lsd, StatusReturned, "The license has been returned", "return", now)
+	doc := *lsd
+	return &doc, nil
+}
+
+func (f *Fake) RenewLicense(ctx context.Context, transaction *stor.Transaction, end time.Time) (*StatusDoc, error) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	lsd, license, err := f.status(transaction.LicenceId)
+	if err != nil {
+		return nil, err
+	}
+	if license.Rights.End == nil || (lsd.Status != StatusReady && lsd.Status != StatusActive) {
+		return nil, errors.New("the license cannot be renewed")
+	}
+	if !end.After(*license.Rights.End) {
+		return nil, errors.New("the new end date must be after the current one")
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	end = end.UTC()
+	license.Rights.End = &end
+	license.Updated = &now
+	f.update(lsd, StatusActive, "The license has been renewed", "renew", now)
+	doc := *lsd
+	return &doc, nil
+}
+
+func (f *Fake) RevokeLicense(ctx context.Context, transaction *stor.Transaction, message string) (*StatusDoc, error) {
+
+	f.Lock()
+	defer f.Unlock()
+
+	lsd, _, err := f.status(transaction.LicenceId)
+	if err != nil {
+		return nil, err
+	}
+	if lsd.Status != StatusReady && lsd.Status != StatusActive {
+		return nil, errors.New("the license cannot be revoked")
+	}
+	if message == "" {
+		message = "The license has been revoked"
+	}
+	f.update(lsd, StatusRevoked, message, "revoke", time.Now().UTC().Truncate(time.Second))
+	doc := *lsd
+	return &doc, nil
+}
+
+// status returns the status document and the license of a license id.
+// A license which rights have ended is expired.
+func (f *Fake) status(licenseID string) (*StatusDoc, *License, error) {
+
+	lsd, ok := f.statuses[licenseID]
+	if !ok {
+		return nil, nil, ErrUnknownLicense
+	}
+	license := f.licenses[licenseID]
+	if (lsd.Status == StatusReady || lsd.Status == StatusActive) && license.Rights.End != nil && license.Rights.End.Before(time.Now()) {
+		lsd.Status = StatusExpired
+		lsd.Message = "The license has expired"
+		lsd.Updated.Status = *license.Rights.End
+	}
+	return lsd, license, nil
+}
+
+// update changes the status of a license and records the related event
+func (f *Fake) update(lsd *StatusDoc, status, message, event string, now time.Time) {
+
+	lsd.Status = status
+	lsd.Message = message
+	lsd.Updated.Status = now
+	lsd.Events = append(lsd.Events, Event{Type: event, Name: "pubstore", DeviceID: "pubstore", Timestamp: now})
+	if event != "revoke" {
+		lsd.Updated.License = now
+	}
+}
+
+func (f *Fake) statusUrl(licenseID string) string {
+	return f.baseUrl + "/fake-lcp/licenses/" + licenseID + "/status"
+}
+
+// encrypt sets the encryption properties of a license: the content key and the key check are encrypted with the user key,
+// i.e. the hex encoded hash of the user passphrase.
+func (f *Fake) encrypt(license *License, textHint, passHash string, contentKey []byte) error {
+
+	userKey, err := hex.DecodeString(passHash)
+	if err != nil || len(userKey) != 32 {
+		return errors.New("the passphrase hash must be a hex encoded SHA-256 value")
+	}
+	encryptedKey, err := encryptAES(userKey, contentKey)
+	if err != nil {
+		return err
+	}
+	keyCheck, err := encryptAES(userKey, []byte(license.ID))
+	if err != nil {
+		return err
+	}
+	license.Encryption = Encryption{
+		Profile: BasicProfile,
+		ContentKey: &ContentKey{
+			Algorithm:      AlgorithmAES256CBC,
+			EncryptedValue: base64.StdEncoding.EncodeToString(encryptedKey),
+		},
+		UserKey: UserKey{
+			Algorithm: AlgorithmSHA256,
+			TextHint:  textHint,
+			KeyCheck:  base64.StdEncoding.EncodeToString(keyCheck),
+		},
+	}
+	return nil
+}
+
+// sign signs a license with the key of the fake provider and returns its serialization
+func (f *Fake) sign(license *License) ([]byte, error) {
+
+	license.Signature = Signature{}
+	data, err := json.Marshal(license)
+	if err != nil {
+		return nil, err
+	}
+	canonical, err := Canonicalize(data)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(canonical)
+	r, s, err := ecdsa.Sign(rand.Reader, f.key, hash[:])
+	if err != nil {
+		return nil, err
+	}
+	size := (f.key.Curve.Params().BitSize + 7) / 8
+	value := append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+	license.Signature = Signature{
+		Certificate: base64.StdEncoding.EncodeToString(f.cert),
+		Value:       base64.StdEncoding.EncodeToString(value),
+		Algorithm:   AlgorithmECDSASHA256,
+	}
+	return json.Marshal(license)
+}
+
+// encryptAES encrypts data using AES-256-CBC with PKCS#7 padding; the IV prefixes the result
+func encryptAES(key, data []byte) ([]byte, error) {
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	data = append(data, bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	out := make([]byte, aes.BlockSize+len(data))
+	if _, err = rand.Read(out[:aes.BlockSize]); err != nil {
+		return nil, err
+	}
+	cipher.NewCBCEncrypter(block, out[:aes.BlockSize]).CryptBlocks(out[aes.BlockSize:], data)
+	return out, nil
+}
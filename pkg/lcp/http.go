@@ -0,0 +1,179 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/edrlab/pubstore/pkg/conf"
+)
+
+// ErrServerUnavailable is returned without sending a request while the circuit breaker of a server is open
+var ErrServerUnavailable = errors.New("the License Server is unavailable")
+
+// retryBaseDelay is the delay before the first retry; it doubles at each retry
+const retryBaseDelay = 200 * time.Millisecond
+
+// breaker is a circuit breaker: it opens after a number of consecutive failures, then lets a single request
+// through once its cooldown is over. The circuit closes again when that request succeeds.
+type breaker struct {
+	sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// breakers holds a circuit breaker per server host
+var breakers sync.Map
+
+func getBreaker(rawUrl string) *breaker {
+	host := rawUrl
+	if u, err := url.Parse(rawUrl); err == nil {
+		host = u.Host
+	}
+	b, _ := breakers.LoadOrStore(host, &breaker{})
+	return b.(*breaker)
+}
+
+// allow indicates if a request can be sent
+func (b *breaker) allow(threshold int) bool {
+	b.Lock()
+	defer b.Unlock()
+	if threshold <= 0 || b.failures < threshold {
+		return true
+	}
+	if b.probing || time.Now().Before(b.openUntil) {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// record records the outcome of a request
+func (b *breaker) record(failed bool, threshold int, cooldown time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+	b.probing = false
+	if !failed {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if threshold > 0 && b.failures >= threshold {
+		b.openUntil = time.Now().Add(cooldown)
+	}
+}
+
+// abort releases the breaker after a request abandoned by the caller, which says nothing about the server
+func (b *breaker) abort() {
+	b.Lock()
+	defer b.Unlock()
+	b.probing = false
+}
+
+// request describes a request sent to a License Server or License Status Server
+type request struct {
+	method  string
+	url     string
+	payload []byte
+	header  http.Header
+	// auth indicates if the request is authenticated with the License Server credentials
+	auth bool
+	// retry indicates if the request can safely be sent again after a failure
+	retry bool
+}
+
+// send sends a request with the timeout set in the configuration. Requests flagged for retry are sent again,
+// with an exponential backoff, after a network error or a 5xx status code.
+// The response body is read and closed; it is returned with the response.
+func send(ctx context.Context, lcpsv conf.LCPServerAccess, r request) (*http.Response, []byte, error) {
+
+	b := getBreaker(r.url)
+	if !b.allow(lcpsv.BreakerThreshold) {
+		return nil, nil, ErrServerUnavailable
+	}
+	cooldown := time.Duration(lcpsv.BreakerCooldown) * time.Second
+
+	// a negative timeout means no timeout
+	client := &http.Client{}
+	if lcpsv.Timeout > 0 {
+		client.Timeout = time.Duration(lcpsv.Timeout) * time.Second
+	}
+	attempts := 1
+	if r.retry && lcpsv.Retries > 0 {
+		attempts += lcpsv.Retries
+	}
+
+	var resp *http.Response
+	var body []byte
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			delay := retryBaseDelay << (attempt - 1)
+			delay += time.Duration(rand.Int63n(int64(delay) / 2))
+			select {
+			case <-ctx.Done():
+				b.abort()
+				return nil, nil, ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		resp, body, err = sendOnce(ctx, client, lcpsv, r)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			break
+		}
+		// the caller gave up
+		if ctx.Err() != nil {
+			b.abort()
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+	b.record(failed, lcpsv.BreakerThreshold, cooldown)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s %s: %w", r.method, r.url, err)
+	}
+	return resp, body, nil
+}
+
+func sendOnce(ctx context.Context, client *http.Client, lcpsv conf.LCPServerAccess, r request) (*http.Response, []byte, error) {
+
+	var payload io.Reader
+	if r.payload != nil {
+		payload = bytes.NewReader(r.payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, r.method, r.url, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range r.header {
+		req.Header[k] = v
+	}
+	if r.auth {
+		req.SetBasicAuth(lcpsv.UserName, lcpsv.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, body, nil
+}
@@ -0,0 +1,203 @@
+package lcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+// failingServer returns a test server which responds with a status code for its first failures requests,
+// then with 200; the number of requests received is counted
+func failingServer(t *testing.T, failures int32, status int) (*httptest.Server, *atomic.Int32) {
+	var count atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if count.Add(1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	t.Cleanup(server.Close)
+	return server, &count
+}
+
+func TestSendRetries(t *testing.T) {
+
+	lcpsv := conf.LCPServerAccess{Timeout: 5, Retries: 2, BreakerThreshold: -1, UserName: "user", Password: "secret"}
+
+	// a request which can be retried succeeds after two server errors
+	server, count := failingServer(t, 2, http.StatusServiceUnavailable)
+	resp, body, err := send(context.Background(), lcpsv, request{method: http.MethodGet, url: server.URL, retry: true})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, int32(3), count.Load())
+
+	// the last server error is returned once retries are exhausted
+	server, count = failingServer(t, 10, http.StatusBadGateway)
+	resp, _, err = send(context.Background(), lcpsv, request{method: http.MethodGet, url: server.URL, retry: true})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	assert.Equal(t, int32(3), count.Load())
+
+	// a request which cannot be retried is sent once
+	server, count = failingServer(t, 1, http.StatusInternalServerError)
+	resp, _, err = send(context.Background(), lcpsv, request{method: http.MethodPost, url: server.URL, payload: []byte("{}")})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, int32(1), count.Load())
+
+	// a client error is not retried
+	server, count = failingServer(t, 1, http.StatusNotFound)
+	resp, _, err = send(context.Background(), lcpsv, request{method: http.MethodGet, url: server.URL, retry: true})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, int32(1), count.Load())
+
+	// retries can be disabled
+	noRetry := lcpsv
+	noRetry.Retries = -1
+	server, count = failingServer(t, 1, http.StatusServiceUnavailable)
+	resp, _, err = send(context.Background(), noRetry, request{method: http.MethodGet, url: server.URL, retry: true})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), count.Load())
+
+	// a network error is retried, then returned
+	server, count = failingServer(t, 0, http.StatusOK)
+	server.Close()
+	_, _, err = send(context.Background(), lcpsv, request{method: http.MethodGet, url: server.URL, retry: true})
+	assert.Error(t, err)
+	assert.Equal(t, int32(0), count.Load())
+}
+
+func TestSendAuth(t *testing.T) {
+
+	lcpsv := conf.LCPServerAccess{Timeout: 5, BreakerThreshold: -1, UserName: "user", Password: "secret"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "user" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(r.Header.Get("Accept")))
+	}))
+	defer server.Close()
+
+	header := http.Header{"Accept": []string{"application/json"}}
+	resp, body, err := send(context.Background(), lcpsv, request{method: http.MethodGet, url: server.URL, header: header, auth: true})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", string(body))
+
+	resp, _, err = send(context.Background(), lcpsv, request{method: http.MethodGet, url: server.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+}
+
+func TestSendTimeout(t *testing.T) {
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	lcpsv := conf.LCPServerAccess{Timeout: 1, Retries: -1, BreakerThreshold: -1}
+	start := time.Now()
+	_, _, err := send(context.Background(), lcpsv, request{method: http.MethodGet, url: server.URL, retry: true})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 3*time.Second)
+}
+
+func TestSendCanceled(t *testing.T) {
+
+	server, count := failingServer(t, 10, http.StatusServiceUnavailable)
+	lcpsv := conf.LCPServerAccess{Timeout: 5, Retries: 5, BreakerThreshold: 1, BreakerCooldown: 60}
+
+	// the caller gives up during the backoff
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, _, err := send(ctx, lcpsv, request{method: http.MethodGet, url: server.URL, retry: true})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int32(1), count.Load())
+
+	// which is not counted as a failure of the server
+	b := getBreaker(server.URL)
+	assert.Equal(t, 0, b.failures)
+	assert.True(t, b.allow(lcpsv.BreakerThreshold))
+}
+
+func TestBreaker(t *testing.T) {
+
+	server, count := failingServer(t, 3, http.StatusInternalServerError)
+	lcpsv := conf.LCPServerAccess{Timeout: 5, Retries: -1, BreakerThreshold: 2, BreakerCooldown: 60}
+	req := request{method: http.MethodGet, url: server.URL + "/licenses/1/status", retry: true}
+
+	// the circuit opens after two consecutive failures
+	for i := 0; i < 2; i++ {
+		resp, _, err := send(context.Background(), lcpsv, req)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	}
+	_, _, err := send(context.Background(), lcpsv, req)
+	assert.ErrorIs(t, err, ErrServerUnavailable)
+	assert.Equal(t, int32(2), count.Load())
+
+	// the breaker is shared by every url of the host
+	_, _, err = send(context.Background(), lcpsv, request{method: http.MethodGet, url: server.URL + "/licenses/2/status"})
+	assert.ErrorIs(t, err, ErrServerUnavailable)
+
+	// but not by other hosts
+	other, otherCount := failingServer(t, 0, http.StatusOK)
+	_, _, err = send(context.Background(), lcpsv, request{method: http.MethodGet, url: other.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), otherCount.Load())
+
+	// once the cooldown is over, a single request goes through; it fails and the circuit opens again
+	b := getBreaker(server.URL)
+	b.openUntil = time.Now().Add(-time.Second)
+	resp, _, err := send(context.Background(), lcpsv, req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	_, _, err = send(context.Background(), lcpsv, req)
+	assert.ErrorIs(t, err, ErrServerUnavailable)
+	assert.Equal(t, int32(3), count.Load())
+
+	// while a probe is in flight, other requests are rejected
+	b.openUntil = time.Now().Add(-time.Second)
+	assert.True(t, b.allow(lcpsv.BreakerThreshold))
+	assert.False(t, b.allow(lcpsv.BreakerThreshold))
+	b.abort()
+
+	// a successful probe closes the circuit
+	resp, _, err = send(context.Background(), lcpsv, req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, _, err = send(context.Background(), lcpsv, req)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(5), count.Load())
+	assert.Equal(t, 0, b.failures)
+}
+
+func TestBreakerDisabled(t *testing.T) {
+
+	server, count := failingServer(t, 10, http.StatusInternalServerError)
+	lcpsv := conf.LCPServerAccess{Timeout: 5, Retries: -1, BreakerThreshold: -1}
+	for i := 0; i < 5; i++ {
+		_, _, err := send(context.Background(), lcpsv, request{method: http.MethodGet, url: server.URL})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int32(5), count.Load())
+}
@@ -5,20 +5,28 @@
 package lcp
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/edrlab/pubstore/pkg/conf"
 	"github.com/edrlab/pubstore/pkg/stor"
 )
 
+// Errors returned for a renewal which is not allowed
+var (
+	ErrRenewalEnd       = errors.New("the new end date must be after the current one")
+	ErrRenewalLimit     = errors.New("the loan cannot be extended beyond its maximum duration")
+	ErrRenewalUnlimited = errors.New("an unlimited loan cannot be renewed")
+)
+
 // LicenseRequest contains every parameter required for requesting a
 // license to the License Server v2
 type LicenseRequest struct {
@@ -65,21 +73,31 @@ type LicenceRequestV1 struct {
 	Rights     Rights     `json:"rights,omitempty"`
 }
 
+// User identifies the user of a license
 type User struct {
 	ID        string   `json:"id"`
 	Email     string   `json:"email"`
+	Name      string   `json:"name,omitempty"`
 	Encrypted []string `json:"encrypted"`
 }
 
+// Encryption holds the encryption properties of a license.
+// A license request only provides the user key, with the hash of the user passphrase as hex value.
 type Encryption struct {
-	UserKey UserKey `json:"user_key"`
+	Profile    string      `json:"profile,omitempty"`
+	ContentKey *ContentKey `json:"content_key,omitempty"`
+	UserKey    UserKey     `json:"user_key"`
 }
 
+// UserKey holds the properties of the user passphrase
 type UserKey struct {
-	TextHint string `json:"text_hint"`
-	HexValue string `json:"hex_value"`
+	Algorithm string `json:"algorithm,omitempty"`
+	TextHint  string `json:"text_hint"`
+	KeyCheck  string `json:"key_check,omitempty"`
+	HexValue  string `json:"hex_value,omitempty"`
 }
 
+// Rights are the rights of a license; nil values are unconstrained
 type Rights struct {
 	Print *int       `json:"print,omitempty"`
 	Copy  *int       `json:"copy,omitempty"`
@@ -130,8 +148,38 @@ func v1Request(licenseReq LicenseRequest) LicenceRequestV1 {
 	return licence
 }
 
-// GenerateLicense sends a request to the License Server and returns a new license to the caller
-func GenerateLicense(lcpsv conf.LCPServerAccess, licenseReq LicenseRequest) ([]byte, error) {
+// NewLicenseRequest prepares a license request for a user and a publication.
+// Negative values for print and copy are considered void (therefore unconstrained),
+// as well as zero start and end dates.
+func NewLicenseRequest(publicationID string, user *stor.User, print, copy int, start, end time.Time) LicenseRequest {
+
+	licenseReq := LicenseRequest{
+		PublicationID: publicationID,
+		UserID:        user.UUID,
+		UserName:      user.Name,
+		UserEmail:     user.Email,
+		UserEncrypted: []string{"email"},
+		TextHint:      user.TextHint,
+		PassHash:      user.HPassphrase,
+	}
+	if print >= 0 {
+		licenseReq.Print = &print
+	}
+	if copy >= 0 {
+		licenseReq.Copy = &copy
+	}
+	if !start.IsZero() {
+		licenseReq.Start = &start
+	}
+	if !end.IsZero() {
+		licenseReq.End = &end
+	}
+	return licenseReq
+}
+
+// GenerateLicense sends a request to the License Server and returns a new license to the caller.
+// The request is not retried, as the License Server may have created a license before failing.
+func GenerateLicense(ctx context.Context, lcpsv conf.LCPServerAccess, licenseReq LicenseRequest) ([]byte, error) {
 
 	var url string
 	var payload []byte
@@ -155,21 +203,17 @@ func GenerateLicense(lcpsv conf.LCPServerAccess, licenseReq LicenseRequest) ([]b
 		}
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth(lcpsv.UserName, lcpsv.Password)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, body, err := send(ctx, lcpsv, request{
+		method:  "POST",
+		url:     url,
+		payload: payload,
+		header:  http.Header{"Content-Type": {"application/json"}},
+		auth:    true,
+	})
 	if err != nil {
 		fmt.Println("Error:", err)
 		return nil, errors.New("failed to send a license request to the License Server")
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusCreated {
 		fmt.Println("License created successfully.")
@@ -181,8 +225,7 @@ func GenerateLicense(lcpsv conf.LCPServerAccess, licenseReq LicenseRequest) ([]b
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+	if err = checkLicense(lcpsv, body); err != nil {
 		return nil, err
 	}
 
@@ -190,7 +233,7 @@ func GenerateLicense(lcpsv conf.LCPServerAccess, licenseReq LicenseRequest) ([]b
 }
 
 // GetFreshLicense sends a request to the License Server and returns the fresh license to the caller
-func GetFreshLicense(lcpsv conf.LCPServerAccess, transaction *stor.Transaction) ([]byte, error) {
+func GetFreshLicense(ctx context.Context, lcpsv conf.LCPServerAccess, transaction *stor.Transaction) ([]byte, error) {
 
 	var url string
 	var payload []byte
@@ -238,21 +281,18 @@ func GetFreshLicense(lcpsv conf.LCPServerAccess, transaction *stor.Transaction)
 		}
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Content-Type", "application/json")
-	req.SetBasicAuth(lcpsv.UserName, lcpsv.Password)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, body, err := send(ctx, lcpsv, request{
+		method:  "POST",
+		url:     url,
+		payload: payload,
+		header:  http.Header{"Content-Type": {"application/json"}},
+		auth:    true,
+		retry:   true,
+	})
 	if err != nil {
 		fmt.Println("Error:", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusOK {
 		fmt.Println("Fresh License successfully fetched.")
@@ -264,14 +304,23 @@ func GetFreshLicense(lcpsv conf.LCPServerAccess, transaction *stor.Transaction)
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+	if err = checkLicense(lcpsv, body); err != nil {
 		return nil, err
 	}
 
 	return body, nil
 }
 
+// License statuses, as defined by the License Status Document specification
+const (
+	StatusReady     = "ready"
+	StatusActive    = "active"
+	StatusRevoked   = "revoked"
+	StatusReturned  = "returned"
+	StatusCancelled = "cancelled"
+	StatusExpired   = "expired"
+)
+
 type LsdStatus struct {
 	StatusMessage      string
 	StatusCode         string
@@ -282,37 +331,277 @@ type LsdStatus struct {
 	EndDate            time.Time
 }
 
-// GetStatusDocument sends a request to the License Server and returns a status document to the caller
-func GetStatusDocument(lcpsv conf.LCPServerAccess, transaction *stor.Transaction) (*LsdStatus, error) {
+// GetStatusDocument returns the status of the license of a transaction.
+// The status document is kept in cache (see conf.LCPServerAccess.StatusCacheTTL).
+// The license rights are those recorded in the transaction; for transactions created before license metadata
+// was recorded, they are fetched from the License Server when the status document indicates a license update.
+func GetStatusDocument(ctx context.Context, lcpsv conf.LCPServerAccess, transaction *stor.Transaction) (*LsdStatus, error) {
+
+	if transaction.Issued != nil && transaction.StatusUrl != "" {
+		lsd, err := getCachedStatusDoc(ctx, lcpsv, transaction.StatusUrl)
+		if err != nil {
+			return nil, err
+		}
+		status := &LsdStatus{
+			StatusMessage:      lsd.Message,
+			StatusCode:         lsd.Status,
+			EndPotentialRights: lsd.PotentialRights.End,
+		}
+		if transaction.PrintLimit != nil {
+			status.PrintLimit = *transaction.PrintLimit
+		}
+		if transaction.CopyLimit != nil {
+			status.CopyLimit = *transaction.CopyLimit
+		}
+		if transaction.StartDate != nil {
+			status.StartDate = *transaction.StartDate
+		}
+		if transaction.EndDate != nil {
+			status.EndDate = *transaction.EndDate
+		}
+		return status, nil
+	}
+
+	rights, cached := licenseCache.get(transaction.LicenceId)
+	fetched := false
+
+	// the status url is persisted in recent transactions
+	statusUrl := transaction.StatusUrl
+	if statusUrl == "" {
+		if !cached {
+			var err error
+			if rights, err = getLicenseRights(ctx, lcpsv, transaction); err != nil {
+				return nil, err
+			}
+			cached, fetched = true, true
+		}
+		statusUrl = rights.statusUrl
+	}
+	if statusUrl == "" {
+		return nil, errors.New("no status link in the license")
+	}
 
-	// TODO: avoid fetching the fresh license first, just to get the url to the status document.
-	licenceBytes, err := GetFreshLicense(lcpsv, transaction)
+	lsd, err := getCachedStatusDoc(ctx, lcpsv, statusUrl)
 	if err != nil {
 		return nil, err
 	}
 
-	_, _, publicationStatusHref, printRights, copyRights, startDate, endDate, err := ParseLicense(licenceBytes)
+	// the license has been updated since its rights were cached
+	if !fetched && (!cached || rights.fetchedAt.Before(lsd.Updated.License)) {
+		if rights, err = getLicenseRights(ctx, lcpsv, transaction); err != nil {
+			return nil, err
+		}
+	}
+
+	return &LsdStatus{
+		StatusMessage:      lsd.Message,
+		StatusCode:         lsd.Status,
+		EndPotentialRights: lsd.PotentialRights.End,
+		PrintLimit:         rights.print,
+		CopyLimit:          rights.copy,
+		StartDate:          rights.start,
+		EndDate:            rights.end,
+	}, nil
+}
+
+// RecordedStatus returns the last known status of the license of a transaction, as recorded in the store.
+// It is displayed when the License Status Server cannot be reached.
+func RecordedStatus(transaction *stor.Transaction, err error) *LsdStatus {
+
+	status := &LsdStatus{
+		StatusMessage: "The license status could not be updated",
+		StatusCode:    transaction.Status,
+	}
+	if errors.Is(err, ErrServerUnavailable) {
+		status.StatusMessage = "The License Server is unavailable, the license status may be out of date"
+	}
+	if transaction.PrintLimit != nil {
+		status.PrintLimit = *transaction.PrintLimit
+	}
+	if transaction.CopyLimit != nil {
+		status.CopyLimit = *transaction.CopyLimit
+	}
+	if transaction.StartDate != nil {
+		status.StartDate = *transaction.StartDate
+	}
+	if transaction.EndDate != nil {
+		status.EndDate = *transaction.EndDate
+	}
+	return status
+}
+
+// getStatusDocUrl gets the url of the status document associated with a transaction
+func getStatusDocUrl(ctx context.Context, lcpsv conf.LCPServerAccess, transaction *stor.Transaction) (string, error) {
+
+	if transaction.StatusUrl != "" {
+		return transaction.StatusUrl, nil
+	}
+	rights, ok := licenseCache.get(transaction.LicenceId)
+	if !ok {
+		var err error
+		if rights, err = getLicenseRights(ctx, lcpsv, transaction); err != nil {
+			return "", err
+		}
+	}
+	if rights.statusUrl == "" {
+		return "", errors.New("no status link in the license")
+	}
+	return rights.statusUrl, nil
+}
+
+// ReturnLicense returns a loan before its end, using the return link of the status document.
+// It returns the updated status document.
+func ReturnLicense(ctx context.Context, lcpsv conf.LCPServerAccess, transaction *stor.Transaction) (*StatusDoc, error) {
+
+	return statusDocInteraction(ctx, lcpsv, transaction, "return", url.Values{})
+}
+
+// RenewLicense extends a loan up to a new end date, using the renew link of the status document.
+// It returns the updated status document.
+func RenewLicense(ctx context.Context, lcpsv conf.LCPServerAccess, transaction *stor.Transaction, end time.Time) (*StatusDoc, error) {
+
+	params := url.Values{}
+	params.Set("end", end.UTC().Format(time.RFC3339))
+	return statusDocInteraction(ctx, lcpsv, transaction, "renew", params)
+}
+
+// RenewalEnd returns the new end date of a loan to be renewed: the requested date if not zero,
+// otherwise the current end date (or now, if later) extended by duration days.
+// A loan lasts at most maxDuration days from its start, 0 meaning unlimited:
+// a default end date is cut to this limit, a requested end date beyond it is rejected.
+// A loan without end date, or extended by default while loans are unlimited (duration 0), is not renewed.
+func RenewalEnd(transaction *stor.Transaction, requested time.Time, duration, maxDuration int) (time.Time, error) {
+
+	if transaction.EndDate == nil || (requested.IsZero() && duration <= 0) {
+		return time.Time{}, ErrRenewalUnlimited
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	current := now
+	if transaction.EndDate != nil && transaction.EndDate.After(now) {
+		current = transaction.EndDate.UTC()
+	}
+	end := requested
+	if end.IsZero() {
+		end = current.AddDate(0, 0, duration)
+	} else if !end.After(current) {
+		return time.Time{}, ErrRenewalEnd
+	}
+	if maxDuration <= 0 {
+		return end, nil
+	}
+
+	start := transaction.CreatedAt
+	if transaction.StartDate != nil {
+		start = *transaction.StartDate
+	}
+	limit := start.UTC().AddDate(0, 0, maxDuration)
+	if end.After(limit) {
+		if !requested.IsZero() || !limit.After(current) {
+			return time.Time{}, ErrRenewalLimit
+		}
+		end = limit
+	}
+	return end, nil
+}
+
+// statusDocInteraction calls an interaction link of the status document associated with a transaction.
+// rel is "return" || "renew". A renewal to a given end date is retried on failure, a return is not.
+func statusDocInteraction(ctx context.Context, lcpsv conf.LCPServerAccess, transaction *stor.Transaction, rel string, params url.Values) (*StatusDoc, error) {
+
+	statusUrl, err := getStatusDocUrl(ctx, lcpsv, transaction)
+	if err != nil {
+		return nil, err
+	}
+	lsd, err := getStatusDocFromUrl(ctx, lcpsv, statusUrl)
 	if err != nil {
 		return nil, err
 	}
 
-	// make a request on publicationStatusHref
-	lsd, err := getStatusDocFromUrl(publicationStatusHref)
+	link, ok := lsd.Link(rel)
+	if !ok || link.Href == "" {
+		return nil, fmt.Errorf("the license cannot be %sed", rel)
+	}
+
+	// the device is the store itself
+	params.Set("id", "pubstore")
+	params.Set("name", "pubstore")
+
+	resp, body, err := send(ctx, lcpsv, request{
+		method: "PUT",
+		url:    expandTemplate(link.Href, params),
+		retry:  rel == "renew",
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	statusMessage := lsd.Message
-	endPotentialRights := lsd.PotentialRights.End
-	statusCode := lsd.Status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the %s request failed. Status code: %d", rel, resp.StatusCode)
+	}
 
-	return &LsdStatus{
-		StatusMessage:      statusMessage,
-		StatusCode:         statusCode,
-		EndPotentialRights: endPotentialRights,
-		PrintLimit:         printRights,
-		CopyLimit:          copyRights,
-		StartDate:          startDate,
-		EndDate:            endDate,
-	}, nil
+	updated, err := ParseStatusDoc(body)
+	if err != nil {
+		return nil, err
+	}
+	cacheStatusDoc(statusUrl, *updated)
+	return updated, nil
+}
+
+// expandTemplate expands a URI template with form-style query parameters, e.g. "/return{?id,name}"
+func expandTemplate(href string, params url.Values) string {
+
+	if i := strings.Index(href, "{"); i >= 0 {
+		href = href[:i]
+	}
+	if len(params) == 0 {
+		return href
+	}
+	if strings.Contains(href, "?") {
+		return href + "&" + params.Encode()
+	}
+	return href + "?" + params.Encode()
+}
+
+// RevokeLicense revokes a license on the License Server and returns the updated status document.
+// A License Server v1 is requested via the status document url, a License Server v2 via its revoke endpoint.
+// A revocation is retried on failure, as revoking a license twice has no other effect.
+func RevokeLicense(ctx context.Context, lcpsv conf.LCPServerAccess, transaction *stor.Transaction, message string) (*StatusDoc, error) {
+
+	statusUrl, err := getStatusDocUrl(ctx, lcpsv, transaction)
+	if err != nil {
+		return nil, err
+	}
+
+	req := request{auth: true, retry: true}
+	// License Server V1
+	if lcpsv.Version == "v1" {
+		req.payload, err = json.Marshal(map[string]string{"status": StatusRevoked, "message": message})
+		if err != nil {
+			return nil, err
+		}
+		req.method = "PATCH"
+		req.url = statusUrl
+		req.header = http.Header{"Content-Type": {"application/json"}}
+
+		// License Server V2
+	} else {
+		req.method = "PUT"
+		req.url = lcpsv.Url + "/revoke/" + transaction.LicenceId
+	}
+
+	resp, _, err := send(ctx, lcpsv, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the revoke request failed. Status code: %d", resp.StatusCode)
+	}
+
+	lsd, err := getStatusDocFromUrl(ctx, lcpsv, statusUrl)
+	if err != nil {
+		return nil, err
+	}
+	cacheStatusDoc(statusUrl, lsd)
+	return &lsd, nil
 }
@@ -0,0 +1,137 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lcp
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// License is an LCP license, as defined by the Readium LCP specification.
+// User, Encryption and Rights are shared with license requests sent to a License Server v1.
+type License struct {
+	Provider   string     `json:"provider"`
+	ID         string     `json:"id"`
+	Issued     time.Time  `json:"issued"`
+	Updated    *time.Time `json:"updated,omitempty"`
+	Encryption Encryption `json:"encryption"`
+	Links      []Link     `json:"links"`
+	User       User       `json:"user"`
+	Rights     Rights     `json:"rights"`
+	Signature  Signature  `json:"signature"`
+}
+
+// ContentKey is the encrypted content key of a license
+type ContentKey struct {
+	Algorithm      string `json:"algorithm"`
+	EncryptedValue string `json:"encrypted_value"`
+}
+
+// Link is a link of a license or status document
+type Link struct {
+	Rel       string `json:"rel"`
+	Href      string `json:"href"`
+	Type      string `json:"type,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Profile   string `json:"profile,omitempty"`
+	Templated bool   `json:"templated,omitempty"`
+	Length    int64  `json:"length,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+}
+
+// Signature is the signature of a license by its provider
+type Signature struct {
+	Certificate string `json:"certificate"`
+	Value       string `json:"value"`
+	Algorithm   string `json:"algorithm"`
+}
+
+// Link returns the first link of the license with a given rel
+func (l *License) Link(rel string) (Link, bool) {
+	return findLink(l.Links, rel)
+}
+
+// Validate checks that the license contains every property required by the LCP specification
+func (l *License) Validate() error {
+	var errs []error
+	if l.Provider == "" {
+		errs = append(errs, errors.New("missing provider"))
+	}
+	if l.ID == "" {
+		errs = append(errs, errors.New("missing id"))
+	}
+	if l.Issued.IsZero() {
+		errs = append(errs, errors.New("missing issue date"))
+	}
+	if l.Encryption.Profile == "" {
+		errs = append(errs, errors.New("missing encryption profile"))
+	}
+	if l.Encryption.ContentKey == nil || l.Encryption.ContentKey.EncryptedValue == "" || l.Encryption.ContentKey.Algorithm == "" {
+		errs = append(errs, errors.New("missing or incomplete content key"))
+	}
+	if l.Encryption.UserKey.TextHint == "" || l.Encryption.UserKey.KeyCheck == "" || l.Encryption.UserKey.Algorithm == "" {
+		errs = append(errs, errors.New("incomplete user key"))
+	}
+	for _, rel := range []string{"hint", "publication"} {
+		if _, ok := l.Link(rel); !ok {
+			errs = append(errs, fmt.Errorf("missing %s link", rel))
+		}
+	}
+	if l.Rights.Start != nil && l.Rights.End != nil && l.Rights.End.Before(*l.Rights.Start) {
+		errs = append(errs, errors.New("rights end before they start"))
+	}
+	if l.Signature.Value == "" || l.Signature.Certificate == "" || l.Signature.Algorithm == "" {
+		errs = append(errs, errors.New("missing or incomplete signature"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid license: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// StatusDoc is a License Status Document, as defined by the Readium LSD specification
+type StatusDoc struct {
+	ID              string          `json:"id"`
+	Status          string          `json:"status"`
+	Message         string          `json:"message"`
+	Updated         StatusUpdated   `json:"updated"`
+	Links           []Link          `json:"links"`
+	PotentialRights PotentialRights `json:"potential_rights"`
+	Events          []Event         `json:"events,omitempty"`
+}
+
+// StatusUpdated holds the dates of the last updates of a license and of its status
+type StatusUpdated struct {
+	License time.Time `json:"license"`
+	Status  time.Time `json:"status"`
+}
+
+// PotentialRights are the rights a license may obtain after renewals
+type PotentialRights struct {
+	End time.Time `json:"end,omitempty"`
+}
+
+// Event is an event of the life of a license: register, renew, return, revoke or cancel
+type Event struct {
+	Type      string    `json:"type"`
+	Name      string    `json:"name"`
+	DeviceID  string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Link returns the first link of the status document with a given rel
+func (s *StatusDoc) Link(rel string) (Link, bool) {
+	return findLink(s.Links, rel)
+}
+
+func findLink(links []Link, rel string) (Link, bool) {
+	for _, l := range links {
+		if l.Rel == rel {
+			return l, true
+		}
+	}
+	return Link{}, false
+}
@@ -5,143 +5,77 @@
 package lcp
 
 import (
+	"context"
 	"encoding/json"
-	"io"
+	"fmt"
 	"net/http"
-	"time"
-)
-
-type contentKey struct {
-	Algorithm      string `json:"algorithm"`
-	EncryptedValue string `json:"encrypted_value"`
-}
-
-type userKey struct {
-	Algorithm string `json:"algorithm"`
-	TextHint  string `json:"text_hint"`
-	KeyCheck  string `json:"key_check"`
-	HexValue  string `json:"hex_value"`
-}
-
-type encryption struct {
-	Profile    string     `json:"profile"`
-	ContentKey contentKey `json:"content_key"`
-	UserKey    userKey    `json:"user_key"`
-}
 
-type link struct {
-	Rel    string `json:"rel"`
-	Href   string `json:"href"`
-	Type   string `json:"type"`
-	Title  string `json:"title,omitempty"`
-	Length int    `json:"length,omitempty"`
-	Hash   string `json:"hash,omitempty"`
-}
-
-type signature struct {
-	Certificate string `json:"certificate"`
-	Value       string `json:"value"`
-	Algorithm   string `json:"algorithm"`
-}
-
-type LCPLicense struct {
-	Provider   string     `json:"provider"`
-	ID         string     `json:"id"`
-	Issued     string     `json:"issued"`
-	Encryption encryption `json:"encryption"`
-	Links      []link     `json:"links"`
-	User       user       `json:"user"`
-	Rights     rights     `json:"rights"`
-	Signature  signature  `json:"signature"`
-}
+	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/stor"
+)
 
-type user struct {
-	ID        string   `json:"id"`
-	Email     string   `json:"email"`
-	Encrypted []string `json:"encrypted"`
+// ParseLicense parses an LCP license. The license is not validated.
+func ParseLicense(data []byte) (*License, error) {
+	var license License
+	err := json.Unmarshal(data, &license)
+	if err != nil {
+		return nil, err
+	}
+	return &license, nil
 }
 
-type rights struct {
-	Print int       `json:"print"`
-	Copy  int       `json:"copy"`
-	Start time.Time `json:"start"`
-	End   time.Time `json:"end"`
+// ParseStatusDoc parses a License Status Document
+func ParseStatusDoc(data []byte) (*StatusDoc, error) {
+	var doc StatusDoc
+	err := json.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
 }
 
-func ParseLicense(data []byte) (string, string, string, int, int, time.Time, time.Time, error) {
-	var lcp LCPLicense
-	err := json.Unmarshal(data, &lcp)
+// RecordLicense copies the metadata of a license to a transaction: license id, issue date, rights,
+// url of the status document and url of the publication. The last known status is set to ready.
+func RecordLicense(transaction *stor.Transaction, data []byte) error {
+	license, err := ParseLicense(data)
 	if err != nil {
-		return "", "", "", 0, 0, time.Now(), time.Now(), err
+		return err
 	}
-
-	// Extracting ID
-	id := lcp.ID
-
-	// Extracting link information
-	var publicationLink link
-	for _, l := range lcp.Links {
-		if l.Rel == "publication" {
-			publicationLink = l
-			break
-		}
+	if err = license.Validate(); err != nil {
+		return err
 	}
 
-	// Extracting status link information
-	var publicationStatus link
-	for _, l := range lcp.Links {
-		if l.Rel == "status" {
-			publicationStatus = l
-			break
-		}
+	transaction.LicenceId = license.ID
+	transaction.Issued = &license.Issued
+	transaction.PrintLimit = license.Rights.Print
+	transaction.CopyLimit = license.Rights.Copy
+	transaction.StartDate = license.Rights.Start
+	transaction.EndDate = license.Rights.End
+	transaction.StatusUrl = ""
+	if status, ok := license.Link("status"); ok {
+		transaction.StatusUrl = status.Href
 	}
-
-	// Extracting publication link information
-	// publicationType := publicationLink.Type
-	publicationTitle := publicationLink.Title
-	publicationStatusHref := publicationStatus.Href
-	printRights := lcp.Rights.Print
-	copyRights := lcp.Rights.Copy
-	startDate := lcp.Rights.Start
-	endDate := lcp.Rights.End
-	// publicationLength := publicationLink.Length
-
-	return id, publicationTitle, publicationStatusHref, printRights, copyRights, startDate, endDate, err
-}
-
-type StatusDoc struct {
-	ID      string `json:"id"`
-	Status  string `json:"status"`
-	Updated struct {
-		License time.Time `json:"license"`
-		Status  time.Time `json:"status"`
-	} `json:"updated"`
-	Message         string `json:"message"`
-	Links           []link `json:"links"`
-	PotentialRights struct {
-		End time.Time `json:"end"`
-	} `json:"potential_rights"`
+	publication, _ := license.Link("publication")
+	transaction.PublicationUrl = publication.Href
+	transaction.Status = StatusReady
+	return nil
 }
 
 // getStatusDocFromUrl gets a status document from a url
-func getStatusDocFromUrl(url string) (StatusDoc, error) {
+func getStatusDocFromUrl(ctx context.Context, lcpsv conf.LCPServerAccess, url string) (StatusDoc, error) {
 
-	response, err := http.Get(url)
+	response, body, err := send(ctx, lcpsv, request{method: "GET", url: url, retry: true})
 	if err != nil {
 		return StatusDoc{}, err
 	}
-	defer response.Body.Close()
-
-	body, err := io.ReadAll(response.Body)
-	if err != nil {
-		return StatusDoc{}, err
+	if response.StatusCode != http.StatusOK {
+		return StatusDoc{}, fmt.Errorf("the status document request failed. Status code: %d", response.StatusCode)
 	}
 
-	var data StatusDoc
-	err = json.Unmarshal(body, &data)
+	doc, err := ParseStatusDoc(body)
 	if err != nil {
 		return StatusDoc{}, err
 	}
 
-	return data, nil
+	return *doc, nil
 }
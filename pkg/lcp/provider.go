@@ -0,0 +1,80 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lcp
+
+import (
+	"context"
+	"time"
+
+	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/stor"
+)
+
+// LicenseProvider generates licenses and manages their status.
+// Client is the implementation backed by a License Server, Fake is an in-process implementation.
+type LicenseProvider interface {
+	// GenerateLicense returns a new license
+	GenerateLicense(ctx context.Context, licenseReq LicenseRequest) ([]byte, error)
+	// GetFreshLicense returns an up to date copy of the license of a transaction
+	GetFreshLicense(ctx context.Context, transaction *stor.Transaction) ([]byte, error)
+	// GetStatusDocument returns the status of the license of a transaction
+	GetStatusDocument(ctx context.Context, transaction *stor.Transaction) (*LsdStatus, error)
+	// GetStatusDocuments returns the status of the licenses of a list of transactions
+	GetStatusDocuments(ctx context.Context, transactions []stor.Transaction) ([]*LsdStatus, error)
+	// ReturnLicense returns a loan before its end
+	ReturnLicense(ctx context.Context, transaction *stor.Transaction) (*StatusDoc, error)
+	// RenewLicense extends a loan up to a new end date
+	RenewLicense(ctx context.Context, transaction *stor.Transaction, end time.Time) (*StatusDoc, error)
+	// RevokeLicense revokes a license
+	RevokeLicense(ctx context.Context, transaction *stor.Transaction, message string) (*StatusDoc, error)
+}
+
+// NewLicenseProvider returns the license provider set in the configuration:
+// a Fake if the version of the License Server is "fake", a Client otherwise.
+func NewLicenseProvider(c *conf.Config) (LicenseProvider, error) {
+
+	if c.LCPServer.Version == "fake" {
+		return NewFake(c.PublicBaseUrl)
+	}
+	return NewClient(c.LCPServer), nil
+}
+
+// Client is a license provider which accesses a License Server v1 or v2 over HTTP
+type Client struct {
+	lcpsv conf.LCPServerAccess
+}
+
+// NewClient returns a client of the License Server described by lcpsv
+func NewClient(lcpsv conf.LCPServerAccess) *Client {
+	return &Client{lcpsv: lcpsv}
+}
+
+func (c *Client) GenerateLicense(ctx context.Context, licenseReq LicenseRequest) ([]byte, error) {
+	return GenerateLicense(ctx, c.lcpsv, licenseReq)
+}
+
+func (c *Client) GetFreshLicense(ctx context.Context, transaction *stor.Transaction) ([]byte, error) {
+	return GetFreshLicense(ctx, c.lcpsv, transaction)
+}
+
+func (c *Client) GetStatusDocument(ctx context.Context, transaction *stor.Transaction) (*LsdStatus, error) {
+	return GetStatusDocument(ctx, c.lcpsv, transaction)
+}
+
+func (c *Client) GetStatusDocuments(ctx context.Context, transactions []stor.Transaction) ([]*LsdStatus, error) {
+	return GetStatusDocuments(ctx, c.lcpsv, transactions)
+}
+
+func (c *Client) ReturnLicense(ctx context.Context, transaction *stor.Transaction) (*StatusDoc, error) {
+	return ReturnLicense(ctx, c.lcpsv, transaction)
+}
+
+func (c *Client) RenewLicense(ctx context.Context, transaction *stor.Transaction, end time.Time) (*StatusDoc, error) {
+	return RenewLicense(ctx, c.lcpsv, transaction, end)
+}
+
+func (c *Client) RevokeLicense(ctx context.Context, transaction *stor.Transaction, message string) (*StatusDoc, error) {
+	return RevokeLicense(ctx, c.lcpsv, transaction, message)
+}
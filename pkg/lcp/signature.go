@@ -0,0 +1,167 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package lcp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+
+	"github.com/edrlab/pubstore/pkg/conf"
+)
+
+// Signature algorithms defined by the LCP specification
+const (
+	AlgorithmRSASHA256   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	AlgorithmECDSASHA256 = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+)
+
+// ErrInvalidSignature is returned when the signature of a license does not match its content
+var ErrInvalidSignature = errors.New("invalid license signature")
+
+// providerRoots keeps the provider root certificates loaded from files, per file path
+var providerRoots sync.Map
+
+// Canonicalize returns the canonical form of a license, i.e. the content signed by the provider:
+// the signature property is removed, object keys are sorted and the JSON has no insignificant whitespace.
+// Like the Readium LCP Server, which produces the signature, HTML characters are escaped.
+func Canonicalize(data []byte) ([]byte, error) {
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// numbers are kept as they are written
+	decoder.UseNumber()
+	var license map[string]interface{}
+	if err := decoder.Decode(&license); err != nil {
+		return nil, err
+	}
+	delete(license, "signature")
+
+	// maps are marshalled with sorted keys
+	return json.Marshal(license)
+}
+
+// VerifySignature verifies the signature of a license against the certificate embedded in the license.
+// If roots is not nil, the certificate must also chain to one of these roots and be valid at the issue date of the license.
+func VerifySignature(data []byte, roots *x509.CertPool) error {
+
+	license, err := ParseLicense(data)
+	if err != nil {
+		return err
+	}
+
+	der, err := base64.StdEncoding.DecodeString(license.Signature.Certificate)
+	if err != nil {
+		return fmt.Errorf("invalid license certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("invalid license certificate: %w", err)
+	}
+	value, err := base64.StdEncoding.DecodeString(license.Signature.Value)
+	if err != nil {
+		return fmt.Errorf("invalid license signature value: %w", err)
+	}
+
+	canonical, err := Canonicalize(data)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(canonical)
+
+	switch license.Signature.Algorithm {
+	case AlgorithmRSASHA256:
+		pub, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("the license certificate does not hold an RSA key")
+		}
+		if err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, hash[:], value); err != nil {
+			return ErrInvalidSignature
+		}
+	case AlgorithmECDSASHA256:
+		pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+		if !ok {
+			return errors.New("the license certificate does not hold an ECDSA key")
+		}
+		if !verifyECDSA(pub, hash[:], value) {
+			return ErrInvalidSignature
+		}
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", license.Signature.Algorithm)
+	}
+
+	if roots == nil {
+		return nil
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:       roots,
+		CurrentTime: license.Issued,
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return fmt.Errorf("the license certificate is not trusted: %w", err)
+	}
+	return nil
+}
+
+// verifyECDSA verifies an ECDSA signature, encoded as the concatenation of r and s (as issued by the
+// Readium LCP Server) or as an ASN.1 sequence.
+func verifyECDSA(pub *ecdsa.PublicKey, hash, value []byte) bool {
+	size := (pub.Curve.Params().BitSize + 7) / 8
+	if len(value) == 2*size {
+		r := new(big.Int).SetBytes(value[:size])
+		s := new(big.Int).SetBytes(value[size:])
+		if ecdsa.Verify(pub, hash, r, s) {
+			return true
+		}
+	}
+	return ecdsa.VerifyASN1(pub, hash, value)
+}
+
+// LoadProviderRoots loads the PEM encoded root certificates of license providers from a file
+func LoadProviderRoots(path string) (*x509.CertPool, error) {
+
+	if roots, ok := providerRoots.Load(path); ok {
+		return roots.(*x509.CertPool), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+	providerRoots.Store(path, roots)
+	return roots, nil
+}
+
+// checkLicense verifies the signature of a license returned by the License Server, if required by the configuration
+func checkLicense(lcpsv conf.LCPServerAccess, data []byte) error {
+
+	if !lcpsv.VerifySignature {
+		return nil
+	}
+	var roots *x509.CertPool
+	if lcpsv.ProviderRoot != "" {
+		var err error
+		if roots, err = LoadProviderRoots(lcpsv.ProviderRoot); err != nil {
+			return fmt.Errorf("failed to load the provider root certificates: %w", err)
+		}
+	}
+	if err := VerifySignature(data, roots); err != nil {
+		return fmt.Errorf("license verification failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package lcp
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/stretchr/testify/assert"
+)
+
+// issued is the issue date of the test licenses
+var issued = time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC)
+
+// fixture is a certificate chain: a root CA and provider certificates, RSA and ECDSA, issued by this root
+type fixture struct {
+	root     *x509.Certificate
+	rootKey  *ecdsa.PrivateKey
+	rsaKey   *rsa.PrivateKey
+	rsaCert  []byte
+	ecKey    *ecdsa.PrivateKey
+	ecCert   []byte
+	rootPool *x509.CertPool
+}
+
+// newRoot returns a self-signed root CA, valid around the issue date of the test licenses
+func newRoot(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             issued.AddDate(-1, 0, 0),
+		NotAfter:              issued.AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	root, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return root, key
+}
+
+// newProviderCert returns a provider certificate issued by a root, valid for a year from notBefore
+func newProviderCert(t *testing.T, root *x509.Certificate, rootKey *ecdsa.PrivateKey, pub crypto.PublicKey, notBefore time.Time) []byte {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(notBefore.UnixNano()),
+		Subject:      pkix.Name{CommonName: "Test license provider"},
+		NotBefore:    notBefore,
+		NotAfter:     notBefore.AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, root, pub, rootKey)
+	assert.NoError(t, err)
+	return der
+}
+
+func newFixture(t *testing.T) *fixture {
+	f := &fixture{}
+	f.root, f.rootKey = newRoot(t, "Test provider root")
+	f.rootPool = x509.NewCertPool()
+	f.rootPool.AddCert(f.root)
+
+	var err error
+	f.rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	f.rsaCert = newProviderCert(t, f.root, f.rootKey, &f.rsaKey.PublicKey, issued.AddDate(0, -1, 0))
+
+	f.ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	f.ecCert = newProviderCert(t, f.root, f.rootKey, &f.ecKey.PublicKey, issued.AddDate(0, -1, 0))
+	return f
+}
+
+// rootFile writes the PEM encoded root certificate in a temporary file and returns its path
+func rootFile(t *testing.T, root *x509.Certificate) string {
+	path := filepath.Join(t.TempDir(), "root.pem")
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw}), 0600)
+	assert.NoError(t, err)
+	return path
+}
+
+// testLicense returns an unsigned license; HTML characters and an extension property
+// with a decimal number check that the signed content is canonicalized like the License Server does
+func testLicense() []byte {
+	return []byte(`{
+		"provider": "https://provider.example.com",
+		"id": "5a7d8b3c-5b2e-4b2f-9d0a-1f1e0c3c2a11",
+		"issued": "` + issued.Format(time.RFC3339) + `",
+		"encryption": {
+			"profile": "http://readium.org/lcp/basic-profile",
+			"content_key": {"algorithm": "http://www.w3.org/2001/04/xmlenc#aes256-cbc", "encrypted_value": "AAAA"},
+			"user_key": {"algorithm": "http://www.w3.org/2001/04/xmlenc#sha256", "text_hint": "Your <library> & password", "key_check": "BBBB"}
+		},
+		"links": [{"rel": "publication", "href": "https://example.com/books/1?a=1&b=2", "length": 12345678901}],
+		"user": {"id": "42", "name": "Reader"},
+		"rights": {"print": 10, "copy": 2000, "start": "2024-03-01T10:00:00Z", "end": "2024-03-31T10:00:00Z"},
+		"extension": {"ratio": 1.50, "big": 12345678901234567890}
+	}`)
+}
+
+// sign adds a signature to a license, computed over its canonical form
+func sign(t *testing.T, data []byte, key crypto.PrivateKey, cert []byte, asn1 bool) []byte {
+	canonical, err := Canonicalize(data)
+	assert.NoError(t, err)
+	hash := sha256.Sum256(canonical)
+
+	var value []byte
+	var algorithm string
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		algorithm = AlgorithmRSASHA256
+		value, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, hash[:])
+		assert.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		algorithm = AlgorithmECDSASHA256
+		if asn1 {
+			value, err = ecdsa.SignASN1(rand.Reader, k, hash[:])
+			assert.NoError(t, err)
+		} else {
+			r, s, err := ecdsa.Sign(rand.Reader, k, hash[:])
+			assert.NoError(t, err)
+			size := (k.Curve.Params().BitSize + 7) / 8
+			value = append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+		}
+	}
+
+	var license map[string]json.RawMessage
+	err = json.Unmarshal(data, &license)
+	assert.NoError(t, err)
+	license["signature"], err = json.Marshal(Signature{
+		Certificate: base64.StdEncoding.EncodeToString(cert),
+		Value:       base64.StdEncoding.EncodeToString(value),
+		Algorithm:   algorithm,
+	})
+	assert.NoError(t, err)
+	signed, err := json.MarshalIndent(license, "", "  ")
+	assert.NoError(t, err)
+	return signed
+}
+
+// tamper replaces a property of a license, keeping its signature
+func tamper(t *testing.T, data []byte, key string, value interface{}) []byte {
+	var license map[string]interface{}
+	err := json.Unmarshal(data, &license)
+	assert.NoError(t, err)
+	license[key] = value
+	tampered, err := json.Marshal(license)
+	assert.NoError(t, err)
+	return tampered
+}
+
+func TestCanonicalize(t *testing.T) {
+
+	data := []byte(`{
+		"z": 1.50,
+		"a": "<b>&</b>",
+		"signature": {"value": "xyz"},
+		"m": {"b": 1e3, "a": 12345678901234567890, "c": [3, 1.0, "é"]}
+	}`)
+	canonical, err := Canonicalize(data)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":"\u003cb\u003e\u0026\u003c/b\u003e","m":{"a":12345678901234567890,"b":1e3,"c":[3,1.0,"é"]},"z":1.50}`, string(canonical))
+
+	_, err = Canonicalize([]byte(`[1, 2]`))
+	assert.Error(t, err)
+	_, err = Canonicalize([]byte(`{"id": `))
+	assert.Error(t, err)
+}
+
+func TestVerifySignature(t *testing.T) {
+
+	f := newFixture(t)
+	license := testLicense()
+
+	untrustedRoot, _ := newRoot(t, "Untrusted root")
+	untrusted := x509.NewCertPool()
+	untrusted.AddCert(untrustedRoot)
+
+	// a provider certificate which expired before the license was issued
+	expiredCert := newProviderCert(t, f.root, f.rootKey, &f.ecKey.PublicKey, issued.AddDate(-2, 0, 0))
+
+	rsaSigned := sign(t, license, f.rsaKey, f.rsaCert, false)
+	ecSigned := sign(t, license, f.ecKey, f.ecCert, false)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		roots   *x509.CertPool
+		wantErr error
+		errText string
+	}{
+		{name: "rsa", data: rsaSigned},
+		{name: "rsa trusted", data: rsaSigned, roots: f.rootPool},
+		{name: "ecdsa", data: ecSigned},
+		{name: "ecdsa trusted", data: ecSigned, roots: f.rootPool},
+		{name: "ecdsa asn1", data: sign(t, license, f.ecKey, f.ecCert, true), roots: f.rootPool},
+		{name: "rsa tampered", data: tamper(t, rsaSigned, "id", "another-id"), wantErr: ErrInvalidSignature},
+		{name: "ecdsa tampered", data: tamper(t, ecSigned, "provider", "https://evil.example.com"), wantErr: ErrInvalidSignature},
+		{name: "signed by another key", data: sign(t, license, f.rsaKey, f.ecCert, false), errText: "does not hold an RSA key"},
+		{name: "untrusted root", data: rsaSigned, roots: untrusted, errText: "not trusted"},
+		{name: "expired certificate", data: sign(t, license, f.ecKey, expiredCert, false), roots: f.rootPool, errText: "not trusted"},
+		{name: "unsupported algorithm", data: tamper(t, ecSigned, "signature", map[string]string{
+			"algorithm":   "http://www.w3.org/2000/09/xmldsig#rsa-sha1",
+			"certificate": base64.StdEncoding.EncodeToString(f.ecCert),
+			"value":       "AAAA",
+		}), errText: "unsupported signature algorithm"},
+		{name: "invalid certificate", data: tamper(t, ecSigned, "signature", map[string]string{
+			"algorithm":   AlgorithmECDSASHA256,
+			"certificate": base64.StdEncoding.EncodeToString([]byte("not a certificate")),
+			"value":       "AAAA",
+		}), errText: "invalid license certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifySignature(tt.data, tt.roots)
+			switch {
+			case tt.wantErr != nil:
+				assert.ErrorIs(t, err, tt.wantErr)
+			case tt.errText != "":
+				assert.ErrorContains(t, err, tt.errText)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestVerifySignatureCanonicalForm(t *testing.T) {
+
+	f := newFixture(t)
+	signed := sign(t, testLicense(), f.ecKey, f.ecCert, false)
+
+	// the signature does not depend on whitespace
+	var license map[string]json.RawMessage
+	err := json.Unmarshal(signed, &license)
+	assert.NoError(t, err)
+	reformatted, err := json.Marshal(license)
+	assert.NoError(t, err)
+	assert.NotEqual(t, signed, reformatted)
+	assert.NoError(t, VerifySignature(reformatted, f.rootPool))
+
+	// but a number written differently is a different content
+	assert.ErrorIs(t, VerifySignature(tamper(t, signed, "extension", map[string]float64{"ratio": 1.5, "big": 12345678901234567890}), nil), ErrInvalidSignature)
+}
+
+func TestLoadProviderRoots(t *testing.T) {
+
+	f := newFixture(t)
+	path := rootFile(t, f.root)
+
+	roots, err := LoadProviderRoots(path)
+	assert.NoError(t, err)
+	assert.True(t, roots.Equal(f.rootPool))
+
+	// the roots are loaded once per path
+	cached, err := LoadProviderRoots(path)
+	assert.NoError(t, err)
+	assert.Same(t, roots, cached)
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	assert.NoError(t, os.WriteFile(empty, []byte("no certificate here"), 0600))
+	_, err = LoadProviderRoots(empty)
+	assert.ErrorContains(t, err, "no certificate found")
+
+	_, err = LoadProviderRoots(filepath.Join(t.TempDir(), "missing.pem"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestCheckLicense(t *testing.T) {
+
+	f := newFixture(t)
+	signed := sign(t, testLicense(), f.rsaKey, f.rsaCert, false)
+	tampered := tamper(t, signed, "id", "another-id")
+
+	// the signature is not verified unless required
+	assert.NoError(t, checkLicense(conf.LCPServerAccess{}, tampered))
+
+	lcpsv := conf.LCPServerAccess{VerifySignature: true}
+	assert.NoError(t, checkLicense(lcpsv, signed))
+	assert.ErrorIs(t, checkLicense(lcpsv, tampered), ErrInvalidSignature)
+
+	lcpsv.ProviderRoot = rootFile(t, f.root)
+	assert.NoError(t, checkLicense(lcpsv, signed))
+
+	untrustedRoot, _ := newRoot(t, "Untrusted root")
+	lcpsv.ProviderRoot = rootFile(t, untrustedRoot)
+	assert.ErrorContains(t, checkLicense(lcpsv, signed), "not trusted")
+
+	lcpsv.ProviderRoot = filepath.Join(t.TempDir(), "missing.pem")
+	assert.ErrorContains(t, checkLicense(lcpsv, signed), "failed to load the provider root certificates")
+}
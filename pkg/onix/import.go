@@ -0,0 +1,503 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package onix
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/cover"
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Status of an imported record
+const (
+	StatusCreated  = "created"
+	StatusUpdated  = "updated"
+	StatusRejected = "rejected"
+)
+
+// Content types of the publications created from ONIX products
+const (
+	contentTypeEPUB      = "application/epub+zip"
+	contentTypePDF       = "application/pdf+lcp"
+	contentTypeAudiobook = "application/audiobook+lcp"
+)
+
+// Report is the result of the import of an ONIX message
+type Report struct {
+	Created  int      `json:"created"`
+	Updated  int      `json:"updated"`
+	Rejected int      `json:"rejected"`
+	Records  []Record `json:"records"`
+}
+
+// Record is the result of the import of a product
+type Record struct {
+	Reference string `json:"reference,omitempty"`
+	ISBN      string `json:"isbn,omitempty"`
+	UUID      string `json:"uuid,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Importer creates or updates publications from ONIX products, identified by their ISBN
+type Importer struct {
+	*conf.Config
+	*stor.Store
+}
+
+// New returns an importer
+func New(c *conf.Config, s *stor.Store) *Importer {
+	return &Importer{Config: c, Store: s}
+}
+
+// Import creates or updates a publication for each product of an ONIX 3.0 message.
+// Invalid products are rejected and reported, the next ones are still imported.
+// An error is returned if the message is malformed; the report then covers the products read before.
+func (i *Importer) Import(ctx context.Context, r io.Reader) (*Report, error) {
+
+	report := &Report{Records: []Record{}}
+	reader := NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		product, err := reader.Next()
+		if err == io.EOF {
+			return report, nil
+		}
+		if err != nil {
+			return report, fmt.Errorf("malformed ONIX message after %d products: %w", len(report.Records), err)
+		}
+
+		record := i.importProduct(ctx, product)
+		switch record.Status {
+		case StatusCreated:
+			report.Created++
+		case StatusUpdated:
+			report.Updated++
+		default:
+			report.Rejected++
+		}
+		report.Records = append(report.Records, record)
+	}
+}
+
+// importProduct creates or updates the publication of a product
+func (i *Importer) importProduct(ctx context.Context, product *Product) Record {
+
+	record := Record{Reference: strings.TrimSpace(product.RecordReference)}
+	reject := func(err error) Record {
+		record.Status = StatusRejected
+		record.Error = err.Error()
+		return record
+	}
+
+	publication, err := ToPublication(product)
+	record.ISBN = publication.ISBN
+	record.Title = publication.Title
+	if err != nil {
+		return reject(err)
+	}
+
+	existing, err := i.Store.GetPublicationByISBN(publication.ISBN)
+	switch {
+	case err == nil:
+		// the identifier, number of copies and stored cover of the publication are kept
+		publication.ID = existing.ID
+		publication.CreatedAt = existing.CreatedAt
+		publication.UUID = existing.UUID
+		publication.Copies = existing.Copies
+		if publication.ContentType == "" {
+			publication.ContentType = existing.ContentType
+		}
+		if publication.CoverUrl == "" || cover.IsStored(i.Config.PublicBaseUrl, existing.CoverUrl) {
+			publication.CoverUrl = existing.CoverUrl
+		}
+		if err = publication.Validate(); err != nil {
+			return reject(err)
+		}
+		if err = i.Store.ReplacePublication(publication); err != nil {
+			return reject(err)
+		}
+		record.Status = StatusUpdated
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		publication.UUID = uuid.New().String()
+		if publication.ContentType == "" {
+			publication.ContentType = contentTypeEPUB
+		}
+		if err = publication.Validate(); err != nil {
+			return reject(err)
+		}
+		if err = i.Store.CreatePublication(publication); err != nil {
+			return reject(err)
+		}
+		record.Status = StatusCreated
+	default:
+		return reject(err)
+	}
+	record.UUID = publication.UUID
+
+	if err = cover.Fetch(ctx, i.Config, i.Store, publication); err != nil {
+		fmt.Println("Failed to store the cover of " + publication.UUID + ": " + err.Error())
+	}
+	return record
+}
+
+// ToPublication maps an ONIX product to a publication, without identifier.
+// The content type is empty if it cannot be inferred from the product form.
+func ToPublication(product *Product) (*stor.Publication, error) {
+
+	publication := &stor.Publication{}
+	publication.Title = title(product)
+	publication.ISBN = isbn(product)
+
+	if product.NotificationType == "05" {
+		return publication, errors.New("deletion notices are not imported")
+	}
+	if publication.ISBN == "" {
+		return publication, errors.New("no valid ISBN")
+	}
+	if publication.Title == "" {
+		return publication, errors.New("no title")
+	}
+	contentType, digital := contentType(product)
+	if !digital {
+		return publication, errors.New("not a digital product: form " + product.DescriptiveDetail.ProductForm)
+	}
+	publication.ContentType = contentType
+
+	dd := &product.DescriptiveDetail
+	publication.Author, publication.Contributors = contributors(dd.Contributor)
+
+	for _, l := range dd.Language {
+		// 01: language of text
+		if l.LanguageRole != "" && l.LanguageRole != "01" {
+			continue
+		}
+		addLanguage(publication, l.LanguageCode)
+	}
+
+	for _, s := range dd.Subject {
+		// 10: BISAC subject heading, 93: Thema subject category
+		if s.SubjectSchemeIdentifier != "10" && s.SubjectSchemeIdentifier != "93" {
+			continue
+		}
+		name := strings.TrimSpace(s.SubjectHeadingText)
+		if name == "" {
+			name = strings.TrimSpace(s.SubjectCode)
+		}
+		addCategory(publication, name)
+	}
+
+	pd := &product.PublishingDetail
+	for _, p := range pd.Publisher {
+		// 01: publisher
+		if p.PublishingRole != "" && p.PublishingRole != "01" {
+			continue
+		}
+		addPublisher(publication, p.PublisherName)
+	}
+	if len(publication.Publisher) == 0 {
+		for _, imprint := range pd.Imprint {
+			addPublisher(publication, imprint.ImprintName)
+		}
+	}
+	for _, d := range pd.PublishingDate {
+		// 01: publication date
+		if d.PublishingDateRole == "01" {
+			publication.DatePublished = date(d.Date.Value, d.Date.Format)
+			break
+		}
+	}
+
+	cd := &product.CollateralDetail
+	publication.Description = description(cd.TextContent)
+	for _, resource := range cd.SupportingResource {
+		// 01: front cover, 03: image
+		if resource.ResourceContentType != "01" || (resource.ResourceMode != "" && resource.ResourceMode != "03") {
+			continue
+		}
+		for _, version := range resource.ResourceVersion {
+			if link := strings.TrimSpace(version.ResourceLink); link != "" {
+				publication.CoverUrl = link
+				break
+			}
+		}
+		if publication.CoverUrl != "" {
+			break
+		}
+	}
+	return publication, nil
+}
+
+// title returns the distinctive title of a product, with its subtitle
+func title(product *Product) string {
+	for _, td := range product.DescriptiveDetail.TitleDetail {
+		// 01: distinctive title
+		if td.TitleType != "01" {
+			continue
+		}
+		for _, te := range td.TitleElement {
+			// 01: product level
+			if te.TitleElementLevel != "01" {
+				continue
+			}
+			t := strings.TrimSpace(te.TitleText)
+			if t == "" {
+				t = strings.TrimSpace(strings.TrimSpace(te.TitlePrefix) + " " + strings.TrimSpace(te.TitleWithoutPrefix))
+			}
+			if sub := strings.TrimSpace(te.Subtitle); sub != "" && t != "" {
+				t += ": " + sub
+			}
+			return t
+		}
+	}
+	return ""
+}
+
+// isbn returns the ISBN-13 of a product, from its ISBN-13, GTIN-13 or ISBN-10 identifier
+func isbn(product *Product) string {
+	ids := map[string]string{}
+	for _, id := range product.ProductIdentifier {
+		ids[id.ProductIDType] = strings.NewReplacer("-", "", " ", "").Replace(id.IDValue)
+	}
+	// 15: ISBN-13
+	if v := ids["15"]; validISBN13(v) {
+		return v
+	}
+	// 03: GTIN-13, an ISBN-13 if it starts with the Bookland prefix
+	if v := ids["03"]; validISBN13(v) && (strings.HasPrefix(v, "978") || strings.HasPrefix(v, "979")) {
+		return v
+	}
+	// 02: ISBN-10
+	if v := strings.ToUpper(ids["02"]); validISBN10(v) {
+		v = "978" + v[:9]
+		return v + string(isbn13CheckDigit(v))
+	}
+	return ""
+}
+
+func validISBN13(v string) bool {
+	if len(v) != 13 {
+		return false
+	}
+	if _, err := strconv.ParseUint(v, 10, 64); err != nil {
+		return false
+	}
+	return isbn13CheckDigit(v[:12]) == v[12]
+}
+
+func isbn13CheckDigit(v string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		d := int(v[i] - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func validISBN10(v string) bool {
+	if len(v) != 10 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 10; i++ {
+		var d int
+		switch {
+		case v[i] >= '0' && v[i] <= '9':
+			d = int(v[i] - '0')
+		case v[i] == 'X' && i == 9:
+			d = 10
+		default:
+			return false
+		}
+		sum += d * (10 - i)
+	}
+	return sum%11 == 0
+}
+
+// contentType returns the content type of a product from its form, and false if the product is not digital
+func contentType(product *Product) (string, bool) {
+	dd := &product.DescriptiveDetail
+	for _, detail := range dd.ProductFormDetail {
+		switch detail {
+		case "E101":
+			return contentTypeEPUB, true
+		case "E107":
+			return contentTypePDF, true
+		}
+	}
+	switch form := dd.ProductForm; {
+	case form == "AJ" || form == "AN":
+		// downloadable audio file
+		return contentTypeAudiobook, true
+	case form == "" || form == "EA" || strings.HasPrefix(form, "E"):
+		return "", true
+	}
+	return "", false
+}
+
+// contributorRoles maps ONIX contributor roles to the roles of the contributors of a publication
+var contributorRoles = map[string]string{
+	"B06": stor.RoleTranslator,  // translated by
+	"B01": stor.RoleEditor,      // edited by
+	"A12": stor.RoleIllustrator, // illustrated by
+	"E07": stor.RoleNarrator,    // read by
+}
+
+// contributors returns the authors (role A01) and other contributors of a product, in sequence order.
+// Contributors whose roles cannot be shown in OPDS feeds (e.g. cover designers) are not kept.
+func contributors(list []Contributor) ([]stor.Author, []stor.Contributor) {
+	sorted := make([]Contributor, len(list))
+	copy(sorted, list)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, _ := strconv.Atoi(sorted[i].SequenceNumber)
+		b, _ := strconv.Atoi(sorted[j].SequenceNumber)
+		return a < b
+	})
+
+	var authors []stor.Author
+	var others []stor.Contributor
+	for _, c := range sorted {
+		name := contributorName(c)
+		if name == "" {
+			continue
+		}
+		for _, r := range c.ContributorRole {
+			r = strings.TrimSpace(r)
+			if r == "A01" {
+				duplicate := false
+				for _, a := range authors {
+					duplicate = duplicate || a.Name == name
+				}
+				if !duplicate {
+					authors = append(authors, stor.Author{Name: name})
+				}
+				continue
+			}
+			role, ok := contributorRoles[r]
+			if !ok {
+				continue
+			}
+			duplicate := false
+			for _, o := range others {
+				duplicate = duplicate || (o.Name == name && o.Role == role)
+			}
+			if !duplicate {
+				others = append(others, stor.Contributor{Name: name, Role: role})
+			}
+		}
+	}
+	return authors, others
+}
+
+// contributorName returns the name of a person or corporate contributor
+func contributorName(c Contributor) string {
+	name := strings.TrimSpace(c.PersonName)
+	if name == "" {
+		name = strings.TrimSpace(strings.TrimSpace(c.NamesBeforeKey) + " " + strings.TrimSpace(c.KeyNames))
+	}
+	if name == "" {
+		name = strings.TrimSpace(c.CorporateName)
+	}
+	if name == "" {
+		name = strings.TrimSpace(c.PersonNameInverted)
+	}
+	return name
+}
+
+// description returns the description of a product, or its short description
+func description(texts []TextContent) string {
+	// 03: description, 02: short description
+	for _, textType := range []string{"03", "02"} {
+		for _, t := range texts {
+			if t.TextType == textType && t.Text != "" {
+				return string(t.Text)
+			}
+		}
+	}
+	return ""
+}
+
+// date converts an ONIX date to an ISO 8601 date; only year, month and day formats are converted
+func date(value, format string) string {
+	v := strings.TrimSpace(value)
+	switch {
+	case (format == "" || format == "00") && len(v) == 8:
+		return v[:4] + "-" + v[4:6] + "-" + v[6:]
+	case format == "01" && len(v) == 6:
+		return v[:4] + "-" + v[4:]
+	}
+	return v
+}
+
+func addPublisher(publication *stor.Publication, name string) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return
+	}
+	for _, p := range publication.Publisher {
+		if p.Name == name {
+			return
+		}
+	}
+	publication.Publisher = append(publication.Publisher, stor.Publisher{Name: name})
+}
+
+func addCategory(publication *stor.Publication, name string) {
+	if name == "" {
+		return
+	}
+	for _, c := range publication.Category {
+		if c.Name == name {
+			return
+		}
+	}
+	publication.Category = append(publication.Category, stor.Category{Name: name})
+}
+
+// addLanguage appends a language to a publication from its ISO 639-2 code, ignoring duplicates and unknown codes
+func addLanguage(publication *stor.Publication, code string) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if len(code) == 3 {
+		code = iso6391[code]
+	}
+	if len(code) != 2 {
+		return
+	}
+	for _, l := range publication.Language {
+		if l.Code == code {
+			return
+		}
+	}
+	publication.Language = append(publication.Language, stor.Language{Code: code})
+}
+
+// iso6391 maps ISO 639-2 codes, bibliographic and terminologic, to ISO 639-1 codes
+var iso6391 = map[string]string{
+	"ara": "ar", "baq": "eu", "eus": "eu", "bul": "bg", "cat": "ca", "chi": "zh", "zho": "zh",
+	"cze": "cs", "ces": "cs", "dan": "da", "dut": "nl", "nld": "nl", "eng": "en", "est": "et",
+	"fin": "fi", "fre": "fr", "fra": "fr", "ger": "de", "deu": "de", "gle": "ga", "glg": "gl",
+	"gre": "el", "ell": "el", "heb": "he", "hin": "hi", "hrv": "hr", "hun": "hu", "ice": "is",
+	"isl": "is", "ind": "id", "ita": "it", "jpn": "ja", "kor": "ko", "lat": "la", "lav": "lv",
+	"lit": "lt", "may": "ms", "msa": "ms", "nor": "no", "nob": "nb", "nno": "nn", "per": "fa",
+	"fas": "fa", "pol": "pl", "por": "pt", "rum": "ro", "ron": "ro", "rus": "ru", "slo": "sk",
+	"slk": "sk", "slv": "sl", "spa": "es", "srp": "sr", "swe": "sv", "tha": "th", "tur": "tr",
+	"ukr": "uk", "vie": "vi", "wel": "cy", "cym": "cy",
+}
@@ -0,0 +1,217 @@
+package onix
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/stretchr/testify/assert"
+)
+
+var store stor.Store
+
+func TestMain(m *testing.M) {
+
+	var err error
+	store, err = stor.Init("sqlite3://file::memory:?cache=shared")
+	if err != nil {
+		panic("Failed to migrate database: " + err.Error())
+	}
+
+	exitCode := m.Run()
+
+	fmt.Println("ExitCode", exitCode)
+	os.Exit(exitCode)
+}
+
+// message wraps products in an ONIX message
+func message(products ...string) string {
+	return "<ONIXMessage release=\"3.0\"><Header/><Product>" + strings.Join(products, "</Product><Product>") + "</Product></ONIXMessage>"
+}
+
+// product reads the first product of a message
+func product(t *testing.T, body string) *Product {
+	p, err := NewReader(strings.NewReader(message(body))).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+const (
+	isbn13Identifier = `<ProductIdentifier><ProductIDType>15</ProductIDType><IDValue>978-0-306-40615-7</IDValue></ProductIdentifier>`
+	titleDetail      = `<TitleDetail><TitleType>01</TitleType><TitleElement><TitleElementLevel>01</TitleElementLevel><TitleText>The Title</TitleText><Subtitle>A Subtitle</Subtitle></TitleElement></TitleDetail>`
+)
+
+func TestToPublication(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		body   string
+		err    string
+		expect func(t *testing.T, p *stor.Publication)
+	}{
+		{
+			name: "complete product",
+			body: isbn13Identifier + `<DescriptiveDetail><ProductForm>ED</ProductForm><ProductFormDetail>E101</ProductFormDetail>` + titleDetail +
+				`<Contributor><SequenceNumber>2</SequenceNumber><ContributorRole>A01</ContributorRole><PersonName>Second Author</PersonName></Contributor>
+				<Contributor><SequenceNumber>1</SequenceNumber><ContributorRole>A01</ContributorRole><NamesBeforeKey>First</NamesBeforeKey><KeyNames>Author</KeyNames></Contributor>
+				<Contributor><SequenceNumber>3</SequenceNumber><ContributorRole>B06</ContributorRole><PersonName>A Translator</PersonName></Contributor>
+				<Contributor><SequenceNumber>4</SequenceNumber><ContributorRole>B01</ContributorRole><ContributorRole>A12</ContributorRole><PersonName>An Editor</PersonName></Contributor>
+				<Contributor><SequenceNumber>5</SequenceNumber><ContributorRole>E07</ContributorRole><PersonName>A Narrator</PersonName></Contributor>
+				<Contributor><SequenceNumber>6</SequenceNumber><ContributorRole>A36</ContributorRole><PersonName>A Cover Designer</PersonName></Contributor>
+				<Language><LanguageRole>01</LanguageRole><LanguageCode>eng</LanguageCode></Language>
+				<Language><LanguageRole>01</LanguageRole><LanguageCode>fre</LanguageCode></Language>
+				<Language><LanguageRole>02</LanguageRole><LanguageCode>ger</LanguageCode></Language>
+				<Subject><SubjectSchemeIdentifier>10</SubjectSchemeIdentifier><SubjectCode>FIC000000</SubjectCode><SubjectHeadingText>Fiction / General</SubjectHeadingText></Subject>
+				<Subject><SubjectSchemeIdentifier>93</SubjectSchemeIdentifier><SubjectCode>FBA</SubjectCode></Subject>
+				<Subject><SubjectSchemeIdentifier>20</SubjectSchemeIdentifier><SubjectHeadingText>keyword</SubjectHeadingText></Subject>
+				</DescriptiveDetail>
+				<CollateralDetail><TextContent><TextType>03</TextType><Text>&lt;p&gt;A description&lt;/p&gt;</Text></TextContent></CollateralDetail>
+				<PublishingDetail><Publisher><PublishingRole>01</PublishingRole><PublisherName>The Publisher</PublisherName></Publisher>
+				<PublishingDate><PublishingDateRole>01</PublishingDateRole><Date>20230115</Date></PublishingDate></PublishingDetail>`,
+			expect: func(t *testing.T, p *stor.Publication) {
+				assert.Equal(t, "9780306406157", p.ISBN)
+				assert.Equal(t, "The Title: A Subtitle", p.Title)
+				assert.Equal(t, contentTypeEPUB, p.ContentType)
+				assert.Equal(t, []stor.Author{{Name: "First Author"}, {Name: "Second Author"}}, p.Author)
+				assert.Equal(t, []stor.Contributor{
+					{Name: "A Translator", Role: stor.RoleTranslator},
+					{Name: "An Editor", Role: stor.RoleEditor},
+					{Name: "An Editor", Role: stor.RoleIllustrator},
+					{Name: "A Narrator", Role: stor.RoleNarrator},
+				}, p.Contributors)
+				assert.Equal(t, []stor.Language{{Code: "en"}, {Code: "fr"}}, p.Language)
+				assert.Equal(t, []stor.Category{{Name: "Fiction / General"}, {Name: "FBA"}}, p.Category)
+				assert.Equal(t, []stor.Publisher{{Name: "The Publisher"}}, p.Publisher)
+				assert.Equal(t, "2023-01-15", p.DatePublished)
+				assert.Equal(t, "A description", p.Description)
+			},
+		},
+		{
+			name: "ISBN-10",
+			body: `<ProductIdentifier><ProductIDType>02</ProductIDType><IDValue>0-306-40615-2</IDValue></ProductIdentifier>
+				<DescriptiveDetail><ProductForm>ED</ProductForm>` + titleDetail + `</DescriptiveDetail>`,
+			expect: func(t *testing.T, p *stor.Publication) {
+				assert.Equal(t, "9780306406157", p.ISBN)
+				assert.Equal(t, "", p.ContentType)
+			},
+		},
+		{
+			name: "GTIN-13 which is not an ISBN",
+			body: `<ProductIdentifier><ProductIDType>03</ProductIDType><IDValue>4006381333931</IDValue></ProductIdentifier>
+				<DescriptiveDetail><ProductForm>ED</ProductForm>` + titleDetail + `</DescriptiveDetail>`,
+			err: "no valid ISBN",
+		},
+		{
+			name: "invalid check digit",
+			body: `<ProductIdentifier><ProductIDType>15</ProductIDType><IDValue>9780306406158</IDValue></ProductIdentifier>
+				<DescriptiveDetail><ProductForm>ED</ProductForm>` + titleDetail + `</DescriptiveDetail>`,
+			err: "no valid ISBN",
+		},
+		{
+			name: "no title",
+			body: isbn13Identifier + `<DescriptiveDetail><ProductForm>ED</ProductForm></DescriptiveDetail>`,
+			err:  "no title",
+		},
+		{
+			name: "deletion notice",
+			body: `<NotificationType>05</NotificationType>` + isbn13Identifier + `<DescriptiveDetail><ProductForm>ED</ProductForm>` + titleDetail + `</DescriptiveDetail>`,
+			err:  "deletion notices are not imported",
+		},
+		{
+			name: "printed book",
+			body: isbn13Identifier + `<DescriptiveDetail><ProductForm>BC</ProductForm>` + titleDetail + `</DescriptiveDetail>`,
+			err:  "not a digital product: form BC",
+		},
+		{
+			name: "audiobook and month date",
+			body: isbn13Identifier + `<DescriptiveDetail><ProductForm>AJ</ProductForm>` + titleDetail + `</DescriptiveDetail>
+				<PublishingDetail><Imprint><ImprintName>The Imprint</ImprintName></Imprint>
+				<PublishingDate><PublishingDateRole>01</PublishingDateRole><Date dateformat="01">202301</Date></PublishingDate></PublishingDetail>`,
+			expect: func(t *testing.T, p *stor.Publication) {
+				assert.Equal(t, contentTypeAudiobook, p.ContentType)
+				assert.Equal(t, []stor.Publisher{{Name: "The Imprint"}}, p.Publisher)
+				assert.Equal(t, "2023-01", p.DatePublished)
+			},
+		},
+		{
+			name: "PDF with short tags",
+			body: `<productidentifier><b221>15</b221><b244>9780306406157</b244></productidentifier>
+				<descriptivedetail><b012>ED</b012><b333>E107</b333>
+				<titledetail><b202>01</b202><titleelement><x409>01</x409><b030>The</b030><b031>Short Tags</b031></titleelement></titledetail>
+				<contributor><b035>A01</b035><b047>A Corporate Author</b047></contributor></descriptivedetail>`,
+			expect: func(t *testing.T, p *stor.Publication) {
+				assert.Equal(t, "9780306406157", p.ISBN)
+				assert.Equal(t, "The Short Tags", p.Title)
+				assert.Equal(t, contentTypePDF, p.ContentType)
+				assert.Equal(t, []stor.Author{{Name: "A Corporate Author"}}, p.Author)
+				assert.Empty(t, p.Contributors)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := ToPublication(product(t, tt.body))
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			if assert.NoError(t, err) {
+				tt.expect(t, p)
+			}
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+
+	importer := New(&conf.Config{}, &store)
+	body := isbn13Identifier + `<DescriptiveDetail><ProductForm>ED</ProductForm>` + titleDetail +
+		`<Contributor><ContributorRole>A01</ContributorRole><PersonName>An Author</PersonName></Contributor></DescriptiveDetail>`
+	updated := isbn13Identifier + `<DescriptiveDetail><ProductForm>ED</ProductForm>` + titleDetail +
+		`<Contributor><ContributorRole>B06</ContributorRole><PersonName>A Translator</PersonName></Contributor></DescriptiveDetail>`
+
+	// the same ISBN is created, rejected if invalid, then updated
+	report, err := importer.Import(context.Background(), strings.NewReader(message(
+		`<RecordReference>ref-1</RecordReference>`+body,
+		`<RecordReference>ref-2</RecordReference><DescriptiveDetail><ProductForm>ED</ProductForm>`+titleDetail+`</DescriptiveDetail>`,
+		`<RecordReference>ref-3</RecordReference>`+updated,
+	)))
+	if !assert.NoError(t, err) || !assert.Len(t, report.Records, 3) {
+		return
+	}
+	assert.Equal(t, 1, report.Created)
+	assert.Equal(t, 1, report.Updated)
+	assert.Equal(t, 1, report.Rejected)
+
+	created, rejected, replaced := report.Records[0], report.Records[1], report.Records[2]
+	assert.Equal(t, Record{Reference: "ref-1", ISBN: "9780306406157", UUID: created.UUID, Title: "The Title: A Subtitle", Status: StatusCreated}, created)
+	assert.Equal(t, Record{Reference: "ref-2", Title: "The Title: A Subtitle", Status: StatusRejected, Error: "no valid ISBN"}, rejected)
+	assert.Equal(t, StatusUpdated, replaced.Status)
+	assert.Equal(t, created.UUID, replaced.UUID)
+
+	// the update replaces the contributors of the publication
+	publication, err := store.GetPublication(created.UUID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, contentTypeEPUB, publication.ContentType)
+		assert.Empty(t, publication.Author)
+		if assert.Len(t, publication.Contributors, 1) {
+			assert.Equal(t, "A Translator", publication.Contributors[0].Name)
+		}
+		err = store.DeletePublication(publication)
+		assert.NoError(t, err)
+	}
+
+	// a malformed message is reported after the products read before
+	report, err = importer.Import(context.Background(), strings.NewReader(`<ONIXMessage><Product><RecordReference>ref-4</RecordReference></Product><Product><a001>`))
+	assert.ErrorContains(t, err, "malformed ONIX message after 1 products")
+	if assert.NotNil(t, report) {
+		assert.Equal(t, 1, report.Rejected)
+	}
+}
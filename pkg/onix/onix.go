@@ -0,0 +1,247 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+// Package onix imports publications from ONIX 3.0 product feeds.
+package onix
+
+import (
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Product is the subset of an ONIX 3.0 product record used by pubstore.
+// Elements are named after ONIX reference tags; short tags are renamed while decoding.
+type Product struct {
+	RecordReference   string `xml:"RecordReference"`
+	NotificationType  string `xml:"NotificationType"`
+	ProductIdentifier []struct {
+		ProductIDType string `xml:"ProductIDType"`
+		IDValue       string `xml:"IDValue"`
+	} `xml:"ProductIdentifier"`
+	DescriptiveDetail struct {
+		ProductForm       string   `xml:"ProductForm"`
+		ProductFormDetail []string `xml:"ProductFormDetail"`
+		TitleDetail       []struct {
+			TitleType    string `xml:"TitleType"`
+			TitleElement []struct {
+				TitleElementLevel  string `xml:"TitleElementLevel"`
+				TitleText          string `xml:"TitleText"`
+				TitlePrefix        string `xml:"TitlePrefix"`
+				TitleWithoutPrefix string `xml:"TitleWithoutPrefix"`
+				Subtitle           string `xml:"Subtitle"`
+			} `xml:"TitleElement"`
+		} `xml:"TitleDetail"`
+		Contributor []Contributor `xml:"Contributor"`
+		Language    []struct {
+			LanguageRole string `xml:"LanguageRole"`
+			LanguageCode string `xml:"LanguageCode"`
+		} `xml:"Language"`
+		Subject []struct {
+			SubjectSchemeIdentifier string `xml:"SubjectSchemeIdentifier"`
+			SubjectCode             string `xml:"SubjectCode"`
+			SubjectHeadingText      string `xml:"SubjectHeadingText"`
+		} `xml:"Subject"`
+	} `xml:"DescriptiveDetail"`
+	CollateralDetail struct {
+		TextContent        []TextContent `xml:"TextContent"`
+		SupportingResource []struct {
+			ResourceContentType string `xml:"ResourceContentType"`
+			ResourceMode        string `xml:"ResourceMode"`
+			ResourceVersion     []struct {
+				ResourceLink string `xml:"ResourceLink"`
+			} `xml:"ResourceVersion"`
+		} `xml:"SupportingResource"`
+	} `xml:"CollateralDetail"`
+	PublishingDetail struct {
+		Imprint []struct {
+			ImprintName string `xml:"ImprintName"`
+		} `xml:"Imprint"`
+		Publisher []struct {
+			PublishingRole string `xml:"PublishingRole"`
+			PublisherName  string `xml:"PublisherName"`
+		} `xml:"Publisher"`
+		PublishingDate []struct {
+			PublishingDateRole string `xml:"PublishingDateRole"`
+			Date               struct {
+				Value  string `xml:",chardata"`
+				Format string `xml:"dateformat,attr"`
+			} `xml:"Date"`
+		} `xml:"PublishingDate"`
+	} `xml:"PublishingDetail"`
+}
+
+// Contributor is a contributor to a product, e.g. its author or illustrator
+type Contributor struct {
+	SequenceNumber     string   `xml:"SequenceNumber"`
+	ContributorRole    []string `xml:"ContributorRole"`
+	PersonName         string   `xml:"PersonName"`
+	PersonNameInverted string   `xml:"PersonNameInverted"`
+	NamesBeforeKey     string   `xml:"NamesBeforeKey"`
+	KeyNames           string   `xml:"KeyNames"`
+	CorporateName      string   `xml:"CorporateName"`
+}
+
+// TextContent is a text describing a product
+type TextContent struct {
+	TextType string `xml:"TextType"`
+	Text     text   `xml:"Text"`
+}
+
+// text is the content of a text element, from which XHTML or HTML markup is removed
+type text string
+
+var blockTags = map[string]bool{"p": true, "br": true, "div": true, "li": true}
+
+func (t *text) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var sb strings.Builder
+	for depth := 0; ; {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			depth++
+		case xml.EndElement:
+			if depth == 0 {
+				*t = text(cleanText(sb.String()))
+				return nil
+			}
+			depth--
+			if blockTags[strings.ToLower(tok.Name.Local)] {
+				sb.WriteString("\n")
+			}
+		case xml.CharData:
+			sb.Write(tok)
+		}
+	}
+}
+
+var (
+	htmlBreak = regexp.MustCompile(`(?i)<(br\s*/?|/p|/div|/li)>`)
+	htmlTag   = regexp.MustCompile(`<[^>]*>`)
+	spaces    = regexp.MustCompile(`[ \t\r]+`)
+	newlines  = regexp.MustCompile(`\s*\n\s*`)
+)
+
+// cleanText removes escaped HTML markup and normalizes white space
+func cleanText(s string) string {
+	if strings.Contains(s, "<") {
+		s = htmlBreak.ReplaceAllString(s, "\n")
+		s = htmlTag.ReplaceAllString(s, "")
+		s = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&quot;", `"`, "&#39;", "'", "&nbsp;", " ").Replace(s)
+	}
+	s = spaces.ReplaceAllString(s, " ")
+	s = newlines.ReplaceAllString(s, "\n")
+	return strings.TrimSpace(s)
+}
+
+// shortTags maps the ONIX 3.0 short tags of the elements used by pubstore to their reference names
+var shortTags = map[string]string{
+	"product":            "Product",
+	"a001":               "RecordReference",
+	"a002":               "NotificationType",
+	"productidentifier":  "ProductIdentifier",
+	"b221":               "ProductIDType",
+	"b244":               "IDValue",
+	"descriptivedetail":  "DescriptiveDetail",
+	"b012":               "ProductForm",
+	"b333":               "ProductFormDetail",
+	"titledetail":        "TitleDetail",
+	"b202":               "TitleType",
+	"titleelement":       "TitleElement",
+	"x409":               "TitleElementLevel",
+	"b203":               "TitleText",
+	"b030":               "TitlePrefix",
+	"b031":               "TitleWithoutPrefix",
+	"b029":               "Subtitle",
+	"contributor":        "Contributor",
+	"b034":               "SequenceNumber",
+	"b035":               "ContributorRole",
+	"b036":               "PersonName",
+	"b037":               "PersonNameInverted",
+	"b039":               "NamesBeforeKey",
+	"b040":               "KeyNames",
+	"b047":               "CorporateName",
+	"language":           "Language",
+	"b253":               "LanguageRole",
+	"b252":               "LanguageCode",
+	"subject":            "Subject",
+	"b067":               "SubjectSchemeIdentifier",
+	"b069":               "SubjectCode",
+	"b070":               "SubjectHeadingText",
+	"collateraldetail":   "CollateralDetail",
+	"textcontent":        "TextContent",
+	"x426":               "TextType",
+	"d104":               "Text",
+	"supportingresource": "SupportingResource",
+	"x436":               "ResourceContentType",
+	"x437":               "ResourceMode",
+	"resourceversion":    "ResourceVersion",
+	"x435":               "ResourceLink",
+	"publishingdetail":   "PublishingDetail",
+	"imprint":            "Imprint",
+	"b079":               "ImprintName",
+	"publisher":          "Publisher",
+	"b291":               "PublishingRole",
+	"b081":               "PublisherName",
+	"publishingdate":     "PublishingDate",
+	"x448":               "PublishingDateRole",
+	"b306":               "Date",
+}
+
+// referenceTags renames short tags to reference tags
+type referenceTags struct {
+	d *xml.Decoder
+}
+
+func (r referenceTags) Token() (xml.Token, error) {
+	tok, err := r.d.Token()
+	switch t := tok.(type) {
+	case xml.StartElement:
+		if name, ok := shortTags[t.Name.Local]; ok {
+			t.Name.Local = name
+		}
+		return t, err
+	case xml.EndElement:
+		if name, ok := shortTags[t.Name.Local]; ok {
+			t.Name.Local = name
+		}
+		return t, err
+	}
+	return tok, err
+}
+
+// Reader reads the products of an ONIX 3.0 message one at a time,
+// which allows the import of large feeds.
+type Reader struct {
+	d *xml.Decoder
+}
+
+// NewReader returns a reader of the products of an ONIX 3.0 message, with reference or short tags
+func NewReader(r io.Reader) *Reader {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	return &Reader{d: xml.NewTokenDecoder(referenceTags{d})}
+}
+
+// Next returns the next product of the message, or io.EOF at the end of the message
+func (r *Reader) Next() (*Product, error) {
+	for {
+		tok, err := r.d.Token()
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "Product" {
+			var product Product
+			if err = r.d.DecodeElement(&product, &start); err != nil {
+				return nil, err
+			}
+			return &product, nil
+		}
+	}
+}
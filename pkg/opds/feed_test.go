@@ -0,0 +1,112 @@
+package opds
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// getFeed requests a feed, given by its path or absolute url, and decodes it
+func getFeed(t *testing.T, r http.Handler, target string) (Root, int) {
+	req := httptest.NewRequest("GET", strings.TrimPrefix(target, publicBaseUrl), nil)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	var feed Root
+	if recorder.Code == http.StatusOK {
+		err := json.Unmarshal(recorder.Body.Bytes(), &feed)
+		assert.NoError(t, err)
+	}
+	return feed, recorder.Code
+}
+
+// feedLink returns the href of the link of a feed with a given rel, or an empty string
+func feedLink(feed Root, rel string) string {
+	for _, link := range feed.Links {
+		if link.Rel == rel {
+			return link.Href
+		}
+	}
+	return ""
+}
+
+// walkFeed follows the next links of a feed from its first page and returns the titles of its publications
+func walkFeed(t *testing.T, r http.Handler, target string) []string {
+	var titles []string
+	for pages := 0; target != "" && pages < 10; pages++ {
+		feed, code := getFeed(t, r, target)
+		if !assert.Equal(t, http.StatusOK, code) {
+			break
+		}
+		for _, publication := range feed.Publications {
+			titles = append(titles, publication.Metadata.Title)
+		}
+		target = feedLink(feed, "next")
+	}
+	return titles
+}
+
+func TestFeedPagination(t *testing.T) {
+
+	r := chi.NewRouter()
+	r.Group(testopds.Router)
+
+	// five publications, with a category and a title word of their own
+	word := strings.ToLower(gofakeit.LetterN(12))
+	category := "Category " + word
+	publications := make([]*stor.Publication, 5)
+	var titles []string
+	for i := range publications {
+		publications[i] = &stor.Publication{
+			Title:       fmt.Sprintf("Walk %s %d", word, i),
+			UUID:        gofakeit.UUID(),
+			ContentType: "application/epub+zip",
+			Category:    []stor.Category{{Name: category}},
+		}
+		err := testopds.Store.CreatePublication(publications[i])
+		assert.NoError(t, err)
+		titles = append(titles, publications[i].Title)
+	}
+
+	feeds := []string{
+		"/opds/catalog?" + url.Values{"category": {category}, "pageSize": {"2"}}.Encode(),
+		"/opds/category/" + url.PathEscape(category) + "?pageSize=2",
+		"/opds/search?" + url.Values{"query": {word}, "pageSize": {"2"}}.Encode(),
+	}
+	for _, target := range feeds {
+		// next links hold cursors by default
+		feed, code := getFeed(t, r, target)
+		assert.Equal(t, http.StatusOK, code, target)
+		assert.Contains(t, feedLink(feed, "next"), "cursor=", target)
+		assert.Empty(t, feedLink(feed, "last"), target)
+		assert.ElementsMatch(t, titles, walkFeed(t, r, target), target)
+
+		// pages are numbered when requested
+		feed, code = getFeed(t, r, target+"&page=1")
+		assert.Equal(t, http.StatusOK, code, target)
+		next := feedLink(feed, "next")
+		assert.Contains(t, next, "page=2", target)
+		assert.NotContains(t, next, "cursor=", target)
+		assert.Contains(t, feedLink(feed, "last"), "page=3", target)
+		assert.Equal(t, 5, int(feed.Metadata.NumberOfItems), target)
+		assert.ElementsMatch(t, titles, walkFeed(t, r, target+"&page=1"), target)
+
+		// an invalid cursor is rejected
+		_, code = getFeed(t, r, target+"&cursor=invalid")
+		assert.Equal(t, http.StatusBadRequest, code, target)
+	}
+
+	// clean up test data
+	for _, publication := range publications {
+		err := testopds.Store.DeletePublication(publication)
+		assert.NoError(t, err)
+	}
+}
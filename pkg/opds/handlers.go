@@ -7,13 +7,17 @@ package opds
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/edrlab/pubstore/pkg/lcp"
 	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/go-chi/chi/v5"
 )
 
 // GetAuthenticationDoc returns an OPDS authentication document
@@ -41,15 +45,96 @@ func GetAuthenticationDoc(w http.ResponseWriter, _ *http.Request) {
 	w.Write([]byte(jsonData))
 }
 
-// GetCatalog returns the full OPDS Catalog
+// defaultPageSize is used when neither the request nor the configuration set a page size
+const defaultPageSize = 50
+
+// pagination returns the page and pageSize query parameters, or their default values
+func (opds *Opds) pagination(r *http.Request) (int, int) {
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	pageSize, err := strconv.Atoi(r.URL.Query().Get("pageSize"))
+	if err != nil || pageSize < 1 || pageSize > 1000 {
+		pageSize = opds.Config.PageSize
+		if pageSize < 1 {
+			pageSize = defaultPageSize
+		}
+	}
+	return page, pageSize
+}
+
+// cursorParam returns the cursor at which a feed starts, or nil if the feed is paged by page number.
+// Feeds are walked with cursors unless the request has a page parameter; no cursor, or an empty one,
+// starts a walk through the publications.
+func cursorParam(r *http.Request) *string {
+	if !r.URL.Query().Has("cursor") && r.URL.Query().Has("page") {
+		return nil
+	}
+	cursor := r.URL.Query().Get("cursor")
+	return &cursor
+}
+
+// GetCatalog returns a page of the OPDS Catalog, optionally filtered by a facet
 func (opds *Opds) GetCatalog(w http.ResponseWriter, r *http.Request) {
 
-	// TODO add pagination in opds feed
-	opdsFeed, err := opds.GenerateOpdsFeed(1, 50)
+	page, pageSize := opds.pagination(r)
+
+	var facet, value string
+	for _, f := range []string{"author", "publisher", "language", "category"} {
+		if v := r.URL.Query().Get(f); v != "" {
+			facet = f
+			value = v
+			break
+		}
+	}
+
+	opdsFeed, err := opds.GenerateOpdsFeed(facet, value, cursorParam(r), page, pageSize)
+	if errors.Is(err, stor.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		fmt.Fprintf(w, "opds feed : %v!", err)
+		http.Error(w, "Failed to generate the OPDS feed: "+err.Error(), http.StatusInternalServerError)
+		return
 	}
-	// Encode the publication as JSON and write it to the response
+	// Encode the feed as JSON and write it to the response
+	w.Header().Set("Content-Type", "application/opds+json")
+	err = json.NewEncoder(w).Encode(opdsFeed)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetSearch returns an OPDS feed of the publications matching a search.
+// The query, title, author, publisher and language parameters are combined: publications must match all of them.
+func (opds *Opds) GetSearch(w http.ResponseWriter, r *http.Request) {
+
+	page, pageSize := opds.pagination(r)
+
+	params := url.Values{}
+	for _, param := range searchParams {
+		if v := r.URL.Query().Get(param); v != "" {
+			params.Set(param, v)
+		}
+	}
+	if len(params) == 0 {
+		http.Error(w, "Missing search parameter", http.StatusBadRequest)
+		return
+	}
+
+	opdsFeed, err := opds.GenerateSearchFeed(params, cursorParam(r), page, pageSize)
+	if errors.Is(err, stor.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Failed to generate the OPDS feed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Encode the feed as JSON and write it to the response
 	w.Header().Set("Content-Type", "application/opds+json")
 	err = json.NewEncoder(w).Encode(opdsFeed)
 	if err != nil {
@@ -58,6 +143,70 @@ func (opds *Opds) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetOpenSearchDescription returns an OpenSearch description document
+func GetOpenSearchDescription(w http.ResponseWriter, _ *http.Request) {
+	xmlData := `<?xml version="1.0" encoding="UTF-8"?>
+<OpenSearchDescription xmlns="http://a9.com/-/spec/opensearch/1.1/">
+  <ShortName>Pubstore</ShortName>
+  <Description>Search the Pubstore catalog</Description>
+  <InputEncoding>UTF-8</InputEncoding>
+  <OutputEncoding>UTF-8</OutputEncoding>
+  <Image height="90" width="90" type="image/jpeg">` + publicBaseUrl + `/static/images/edrlab-logo.jpeg</Image>
+  <Url type="application/opds+json" template="` + publicBaseUrl + `/opds/search?query={searchTerms}&amp;page={startPage?}&amp;pageSize={count?}"/>
+</OpenSearchDescription>`
+
+	w.Header().Set("Content-Type", "application/opensearchdescription+xml")
+	w.Write([]byte(xmlData))
+}
+
+// GetRoot returns the OPDS navigation feed used as an entry point
+func (opds *Opds) GetRoot(w http.ResponseWriter, r *http.Request) {
+
+	opdsFeed, err := opds.GenerateRootFeed()
+	if err != nil {
+		http.Error(w, "Failed to generate the OPDS feed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Encode the feed as JSON and write it to the response
+	w.Header().Set("Content-Type", "application/opds+json")
+	err = json.NewEncoder(w).Encode(opdsFeed)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetFacetFeed returns a handler for the OPDS feed of the publications associated with a facet value,
+// e.g. an author or a category. facet is "author" || "publisher" || "language" || "category".
+func (opds *Opds) GetFacetFeed(facet string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		value, err := url.PathUnescape(chi.URLParam(r, "name"))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		page, pageSize := opds.pagination(r)
+
+		opdsFeed, err := opds.GenerateFacetFeed(facet, value, cursorParam(r), page, pageSize)
+		if errors.Is(err, stor.ErrInvalidCursor) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, "Failed to generate the OPDS feed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Encode the feed as JSON and write it to the response
+		w.Header().Set("Content-Type", "application/opds+json")
+		err = json.NewEncoder(w).Encode(opdsFeed)
+		if err != nil {
+			http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
 // GetPublication returns an OPDS Publication
 func (o *Opds) GetPublication(w http.ResponseWriter, r *http.Request) {
 
@@ -68,7 +217,7 @@ func (o *Opds) GetPublication(w http.ResponseWriter, r *http.Request) {
 		authentified = true
 	}
 
-	storPublication, ok := ctx.Value("publication").(*stor.Publication)
+	storPublication, ok := fromPubContext(ctx)
 	if !ok {
 		http.Error(w, http.StatusText(500), 500)
 		return
@@ -96,25 +245,38 @@ func (o *Opds) GetPublication(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			// http.Error(w, "Failed to get user", http.StatusInternalServerError)
 			fmt.Println("Failed to get user : " + credential)
-			pub.Links = append(pub.Links, publicationAcquisitionLinkChoice("notAuthentified", storPublication.UUID, "", "", time.Time{}, time.Time{}))
+			pub.Links = append(pub.Links, o.borrowLinks("notAuthentified", storPublication, nil)...)
 			return
 		}
 
 		transaction, err := o.getTransactionFromUserAndPubUUID(user, storPublication.UUID)
 		if err != nil {
-			pub.Links = append(pub.Links, publicationAcquisitionLinkChoice("authentified", storPublication.UUID, "", "", time.Time{}, time.Time{}))
+			pub.Links = append(pub.Links, o.borrowLinks("authentified", storPublication, user)...)
 			return
 		}
 
-		lsdStatus, err := lcp.GetStatusDocument(o.Config.LCPServer, transaction)
+		lsdStatus, err := o.LCP.GetStatusDocument(r.Context(), transaction)
 		if err != nil {
-			lsdStatus = &lcp.LsdStatus{}
+			lsdStatus = lcp.RecordedStatus(transaction, err)
+		}
+		if err = o.Store.UpdateTransactionStatus(transaction, lsdStatus.StatusCode); err != nil {
+			fmt.Println("Failed to update the transaction status : " + err.Error())
+		}
+		// a returned, expired or revoked license does not prevent borrowing the publication again
+		if lsdStatus.StatusCode != lcp.StatusReady && lsdStatus.StatusCode != lcp.StatusActive {
+			pub.Links = append(pub.Links, o.borrowLinks("authentified", storPublication, user)...)
+			return
+		}
+		link := publicationAcquisitionLinkChoice("authentifiedAndBorrowed", storPublication.UUID, lsdStatus.StatusCode, user.HPassphrase, lsdStatus.StartDate, lsdStatus.EndDate)
+		link.Properties.TransactionType = transaction.Type
+		pub.Links = append(pub.Links, link)
+		if transaction.Type == stor.TransactionTypeLoan {
+			pub.Links = append(pub.Links, loanInteractionLinks(storPublication.UUID)...)
 		}
-		pub.Links = append(pub.Links, publicationAcquisitionLinkChoice("authentifiedAndBorrowed", storPublication.UUID, lsdStatus.StatusCode, user.HPassphrase, lsdStatus.StartDate, lsdStatus.EndDate))
 
 	} else {
 		// add borrow link
-		pub.Links = append(pub.Links, publicationAcquisitionLinkChoice("notAuthentified", storPublication.UUID, "", "", time.Time{}, time.Time{}))
+		pub.Links = append(pub.Links, o.borrowLinks("notAuthentified", storPublication, nil)...)
 	}
 }
 
@@ -127,7 +289,7 @@ func (opds *Opds) GetPublicationBorrow(w http.ResponseWriter, r *http.Request) {
 		GetAuthenticationDoc(w, r)
 		return
 	}
-	storPublication, ok := ctx.Value("publication").(*stor.Publication)
+	storPublication, ok := fromPubContext(ctx)
 	if !ok {
 		http.Error(w, http.StatusText(500), 500)
 		return
@@ -135,37 +297,84 @@ func (opds *Opds) GetPublicationBorrow(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/opds/publication/"+storPublication.UUID, http.StatusFound)
 }
 
-// GetPublicationLoan is currently empty
-func (opds *Opds) GetPublicationLoan(w http.ResponseWriter, r *http.Request) {
+// GetPublicationLoan lends a publication to the authenticated user and returns the LCP license
+func (o *Opds) GetPublicationLoan(w http.ResponseWriter, r *http.Request) {
 
-	/*
-		ctx := r.Context()
-		credential, ok := ctx.Value(CredentialContext).(string)
-		if !ok {
-			opds.GetAuthenticationDoc(w, r)
-			return
+	ctx := r.Context()
+	credential, ok := ctx.Value(CredentialContext).(string)
+	if !ok {
+		GetAuthenticationDoc(w, r)
+		return
+	}
+	user, err := o.Store.GetUserByEmail(credential)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return
+	}
+
+	storPublication, ok := fromPubContext(ctx)
+	if !ok {
+		http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+		return
+	}
+
+	// a loan requires an available copy, which is reserved before the license is generated
+	transaction := &stor.Transaction{
+		UserID:        user.ID,
+		PublicationID: storPublication.ID,
+	}
+	if err = o.Store.CreateLoan(transaction); err != nil {
+		if errors.Is(err, stor.ErrNoCopyAvailable) || errors.Is(err, stor.ErrLoanOngoing) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to check the availability of the publication", http.StatusInternalServerError)
 		}
-		user, err := opds.stor.GetUserByEmail(credential)
-		if err != nil {
-			http.Error(w, "Failed to get transaction", http.StatusInternalServerError)
-			return
+		return
+	}
+	// the reserved copy is released if the license cannot be generated
+	fail := func(message string, code int) {
+		if deleteErr := o.Store.DeleteTransaction(transaction); deleteErr != nil {
+			fmt.Println("Failed to release the reserved copy: " + deleteErr.Error())
 		}
+		http.Error(w, message, code)
+	}
 
-		storPublication, ok := ctx.Value("publication").(*stor.Publication)
-		if !ok {
-			http.Error(w, http.StatusText(500), 500)
-			return
-		}
+	// the loan starts now and lasts the configured number of days
+	print, copy, duration := o.Config.DefaultRights(stor.TransactionTypeLoan)
+	start := time.Now().UTC().Truncate(time.Second)
+	var end time.Time
+	if duration > 0 {
+		end = start.AddDate(0, 0, duration)
+	}
 
-		licenceBytes, err := lcp.LicenceLoan(storPublication.UUID, user.UUID, user.Email, user.TextHint, user.HPassphrase, 100, 2000, time.Now(), time.Now().AddDate(0, 0, 7))
-		if err != nil {
-			http.Error(w, http.StatusText(500), 500)
-			return
-		}
+	licenseReq := lcp.NewLicenseRequest(storPublication.UUID, user, print, copy, start, end)
+
+	licenceBytes, err := o.LCP.GenerateLicense(r.Context(), licenseReq)
+	if err != nil {
+		fail("License acquisition failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = lcp.RecordLicense(transaction, licenceBytes)
+	if err != nil {
+		fail("License acquisition failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	err = o.Store.UpdateTransaction(transaction)
+	if err != nil {
+		fail("Failed to create transaction", http.StatusInternalServerError)
+		return
+	}
 
-		w.Header().Set("Content-Type", "application/vnd.readium.lcp.license.v1.0+json")
-		io.Copy(w, bytes.NewReader(licenceBytes))
-	*/
+	// the hold placed by the user, if any, is fulfilled
+	if err = o.Store.FulfillHold(user.ID, storPublication.ID); err != nil {
+		fmt.Println("Failed to fulfill hold : " + err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.readium.lcp.license.v1.0+json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(licenceBytes)))
+	w.WriteHeader(http.StatusCreated)
+	io.Copy(w, bytes.NewReader(licenceBytes))
 }
 
 // GetPublicationLicense returns the LCP license attached to a user / publication tuple
@@ -183,7 +392,7 @@ func (o *Opds) GetPublicationLicense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	storPublication, ok := ctx.Value("publication").(*stor.Publication)
+	storPublication, ok := fromPubContext(ctx)
 	if !ok {
 		http.Error(w, http.StatusText(500), http.StatusInternalServerError)
 		return
@@ -195,7 +404,7 @@ func (o *Opds) GetPublicationLicense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	licenceBytes, err := lcp.GetFreshLicense(o.Config.LCPServer, transaction)
+	licenceBytes, err := o.LCP.GetFreshLicense(r.Context(), transaction)
 	if err != nil {
 		http.Error(w, http.StatusText(500), 500)
 		return
@@ -205,6 +414,210 @@ func (o *Opds) GetPublicationLicense(w http.ResponseWriter, r *http.Request) {
 	io.Copy(w, bytes.NewReader(licenceBytes))
 }
 
+// ReturnPublicationLoan returns a loan before its end and returns the updated status document
+func (o *Opds) ReturnPublicationLoan(w http.ResponseWriter, r *http.Request) {
+
+	transaction, ok := o.userLoan(w, r)
+	if !ok {
+		return
+	}
+
+	lsd, err := o.LCP.ReturnLicense(r.Context(), transaction)
+	if err != nil {
+		http.Error(w, "License return failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the loan ends now
+	now := time.Now().UTC().Truncate(time.Second)
+	transaction.EndDate = &now
+	transaction.Status = lsd.Status
+	err = o.Store.UpdateTransaction(transaction)
+	if err != nil {
+		http.Error(w, "Failed to update transaction", http.StatusInternalServerError)
+		return
+	}
+
+	// the copy goes to the next hold in line
+	if _, err = o.Store.PromoteHolds(transaction.PublicationID, o.Config.HoldReadyPeriod()); err != nil {
+		fmt.Println("Failed to promote holds : " + err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.readium.license.status.v1.0+json")
+	json.NewEncoder(w).Encode(lsd)
+}
+
+// RenewPublicationLoan extends a loan and returns the updated status document.
+// The new end date may be passed as an end parameter; by default the loan is extended by the loan duration.
+// A loan is not extended beyond its maximum duration.
+func (o *Opds) RenewPublicationLoan(w http.ResponseWriter, r *http.Request) {
+
+	transaction, ok := o.userLoan(w, r)
+	if !ok {
+		return
+	}
+
+	var requested time.Time
+	var err error
+	if endParam := r.URL.Query().Get("end"); endParam != "" {
+		requested, err = time.Parse(time.RFC3339, endParam)
+		if err != nil {
+			http.Error(w, "Invalid end date", http.StatusBadRequest)
+			return
+		}
+	}
+	_, _, duration := o.Config.DefaultRights(stor.TransactionTypeLoan)
+	end, err := lcp.RenewalEnd(transaction, requested, duration, o.Config.MaxDuration(stor.TransactionTypeLoan))
+	if errors.Is(err, lcp.ErrRenewalEnd) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	lsd, err := o.LCP.RenewLicense(r.Context(), transaction, end)
+	if err != nil {
+		http.Error(w, "License renewal failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	transaction.EndDate = &end
+	transaction.Status = lsd.Status
+	err = o.Store.UpdateTransaction(transaction)
+	if err != nil {
+		http.Error(w, "Failed to update transaction", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.readium.license.status.v1.0+json")
+	json.NewEncoder(w).Encode(lsd)
+}
+
+// userLoan retrieves the last transaction of the authenticated user on the publication in context, which must be a loan.
+// If it fails, an error is written in the response.
+func (o *Opds) userLoan(w http.ResponseWriter, r *http.Request) (*stor.Transaction, bool) {
+
+	ctx := r.Context()
+	credential, ok := ctx.Value(CredentialContext).(string)
+	if !ok {
+		GetAuthenticationDoc(w, r)
+		return nil, false
+	}
+	user, err := o.Store.GetUserByEmail(credential)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	storPublication, ok := fromPubContext(ctx)
+	if !ok {
+		http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+		return nil, false
+	}
+
+	transaction, err := o.Store.GetTransactionByUserAndPublication(user.ID, storPublication.ID)
+	if err != nil {
+		http.Error(w, "Failed to get transaction", http.StatusNotFound)
+		return nil, false
+	}
+	if transaction.Type != stor.TransactionTypeLoan {
+		http.Error(w, stor.ErrNotLoan.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return transaction, true
+}
+
+// PlacePublicationHold places a hold on a publication which has no copy available,
+// then returns the publication with its updated availability
+func (o *Opds) PlacePublicationHold(w http.ResponseWriter, r *http.Request) {
+
+	user, storPublication, ok := o.userAndPublication(w, r)
+	if !ok {
+		return
+	}
+
+	// a hold is useless if a copy can be borrowed
+	err := o.Store.CheckCopyAvailable(storPublication, user.ID)
+	if err == nil {
+		http.Error(w, "A copy is available for loan", http.StatusConflict)
+		return
+	}
+	if !errors.Is(err, stor.ErrNoCopyAvailable) {
+		http.Error(w, "Failed to check the availability of the publication", http.StatusInternalServerError)
+		return
+	}
+
+	hold := &stor.Hold{
+		UserID:        user.ID,
+		PublicationID: storPublication.ID,
+	}
+	if err = o.Store.CreateHold(hold); err != nil {
+		if errors.Is(err, stor.ErrHoldExists) {
+			http.Error(w, err.Error(), http.StatusConflict)
+		} else {
+			http.Error(w, "Failed to create hold", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	o.GetPublication(w, r)
+}
+
+// CancelPublicationHold cancels the hold placed by the user on a publication,
+// then returns the publication with its updated availability
+func (o *Opds) CancelPublicationHold(w http.ResponseWriter, r *http.Request) {
+
+	user, storPublication, ok := o.userAndPublication(w, r)
+	if !ok {
+		return
+	}
+
+	hold, err := o.Store.GetHold(user.ID, storPublication.ID)
+	if err != nil {
+		http.Error(w, "Failed to get hold", http.StatusNotFound)
+		return
+	}
+	if err = o.Store.DeleteHold(hold); err != nil {
+		http.Error(w, "Failed to delete hold", http.StatusInternalServerError)
+		return
+	}
+
+	// a copy kept for the user goes to the next hold in line
+	if hold.Status == stor.HoldStatusReady {
+		if _, err = o.Store.PromoteHolds(storPublication.ID, o.Config.HoldReadyPeriod()); err != nil {
+			fmt.Println("Failed to promote holds : " + err.Error())
+		}
+	}
+
+	o.GetPublication(w, r)
+}
+
+// userAndPublication returns the authenticated user and the publication of the request.
+// It writes an error to the response if one of them is missing.
+func (o *Opds) userAndPublication(w http.ResponseWriter, r *http.Request) (*stor.User, *stor.Publication, bool) {
+
+	ctx := r.Context()
+	credential, ok := ctx.Value(CredentialContext).(string)
+	if !ok {
+		GetAuthenticationDoc(w, r)
+		return nil, nil, false
+	}
+	user, err := o.Store.GetUserByEmail(credential)
+	if err != nil {
+		http.Error(w, "Failed to get user", http.StatusInternalServerError)
+		return nil, nil, false
+	}
+
+	storPublication, ok := fromPubContext(ctx)
+	if !ok {
+		http.Error(w, http.StatusText(500), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	return user, storPublication, true
+}
+
 // GetBookshelf returns a personal bookshelf feed
 func (opds *Opds) GetBookshelf(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
@@ -214,7 +627,7 @@ func (opds *Opds) GetBookshelf(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	opdsFeed, err := opds.GenerateBookshelfFeed(credential)
+	opdsFeed, err := opds.GenerateBookshelfFeed(ctx, credential)
 	if err != nil {
 		fmt.Println("Bookshelf : " + err.Error())
 	}
@@ -0,0 +1,252 @@
+package opds
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/edrlab/pubstore/pkg/lcp"
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// createUser creates a user in the store and returns it with a bearer token
+func createUser(t *testing.T, r http.Handler) (*stor.User, string) {
+	user := &stor.User{
+		UUID:       gofakeit.UUID(),
+		Name:       gofakeit.Name(),
+		Email:      gofakeit.Email(),
+		Password:   "password",
+		TextHint:   "hint",
+		Passphrase: "passphrase",
+	}
+	err := testopds.Store.CreateUser(user)
+	assert.NoError(t, err)
+
+	recorder := requestToken(r, user.Email, "password")
+	if !assert.Equal(t, http.StatusOK, recorder.Code) {
+		t.FailNow()
+	}
+	var tokenResp struct {
+		Token string `json:"access_token"`
+	}
+	err = json.Unmarshal(recorder.Body.Bytes(), &tokenResp)
+	assert.NoError(t, err)
+	return user, tokenResp.Token
+}
+
+// requestToken requests a bearer token with the credentials of a user
+func requestToken(r http.Handler, username, password string) *httptest.ResponseRecorder {
+	data := url.Values{
+		"grant_type": {"password"},
+		"username":   {username},
+		"password":   {password},
+	}
+	req := httptest.NewRequest("POST", "/opds/token", strings.NewReader(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// send sends a request to the OPDS server, with a bearer token if not empty
+func send(r http.Handler, method, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func TestAuthentication(t *testing.T) {
+
+	r := chi.NewRouter()
+	r.Group(testopds.Router)
+
+	user, token := createUser(t, r)
+	publication := &stor.Publication{Title: "Authentication", UUID: gofakeit.UUID(), ContentType: "application/epub+zip", Copies: 1}
+	err := testopds.Store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	// wrong credentials do not get a token
+	recorder := requestToken(r, user.Email, "wrong password")
+	assert.NotEqual(t, http.StatusOK, recorder.Code)
+	recorder = requestToken(r, gofakeit.Email(), "password")
+	assert.NotEqual(t, http.StatusOK, recorder.Code)
+
+	// without a valid token, acquisitions and interactions return the authentication document
+	path := "/opds/publication/" + publication.UUID
+	requests := []struct{ method, target string }{
+		{"GET", path + "/loan"},
+		{"GET", path + "/borrow"},
+		{"GET", path + "/license"},
+		{"PUT", path + "/return"},
+		{"PUT", path + "/renew"},
+		{"PUT", path + "/hold"},
+		{"DELETE", path + "/hold"},
+		{"GET", "/opds/bookshelf"},
+	}
+	for _, rq := range requests {
+		for _, bearer := range []string{"", "invalid-token"} {
+			recorder = send(r, rq.method, rq.target, bearer)
+			assert.Equal(t, http.StatusUnauthorized, recorder.Code, rq.target)
+			assert.Equal(t, "application/opds-authentication+json", recorder.Header().Get("Content-Type"), rq.target)
+			assert.Contains(t, recorder.Body.String(), "http://opds-spec.org/auth/oauth/password", rq.target)
+		}
+	}
+
+	// the publication is public, its acquisition links lead to the authentication document
+	recorder = send(r, "GET", path, "")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// an unknown publication is not found, even when authenticated
+	recorder = send(r, "GET", "/opds/publication/"+gofakeit.UUID()+"/loan", token)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	// no transaction was created
+	_, err = testopds.Store.GetTransactionByUserAndPublication(user.ID, publication.ID)
+	assert.Error(t, err)
+
+	// clean up test data
+	err = testopds.Store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = testopds.Store.DeleteUser(user)
+	assert.NoError(t, err)
+}
+
+func TestBorrow(t *testing.T) {
+
+	r := chi.NewRouter()
+	r.Group(testopds.Router)
+
+	defaultRights := testopds.Config.Rights
+	defer func() { testopds.Config.Rights = defaultRights }()
+	duration := 14
+	testopds.Config.Rights.Loan.Duration = &duration
+
+	borrower, borrowerToken := createUser(t, r)
+	holder, holderToken := createUser(t, r)
+	publication := &stor.Publication{Title: "Borrow", UUID: gofakeit.UUID(), ContentType: "application/epub+zip", Copies: 1}
+	err := testopds.Store.CreatePublication(publication)
+	assert.NoError(t, err)
+	path := "/opds/publication/" + publication.UUID
+
+	// the only copy is borrowed, the license is returned
+	recorder := send(r, "GET", path+"/loan", borrowerToken)
+	if !assert.Equal(t, http.StatusCreated, recorder.Code, recorder.Body.String()) {
+		t.FailNow()
+	}
+	assert.Equal(t, "application/vnd.readium.lcp.license.v1.0+json", recorder.Header().Get("Content-Type"))
+	body, err := io.ReadAll(recorder.Body)
+	assert.NoError(t, err)
+	assert.NoError(t, lcp.VerifySignature(body, nil))
+	license, err := lcp.ParseLicense(body)
+	assert.NoError(t, err)
+
+	loan, err := testopds.Store.GetTransactionByUserAndPublication(borrower.ID, publication.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, stor.TransactionTypeLoan, loan.Type)
+		assert.Equal(t, license.ID, loan.LicenceId)
+		assert.NotNil(t, loan.EndDate)
+	}
+
+	// the borrower cannot take a second copy, and no copy is left for others
+	recorder = send(r, "GET", path+"/loan", borrowerToken)
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), stor.ErrLoanOngoing.Error())
+	recorder = send(r, "GET", path+"/loan", holderToken)
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), stor.ErrNoCopyAvailable.Error())
+	_, err = testopds.Store.GetTransactionByUserAndPublication(holder.ID, publication.ID)
+	assert.Error(t, err)
+
+	// the other user places a hold
+	recorder = send(r, "PUT", path+"/hold", holderToken)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	hold, err := testopds.Store.GetHold(holder.ID, publication.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, stor.HoldStatusWaiting, hold.Status)
+	}
+
+	// the loan is renewed, then returned
+	recorder = send(r, "PUT", path+"/renew", borrowerToken)
+	assert.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	recorder = send(r, "PUT", path+"/return", borrowerToken)
+	assert.Equal(t, http.StatusOK, recorder.Code, recorder.Body.String())
+	var lsd lcp.StatusDoc
+	err = json.Unmarshal(recorder.Body.Bytes(), &lsd)
+	assert.NoError(t, err)
+	assert.Equal(t, lcp.StatusReturned, lsd.Status)
+
+	// the returned copy is kept for the holder, who borrows it
+	hold, err = testopds.Store.GetHold(holder.ID, publication.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, stor.HoldStatusReady, hold.Status)
+	}
+	recorder = send(r, "GET", path+"/loan", borrowerToken)
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	recorder = send(r, "GET", path+"/loan", holderToken)
+	assert.Equal(t, http.StatusCreated, recorder.Code, recorder.Body.String())
+	_, err = testopds.Store.GetHold(holder.ID, publication.ID)
+	assert.Error(t, err)
+
+	// clean up test data
+	for _, user := range []*stor.User{borrower, holder} {
+		transactions, err := testopds.Store.FindTransactionsByUser(user.ID)
+		if assert.NoError(t, err) {
+			for i := range *transactions {
+				err = testopds.Store.DeleteTransaction(&(*transactions)[i])
+				assert.NoError(t, err)
+			}
+		}
+	}
+	err = testopds.Store.DeletePublication(publication)
+	assert.NoError(t, err)
+	for _, user := range []*stor.User{borrower, holder} {
+		err = testopds.Store.DeleteUser(user)
+		assert.NoError(t, err)
+	}
+}
+
+func TestReturnPurchase(t *testing.T) {
+
+	r := chi.NewRouter()
+	r.Group(testopds.Router)
+
+	user, token := createUser(t, r)
+	publication := &stor.Publication{Title: "Purchase", UUID: gofakeit.UUID(), ContentType: "application/epub+zip"}
+	err := testopds.Store.CreatePublication(publication)
+	assert.NoError(t, err)
+	path := "/opds/publication/" + publication.UUID
+
+	// nothing to return or renew before an acquisition
+	recorder := send(r, "PUT", path+"/return", token)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+	purchase := &stor.Transaction{UserID: user.ID, PublicationID: publication.ID, LicenceId: gofakeit.UUID(), Type: stor.TransactionTypePurchase}
+	err = testopds.Store.CreateTransaction(purchase)
+	assert.NoError(t, err)
+
+	// a purchase can be neither returned nor renewed
+	for _, action := range []string{"return", "renew"} {
+		recorder = send(r, "PUT", path+"/"+action, token)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code, action)
+		assert.Contains(t, recorder.Body.String(), stor.ErrNotLoan.Error(), action)
+	}
+
+	// clean up test data
+	err = testopds.Store.DeleteTransaction(purchase)
+	assert.NoError(t, err)
+	err = testopds.Store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = testopds.Store.DeleteUser(user)
+	assert.NoError(t, err)
+}
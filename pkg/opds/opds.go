@@ -8,27 +8,53 @@ import (
 	"time"
 
 	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/lcp"
 	"github.com/edrlab/pubstore/pkg/stor"
 )
 
 type MetadataFeed struct {
-	Title string `json:"title"`
+	Title         string `json:"title"`
+	NumberOfItems int64  `json:"numberOfItems,omitempty"`
+	ItemsPerPage  int    `json:"itemsPerPage,omitempty"`
+	CurrentPage   int    `json:"currentPage,omitempty"`
 }
 
-// TODO: an OPDS Publication supports x languages as an array -> update the model and mapping
+// Metadata of an OPDS Publication, as defined by the Readium Web Publication Manifest
 type Metadata struct {
-	Type       string `json:"@type"`
-	Title      string `json:"title"`
-	Author     string `json:"author,omitempty"`
-	Identifier string `json:"identifier,omitempty"`
-	Language   string `json:"language,omitempty"`
-	Published  string `json:"published,omitempty"`
+	Type        string        `json:"@type"`
+	Title       string        `json:"title"`
+	Author      []Contributor `json:"author,omitempty"`
+	Translator  []Contributor `json:"translator,omitempty"`
+	Editor      []Contributor `json:"editor,omitempty"`
+	Illustrator []Contributor `json:"illustrator,omitempty"`
+	Narrator    []Contributor `json:"narrator,omitempty"`
+	Publisher   []Contributor `json:"publisher,omitempty"`
+	Identifier  string        `json:"identifier,omitempty"`
+	Language    []string      `json:"language,omitempty"`
+	Subject     []Subject     `json:"subject,omitempty"`
+	Description string        `json:"description,omitempty"`
+	Published   string        `json:"published,omitempty"`
+	Modified    *time.Time    `json:"modified,omitempty"`
+}
+
+// Contributor is an author, publisher or other contributor of a publication
+type Contributor struct {
+	Name  string `json:"name"`
+	Links []Link `json:"links,omitempty"`
+}
+
+// Subject is a category of a publication
+type Subject struct {
+	Name  string `json:"name"`
+	Links []Link `json:"links,omitempty"`
 }
 
 type Link struct {
 	Rel        string      `json:"rel,omitempty"`
 	Href       string      `json:"href,omitempty"`
 	Type       string      `json:"type,omitempty"`
+	Title      string      `json:"title,omitempty"`
+	Templated  bool        `json:"templated,omitempty"`
 	Child      []Link      `json:"child,omitempty"`
 	Properties *Properties `json:"properties,omitempty"`
 }
@@ -46,27 +72,69 @@ type Image struct {
 	Width  int    `json:"width,omitempty"`
 }
 
+// Availability states of an acquisition link
+const (
+	AvailabilityAvailable   = "available"
+	AvailabilityUnavailable = "unavailable"
+	AvailabilityReserved    = "reserved"
+	AvailabilityReady       = "ready"
+)
+
 type Availability struct {
 	Status    string     `json:"state,omitempty"`
 	StartDate *time.Time `json:"since,omitempty"`
 	EndDate   *time.Time `json:"until,omitempty"`
 }
 
+// Copies indicates how many copies of a publication can be lent
+type Copies struct {
+	Total     int   `json:"total"`
+	Available int64 `json:"available"`
+}
+
+// Holds indicates how many holds are placed on a publication, and the position of the user in the queue
+type Holds struct {
+	Total    int64 `json:"total"`
+	Position int64 `json:"position,omitempty"`
+}
+
 type Properties struct {
+	NumberOfItems       int64         `json:"numberOfItems,omitempty"`
 	Availability        *Availability `json:"availability,omitempty"`
+	Copies              *Copies       `json:"copies,omitempty"`
+	Holds               *Holds        `json:"holds,omitempty"`
 	IndirectAcquisition []Link        `json:"indirectAcquisition,omitempty"`
 	LcpHashedPassphrase string        `json:"lcp_hashed_passphrase,omitempty"`
+	TransactionType     string        `json:"transaction_type,omitempty"`
+}
+
+// Facet is a group of links used to filter a feed
+type Facet struct {
+	Metadata MetadataFeed `json:"metadata"`
+	Links    []Link       `json:"links"`
+}
+
+// Group is a collection of navigation links or publications inside a feed
+type Group struct {
+	Metadata     MetadataFeed  `json:"metadata"`
+	Links        []Link        `json:"links,omitempty"`
+	Navigation   []Link        `json:"navigation,omitempty"`
+	Publications []Publication `json:"publications,omitempty"`
 }
 
 type Root struct {
 	Metadata     MetadataFeed  `json:"metadata"`
 	Links        []Link        `json:"links"`
-	Publications []Publication `json:"publications"`
+	Facets       []Facet       `json:"facets,omitempty"`
+	Navigation   []Link        `json:"navigation,omitempty"`
+	Groups       []Group       `json:"groups,omitempty"`
+	Publications []Publication `json:"publications,omitempty"`
 }
 
 type Opds struct {
 	*conf.Config
 	*stor.Store
+	LCP lcp.LicenseProvider
 }
 
 // PublicBaseURL is set with the base URL of the server.
@@ -74,12 +142,13 @@ type Opds struct {
 var publicBaseUrl string
 
 // Init initializes the module
-func Init(c *conf.Config, s *stor.Store) Opds {
+func Init(c *conf.Config, s *stor.Store, lp lcp.LicenseProvider) Opds {
 
 	publicBaseUrl = c.PublicBaseUrl
 
 	return Opds{
 		Config: c,
 		Store:  s,
+		LCP:    lp,
 	}
 }
@@ -0,0 +1,37 @@
+package opds
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/lcp"
+	"github.com/edrlab/pubstore/pkg/stor"
+)
+
+var testopds Opds
+
+func TestMain(m *testing.M) {
+
+	config := conf.Config{OAuthSeed: "EDRLAB_Rocks", PublicBaseUrl: "http://localhost:8080", PageSize: 10, HoldDuration: 3}
+
+	store, err := stor.Init("sqlite3://file::memory:?cache=shared")
+	if err != nil {
+		panic("Database setup failed.")
+	}
+
+	lp, err := lcp.NewFake(config.PublicBaseUrl)
+	if err != nil {
+		panic("License provider setup failed.")
+	}
+
+	testopds = Init(&config, &store, lp)
+
+	// Run the tests
+	exitCode := m.Run()
+
+	fmt.Println("ExitCode", exitCode)
+	// Exit with the appropriate exit code
+	os.Exit(exitCode)
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/edrlab/pubstore/pkg/internal/auth"
+	"github.com/edrlab/pubstore/pkg/stor"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/cors"
 	"github.com/go-chi/oauth"
@@ -37,7 +38,14 @@ func (o *Opds) Router(r chi.Router) {
 	r.Post("/opds/token", s.UserCredentials)
 	r.Get("/401", GetAuthenticationDoc)
 	r.Route("/opds", func(r chi.Router) {
+		r.Get("/", o.GetRoot)
 		r.Get("/catalog", o.GetCatalog)
+		r.Get("/author/{name}", o.GetFacetFeed("author"))
+		r.Get("/publisher/{name}", o.GetFacetFeed("publisher"))
+		r.Get("/language/{name}", o.GetFacetFeed("language"))
+		r.Get("/category/{name}", o.GetFacetFeed("category"))
+		r.Get("/search", o.GetSearch)
+		r.Get("/opensearch.xml", GetOpenSearchDescription)
 		r.Route("/publication/{id}", func(r chi.Router) {
 			// TODO: check the use of authorizePassthrough
 			r.Use(authorizePassthrough(o.Config.OAuthSeed, nil))
@@ -46,6 +54,10 @@ func (o *Opds) Router(r chi.Router) {
 			r.Get("/loan", o.GetPublicationLoan)
 			r.Get("/borrow", o.GetPublicationBorrow)
 			r.Get("/license", o.GetPublicationLicense)
+			r.Put("/return", o.ReturnPublicationLoan)
+			r.Put("/renew", o.RenewPublicationLoan)
+			r.Put("/hold", o.PlacePublicationHold)
+			r.Delete("/hold", o.CancelPublicationHold)
 		})
 		r.Group(func(r chi.Router) {
 			// TODO: check why a custom authorize is required
@@ -60,6 +72,17 @@ type key int
 
 var pubKey key
 
+// newPubContext returns a new Context that carries a Publication.
+func newPubContext(ctx context.Context, p *stor.Publication) context.Context {
+	return context.WithValue(ctx, pubKey, p)
+}
+
+// fromPubContext returns the Publication value stored in ctx, if any.
+func fromPubContext(ctx context.Context) (*stor.Publication, bool) {
+	p, ok := ctx.Value(pubKey).(*stor.Publication)
+	return p, ok
+}
+
 func (o *Opds) publicationCtx(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		pubID := chi.URLParam(r, "id")
@@ -68,7 +91,7 @@ func (o *Opds) publicationCtx(next http.Handler) http.Handler {
 			http.Error(w, http.StatusText(404), 404)
 			return
 		}
-		ctx := context.WithValue(r.Context(), pubKey, pub)
+		ctx := newPubContext(r.Context(), pub)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
@@ -5,11 +5,17 @@
 package opds
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/edrlab/pubstore/pkg/cover"
 	"github.com/edrlab/pubstore/pkg/lcp"
 	"github.com/edrlab/pubstore/pkg/stor"
 )
@@ -25,7 +31,7 @@ func publicationAcquisitionLinkChoice(choice string, pubUUID, statusCode, lcpHas
 			Href: publicBaseUrl + "/opds/publication/" + pubUUID + "/loan",
 			Properties: &Properties{
 				Availability: &Availability{
-					Status: "available",
+					Status: AvailabilityAvailable,
 				},
 				IndirectAcquisition: []Link{
 					{
@@ -47,7 +53,7 @@ func publicationAcquisitionLinkChoice(choice string, pubUUID, statusCode, lcpHas
 			Href: publicBaseUrl + "/opds/publication/" + pubUUID + "/borrow",
 			Properties: &Properties{
 				Availability: &Availability{
-					Status: "available",
+					Status: AvailabilityAvailable,
 				},
 				IndirectAcquisition: []Link{
 					{
@@ -89,6 +95,85 @@ func publicationAcquisitionLinkChoice(choice string, pubUUID, statusCode, lcpHas
 
 }
 
+// borrowLinks returns an acquisition link whose availability reflects the copies of the publication left for loan
+// and the hold placed by the user, if any. A hold link is added when the user can place or cancel a hold.
+// choice is "authentified" || "notAuthentified"; user is nil if not authentified.
+func (o *Opds) borrowLinks(choice string, storPublication *stor.Publication, user *stor.User) []Link {
+
+	link := publicationAcquisitionLinkChoice(choice, storPublication.UUID, "", "", time.Time{}, time.Time{})
+
+	holdCount, err := o.Store.CountHolds(storPublication.ID)
+	if err != nil {
+		fmt.Println("Failed to count holds : " + err.Error())
+	}
+	link.Properties.Holds = &Holds{Total: holdCount}
+
+	// the hold placed by the user
+	if user != nil {
+		if hold, err := o.Store.GetHold(user.ID, storPublication.ID); err == nil {
+			link.Properties.Availability.Status = AvailabilityReserved
+			if hold.Status == stor.HoldStatusReady {
+				link.Properties.Availability.Status = AvailabilityReady
+				link.Properties.Availability.EndDate = hold.ExpiresAt
+			}
+			if position, err := o.Store.HoldPosition(hold); err == nil {
+				link.Properties.Holds.Position = position
+			}
+			return []Link{link, holdLink(storPublication.UUID)}
+		}
+	}
+	if holdCount == 0 {
+		link.Properties.Holds = nil
+	}
+
+	// an unlimited number of copies is always available
+	if storPublication.Copies == 0 {
+		return []Link{link}
+	}
+	available, err := o.Store.AvailableCopies(storPublication)
+	if err != nil {
+		fmt.Println("Failed to count available copies : " + err.Error())
+		return []Link{link}
+	}
+	link.Properties.Copies = &Copies{
+		Total:     storPublication.Copies,
+		Available: available,
+	}
+	if available == 0 {
+		link.Properties.Availability.Status = AvailabilityUnavailable
+		if user != nil {
+			return []Link{link, holdLink(storPublication.UUID)}
+		}
+	}
+	return []Link{link}
+}
+
+// holdLink returns the link used to place (HTTP PUT) or cancel (HTTP DELETE) a hold on a publication
+func holdLink(pubUUID string) Link {
+	return Link{
+		Rel:  "hold",
+		Href: publicBaseUrl + "/opds/publication/" + pubUUID + "/hold",
+		Type: "application/opds-publication+json",
+	}
+}
+
+// loanInteractionLinks returns the links used to return or renew a loan (HTTP PUT)
+func loanInteractionLinks(pubUUID string) []Link {
+	return []Link{
+		{
+			Rel:  "return",
+			Href: publicBaseUrl + "/opds/publication/" + pubUUID + "/return",
+			Type: "application/vnd.readium.license.status.v1.0+json",
+		},
+		{
+			Rel:       "renew",
+			Href:      publicBaseUrl + "/opds/publication/" + pubUUID + "/renew{?end}",
+			Type:      "application/vnd.readium.license.status.v1.0+json",
+			Templated: true,
+		},
+	}
+}
+
 // convertToOpdsPublication converts a stored Publication to an OPDS Publication
 func convertToOpdsPublication(storPublication *stor.Publication) (Publication, error) {
 	if storPublication == nil {
@@ -97,12 +182,19 @@ func convertToOpdsPublication(storPublication *stor.Publication) (Publication, e
 
 	publication := Publication{
 		Metadata: Metadata{
-			Type:       "http://schema.org/Book",
-			Title:      storPublication.Title,
-			Author:     getAuthorNames(storPublication.Author),
-			Identifier: storPublication.UUID,
-			Language:   getLanguageCode(storPublication.Language),
-			Published:  storPublication.DatePublished,
+			Type:        "http://schema.org/Book",
+			Title:       storPublication.Title,
+			Author:      getAuthors(storPublication.Author),
+			Translator:  getContributors(storPublication.Contributors, stor.RoleTranslator),
+			Editor:      getContributors(storPublication.Contributors, stor.RoleEditor),
+			Illustrator: getContributors(storPublication.Contributors, stor.RoleIllustrator),
+			Narrator:    getContributors(storPublication.Contributors, stor.RoleNarrator),
+			Publisher:   getPublishers(storPublication.Publisher),
+			Identifier:  storPublication.UUID,
+			Language:    getLanguageCodes(storPublication.Language),
+			Subject:     getSubjects(storPublication.Category),
+			Description: storPublication.Description,
+			Published:   storPublication.DatePublished,
 		},
 		Links: []Link{
 			{
@@ -111,84 +203,568 @@ func convertToOpdsPublication(storPublication *stor.Publication) (Publication, e
 				Type: "application/opds+json",
 			},
 		},
-		Images: getImages(storPublication.CoverUrl),
+		Images: getImages(publicBaseUrl, storPublication),
+	}
+	if !storPublication.UpdatedAt.IsZero() {
+		modified := storPublication.UpdatedAt.UTC()
+		publication.Metadata.Modified = &modified
 	}
 
 	return publication, nil
 }
 
-// getAuthorNames creates a comma separated string out of an array of authors
-func getAuthorNames(authors []stor.Author) string {
-	var names []string
+// facetLink returns a link to the sub-feed of a facet value
+func facetLink(facet, value string) []Link {
+	return []Link{
+		{
+			Href: publicBaseUrl + facetPaths[facet] + url.PathEscape(value),
+			Type: "application/opds+json",
+		},
+	}
+}
+
+// getAuthors creates an array of contributors out of an array of authors
+func getAuthors(authors []stor.Author) []Contributor {
+	var contributors []Contributor
 	for _, author := range authors {
-		names = append(names, author.Name)
+		contributors = append(contributors, Contributor{Name: author.Name, Links: facetLink("author", author.Name)})
+	}
+	return contributors
+}
+
+// getContributors creates an array of contributors out of the contributors of a publication which have a given role
+func getContributors(contributors []stor.Contributor, role string) []Contributor {
+	var selected []Contributor
+	for _, contributor := range contributors {
+		if contributor.Role == role {
+			selected = append(selected, Contributor{Name: contributor.Name})
+		}
+	}
+	return selected
+}
+
+// getPublishers creates an array of contributors out of an array of publishers
+func getPublishers(publishers []stor.Publisher) []Contributor {
+	var contributors []Contributor
+	for _, publisher := range publishers {
+		contributors = append(contributors, Contributor{Name: publisher.Name, Links: facetLink("publisher", publisher.Name)})
 	}
-	return strings.Join(names, ", ")
+	return contributors
 }
 
-// getLanguageCode returns the first language
-// TODO: an OPDS Publication supports x languages as an array -> update the model and mapping
-func getLanguageCode(languages []stor.Language) string {
+// getLanguageCodes creates an array of language codes out of an array of languages
+func getLanguageCodes(languages []stor.Language) []string {
 	var codes []string
 	for _, language := range languages {
 		codes = append(codes, language.Code)
 	}
-	if len(codes) > 0 {
-		return codes[0]
+	return codes
+}
+
+// getSubjects creates an array of subjects out of an array of categories
+func getSubjects(categories []stor.Category) []Subject {
+	var subjects []Subject
+	for _, category := range categories {
+		subjects = append(subjects, Subject{Name: category.Name, Links: facetLink("category", category.Name)})
 	}
-	return ""
+	return subjects
 }
 
-// getImages returns an Image object out of cover info
-func getImages(coverURL string) []Image {
+// getImages returns the sizes of the cover stored by pubstore, largest first,
+// or an Image object out of the cover url if the cover is external.
+// The media type is inferred from the extension of the cover url.
+func getImages(publicBaseUrl string, storPublication *stor.Publication) []Image {
+	if len(storPublication.Covers) > 0 {
+		images := make([]Image, 0, len(storPublication.Covers))
+		for _, size := range []string{stor.CoverSizeOriginal, stor.CoverSizeMedium, stor.CoverSizeThumbnail} {
+			for _, c := range storPublication.Covers {
+				if c.Size == size {
+					images = append(images, Image{
+						Href:   cover.Href(publicBaseUrl, c.Path),
+						Type:   c.Type,
+						Width:  c.Width,
+						Height: c.Height,
+					})
+				}
+			}
+		}
+		return images
+	}
+
+	coverURL := storPublication.CoverUrl
 	if coverURL == "" {
 		return nil
 	}
 
-	images := []Image{
+	image := Image{
+		Href: coverURL,
+	}
+	if u, err := url.Parse(coverURL); err == nil {
+		image.Type = mime.TypeByExtension(strings.ToLower(path.Ext(u.Path)))
+	}
+	return []Image{image}
+}
+
+// findPublications returns a page of publications, optionally filtered by a facet, and the total number of publications.
+// facet is "author" || "publisher" || "language" || "category" || "" (no filter).
+func (opds *Opds) findPublications(facet, value string, page, pageSize int) ([]stor.Publication, int64, error) {
+
+	switch facet {
+	case "author":
+		return opds.Store.FindPublicationsByAuthor(value, page, pageSize)
+	case "publisher":
+		return opds.Store.FindPublicationsByPublisher(value, page, pageSize)
+	case "language":
+		return opds.Store.FindPublicationsByLanguage(value, page, pageSize)
+	case "category":
+		return opds.Store.FindPublicationsByCategory(value, page, pageSize)
+	default:
+		publications, err := opds.Store.ListPublications(page, pageSize)
+		if err != nil {
+			return publications, 0, err
+		}
+		count, err := opds.Store.CountPublications()
+		return publications, count, err
+	}
+}
+
+// lastPage returns the number of the last page of a paginated feed
+func lastPage(count int64, pageSize int) int {
+	last := int((count + int64(pageSize) - 1) / int64(pageSize))
+	if last == 0 {
+		last = 1
+	}
+	return last
+}
+
+// feedPage is the position of a page of a paginated feed, identified by its number or by a cursor
+type feedPage struct {
+	number int    // page number, 0 if the page starts at a cursor
+	size   int    // page size
+	last   int    // number of the last page, 0 if unknown
+	cursor string // cursor at which the page starts, empty for the first page of a walk
+	next   string // cursor of the next page of a walk, empty on its last page
+}
+
+// feedHref returns the url of a page of a feed
+func feedHref(path string, query url.Values, page, pageSize int) string {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("page", strconv.Itoa(page))
+	q.Set("pageSize", strconv.Itoa(pageSize))
+	return publicBaseUrl + path + "?" + q.Encode()
+}
+
+// cursorHref returns the url of a page of a feed which starts at a cursor
+func cursorHref(path string, query url.Values, cursor string, pageSize int) string {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("cursor", cursor)
+	q.Set("pageSize", strconv.Itoa(pageSize))
+	return publicBaseUrl + path + "?" + q.Encode()
+}
+
+// paginationLinks returns the self, first, previous, next and last links of a page of a feed.
+// A page which starts at a cursor only links to the first and next pages of its walk, so that a client
+// following next links does not skip or repeat items when items are added or removed meanwhile.
+func paginationLinks(path string, query url.Values, page feedPage) []Link {
+
+	if page.number == 0 {
+		links := []Link{
+			{
+				Rel:  "self",
+				Href: cursorHref(path, query, page.cursor, page.size),
+				Type: "application/opds+json",
+			},
+			{
+				Rel:  "first",
+				Href: cursorHref(path, query, "", page.size),
+				Type: "application/opds+json",
+			},
+		}
+		if page.next != "" {
+			links = append(links, Link{
+				Rel:  "next",
+				Href: cursorHref(path, query, page.next, page.size),
+				Type: "application/opds+json",
+			})
+		}
+		return links
+	}
+
+	links := []Link{
+		{
+			Rel:  "self",
+			Href: feedHref(path, query, page.number, page.size),
+			Type: "application/opds+json",
+		},
 		{
-			Href: coverURL,
-			Type: "image/jpeg",
+			Rel:  "first",
+			Href: feedHref(path, query, 1, page.size),
+			Type: "application/opds+json",
+		},
+	}
+	if page.number > 1 {
+		links = append(links, Link{
+			Rel:  "previous",
+			Href: feedHref(path, query, page.number-1, page.size),
+			Type: "application/opds+json",
+		})
+	}
+	if page.number < page.last {
+		links = append(links, Link{
+			Rel:  "next",
+			Href: feedHref(path, query, page.number+1, page.size),
+			Type: "application/opds+json",
+		})
+	}
+	if page.last > 0 {
+		links = append(links, Link{
+			Rel:  "last",
+			Href: feedHref(path, query, page.last, page.size),
+			Type: "application/opds+json",
+		})
+	}
+	return links
+}
+
+// facetGroup creates a group of facet links, one per value
+func facetGroup(title, facet, currentFacet, currentValue string, values []string) Facet {
+
+	group := Facet{
+		Metadata: MetadataFeed{
+			Title: title,
 		},
+		Links: make([]Link, len(values)),
 	}
-	return images
+	for i, value := range values {
+		group.Links[i] = Link{
+			Href:  publicBaseUrl + "/opds/catalog?" + url.Values{facet: {value}}.Encode(),
+			Type:  "application/opds+json",
+			Title: value,
+		}
+		if facet == currentFacet && value == currentValue {
+			group.Links[i].Rel = "self"
+		}
+	}
+	return group
 }
 
-// GenerateOpdsFeed create an OPDS feed from all existing publications
-func (opds *Opds) GenerateOpdsFeed(page, pageSize int) (Root, error) {
+// catalogFacets creates the facet groups of the catalog feed
+func (opds *Opds) catalogFacets(currentFacet, currentValue string) []Facet {
+
+	var facets []Facet
 
-	publications, err := opds.Store.ListPublications(page, pageSize)
+	if authors, err := opds.Store.GetAuthors(); err == nil && len(authors) > 0 {
+		values := make([]string, len(authors))
+		for i, author := range authors {
+			values[i] = author.Name
+		}
+		facets = append(facets, facetGroup("Author", "author", currentFacet, currentValue, values))
+	}
+	if publishers, err := opds.Store.GetPublishers(); err == nil && len(publishers) > 0 {
+		values := make([]string, len(publishers))
+		for i, publisher := range publishers {
+			values[i] = publisher.Name
+		}
+		facets = append(facets, facetGroup("Publisher", "publisher", currentFacet, currentValue, values))
+	}
+	if languages, err := opds.Store.GetLanguages(); err == nil && len(languages) > 0 {
+		values := make([]string, len(languages))
+		for i, language := range languages {
+			values[i] = language.Code
+		}
+		facets = append(facets, facetGroup("Language", "language", currentFacet, currentValue, values))
+	}
+	if categories, err := opds.Store.GetCategories(); err == nil && len(categories) > 0 {
+		values := make([]string, len(categories))
+		for i, category := range categories {
+			values[i] = category.Name
+		}
+		facets = append(facets, facetGroup("Category", "category", currentFacet, currentValue, values))
+	}
+	return facets
+}
+
+// searchLinks returns the links to the search template and OpenSearch description
+func searchLinks() []Link {
+	return []Link{
+		{
+			Rel:       "search",
+			Href:      publicBaseUrl + "/opds/search{?query,author,title,language,publisher}",
+			Type:      "application/opds+json",
+			Templated: true,
+		},
+		{
+			Rel:  "search",
+			Href: publicBaseUrl + "/opds/opensearch.xml",
+			Type: "application/opensearchdescription+xml",
+		},
+	}
+}
+
+// publicationFeed creates a paginated OPDS feed from a page of publications
+func publicationFeed(title, path string, query url.Values, publications []stor.Publication, page feedPage) Root {
+
+	root := Root{
+		Metadata: MetadataFeed{
+			Title:        title,
+			ItemsPerPage: page.size,
+			CurrentPage:  page.number,
+		},
+		Links:        paginationLinks(path, query, page),
+		Publications: make([]Publication, len(publications)),
+	}
+	root.Links = append(root.Links, Link{
+		Rel:  "start",
+		Href: publicBaseUrl + "/opds",
+		Type: "application/opds+json",
+	})
+	root.Links = append(root.Links, searchLinks()...)
+	root.Links = append(root.Links, Link{
+		Rel:  "http://opds-spec.org/shelf",
+		Href: publicBaseUrl + "/opds/bookshelf",
+		Type: "application/opds+json",
+	})
+
+	var err error
+	for i, storPub := range publications {
+		root.Publications[i], err = convertToOpdsPublication(&storPub)
+		if err != nil {
+			fmt.Println(err)
+		}
+	}
+	return root
+}
+
+// findPage returns a page of publications, optionally filtered by a facet, its position and the total number of publications.
+// The page starts at a cursor if cursor is not nil, an empty cursor starting a walk; otherwise at a page number.
+// Feeds are walked with cursors by default; pages are only numbered when requested.
+// Cursor walks are sorted by last update in ascending order, so that publications updated meanwhile are not skipped.
+// facet is "author" || "publisher" || "language" || "category" || "" (no filter).
+func (opds *Opds) findPage(facet, value string, cursor *string, page, pageSize int) ([]stor.Publication, feedPage, int64, error) {
+
+	if cursor != nil {
+		query := &stor.PublicationQuery{}
+		switch facet {
+		case "author":
+			query.Author = value
+		case "publisher":
+			query.Publisher = value
+		case "language":
+			query.Language = value
+		case "category":
+			query.Category = value
+		}
+		// the total count is not needed to follow cursors
+		publications, next, err := opds.Store.FindPublicationsByCursor(query, *cursor, pageSize)
+		return publications, feedPage{size: pageSize, cursor: *cursor, next: next}, 0, err
+	}
+
+	publications, count, err := opds.findPublications(facet, value, page, pageSize)
+	return publications, feedPage{number: page, size: pageSize, last: lastPage(count, pageSize)}, count, err
+}
+
+// GenerateOpdsFeed create a paginated OPDS feed from existing publications, optionally filtered by a facet.
+// The feed starts at a cursor if cursor is not nil, otherwise at a page number.
+func (opds *Opds) GenerateOpdsFeed(facet, value string, cursor *string, page, pageSize int) (Root, error) {
+
+	publications, position, count, err := opds.findPage(facet, value, cursor, page, pageSize)
 	if err != nil {
-		return Root{}, errors.New("Error fetching publications:" + err.Error())
+		return Root{}, fmt.Errorf("Error fetching publications:%w", err)
+	}
+
+	query := url.Values{}
+	if facet != "" {
+		query.Set(facet, value)
+	}
+
+	root := publicationFeed("Pubstore OPDS Feed", "/opds/catalog", query, publications, position)
+	root.Metadata.NumberOfItems = count
+	root.Facets = opds.catalogFacets(facet, value)
+
+	return root, nil
+}
+
+// searchParams are the parameters of an OPDS search
+var searchParams = []string{"query", "title", "author", "publisher", "language"}
+
+// GenerateSearchFeed creates an OPDS feed from the publications matching every parameter of a search.
+// query is a full-text search in titles, authors, subjects and descriptions, title is a part of the title,
+// author, publisher and language (a code) are exact values.
+// The feed starts at a cursor if cursor is not nil, results being sorted by last update;
+// otherwise at a page number, results being sorted by relevance (or by last update without query).
+func (opds *Opds) GenerateSearchFeed(params url.Values, cursor *string, page, pageSize int) (Root, error) {
+
+	query := &stor.PublicationQuery{
+		Text:      params.Get("query"),
+		Title:     params.Get("title"),
+		Author:    params.Get("author"),
+		Publisher: params.Get("publisher"),
+		Language:  params.Get("language"),
 	}
+	if cursor != nil {
+		publications, next, err := opds.Store.FindPublicationsByCursor(query, *cursor, pageSize)
+		if err != nil {
+			return Root{}, fmt.Errorf("Error searching publications:%w", err)
+		}
+		return publicationFeed("Search results", "/opds/search", params, publications, feedPage{size: pageSize, cursor: *cursor, next: next}), nil
+	}
+
+	result, err := opds.Store.QueryPublications(query, page, pageSize)
+	if err != nil {
+		return Root{}, errors.New("Error searching publications:" + err.Error())
+	}
+
+	root := publicationFeed("Search results", "/opds/search", params, result.Publications, feedPage{number: page, size: pageSize, last: lastPage(result.Total, pageSize)})
+	root.Metadata.NumberOfItems = result.Total
+	return root, nil
+}
+
+// facetPaths maps a facet to the path of its sub-feeds
+var facetPaths = map[string]string{
+	"author":    "/opds/author/",
+	"publisher": "/opds/publisher/",
+	"language":  "/opds/language/",
+	"category":  "/opds/category/",
+}
+
+// GenerateFacetFeed creates a paginated OPDS feed from the publications of an author, publisher, language or category.
+// The feed starts at a cursor if cursor is not nil, otherwise at a page number.
+func (opds *Opds) GenerateFacetFeed(facet, value string, cursor *string, page, pageSize int) (Root, error) {
+
+	path, ok := facetPaths[facet]
+	if !ok {
+		return Root{}, errors.New("invalid facet " + facet)
+	}
+	publications, position, count, err := opds.findPage(facet, value, cursor, page, pageSize)
+	if err != nil {
+		return Root{}, fmt.Errorf("Error fetching publications:%w", err)
+	}
+
+	root := publicationFeed(value, path+url.PathEscape(value), url.Values{}, publications, position)
+	root.Metadata.NumberOfItems = count
+	return root, nil
+}
+
+// navigationLinks creates navigation links to the sub-feeds of a facet, one per value
+func navigationLinks(facet string, values []string) []Link {
+
+	links := make([]Link, len(values))
+	for i, value := range values {
+		links[i] = Link{
+			Href:  publicBaseUrl + facetPaths[facet] + url.PathEscape(value),
+			Type:  "application/opds+json",
+			Title: value,
+		}
+	}
+	return links
+}
+
+// newArrivalsCount is the number of publications displayed in the new arrivals group of the root feed
+const newArrivalsCount = 10
+
+// GenerateRootFeed creates the OPDS navigation feed used as an entry point to the catalog
+func (opds *Opds) GenerateRootFeed() (Root, error) {
 
 	root := Root{
 		Metadata: MetadataFeed{
-			Title: "Pubstore OPDS Feed",
+			Title: "Pubstore",
 		},
 		Links: []Link{
 			{
 				Rel:  "self",
-				Href: publicBaseUrl + "/opds/catalog",
+				Href: publicBaseUrl + "/opds",
 				Type: "application/opds+json",
 			},
 			{
-				Rel:  "http://opds-spec.org/shelf",
-				Href: publicBaseUrl + "/opds/bookshelf",
+				Rel:  "start",
+				Href: publicBaseUrl + "/opds",
 				Type: "application/opds+json",
 			},
 		},
-		Publications: make([]Publication, len(publications)),
+		Navigation: []Link{
+			{
+				Rel:   "http://opds-spec.org/sort/new",
+				Href:  publicBaseUrl + "/opds/catalog",
+				Type:  "application/opds+json",
+				Title: "All publications",
+			},
+			{
+				Rel:   "http://opds-spec.org/shelf",
+				Href:  publicBaseUrl + "/opds/bookshelf",
+				Type:  "application/opds+json",
+				Title: "Bookshelf",
+			},
+		},
 	}
+	root.Links = append(root.Links, searchLinks()...)
 
-	for i, storPub := range publications {
-		root.Publications[i], err = convertToOpdsPublication(&storPub)
-		if err != nil {
-			fmt.Println(err)
+	// the most recently updated publications come first
+	publications, err := opds.Store.ListPublications(1, newArrivalsCount)
+	if err != nil {
+		return Root{}, errors.New("Error fetching publications:" + err.Error())
+	}
+	if len(publications) > 0 {
+		group := Group{
+			Metadata: MetadataFeed{
+				Title: "New arrivals",
+			},
+			Links: []Link{
+				{
+					Rel:  "self",
+					Href: publicBaseUrl + "/opds/catalog",
+					Type: "application/opds+json",
+				},
+			},
+			Publications: make([]Publication, len(publications)),
+		}
+		for i, storPub := range publications {
+			group.Publications[i], err = convertToOpdsPublication(&storPub)
+			if err != nil {
+				fmt.Println(err)
+			}
 		}
+		root.Groups = append(root.Groups, group)
 	}
 
-	fmt.Println(root.Links)
+	categories, err := opds.Store.GetCategories()
+	if err != nil {
+		return Root{}, errors.New("Error fetching categories:" + err.Error())
+	}
+	if len(categories) > 0 {
+		values := make([]string, len(categories))
+		for i, category := range categories {
+			values[i] = category.Name
+		}
+		root.Groups = append(root.Groups, Group{
+			Metadata: MetadataFeed{
+				Title: "By category",
+			},
+			Navigation: navigationLinks("category", values),
+		})
+	}
+
+	languages, err := opds.Store.GetLanguages()
+	if err != nil {
+		return Root{}, errors.New("Error fetching languages:" + err.Error())
+	}
+	if len(languages) > 0 {
+		values := make([]string, len(languages))
+		for i, language := range languages {
+			values[i] = language.Code
+		}
+		root.Groups = append(root.Groups, Group{
+			Metadata: MetadataFeed{
+				Title: "By language",
+			},
+			Navigation: navigationLinks("language", values),
+		})
+	}
 
 	return root, nil
 }
@@ -213,7 +789,7 @@ func (opds *Opds) getTransactionFromUserAndPubUUID(user *stor.User, pubUUID stri
 }
 
 // GenerateBookshelfFeed create a personal OPDS feed
-func (opds *Opds) GenerateBookshelfFeed(credential string) (Root, error) {
+func (opds *Opds) GenerateBookshelfFeed(ctx context.Context, credential string) (Root, error) {
 
 	user, err := opds.Store.GetUserByEmail(credential)
 	if err != nil {
@@ -224,13 +800,16 @@ func (opds *Opds) GenerateBookshelfFeed(credential string) (Root, error) {
 		return Root{}, err
 	}
 
+	// status documents are fetched concurrently; a missing status is the recorded one
+	lsdStatus, err := opds.LCP.GetStatusDocuments(ctx, *transactions)
+	if err != nil {
+		fmt.Println("Bookshelf : " + err.Error())
+	}
+
 	var publicationOpdsView []Publication = make([]Publication, len(*transactions))
-	var lsdStatus []*lcp.LsdStatus = make([]*lcp.LsdStatus, len(*transactions))
 	for i, transaction := range *transactions {
-		// TODO: try to find a better solution than a loop on http requests
-		lsdStatus[i], err = lcp.GetStatusDocument(opds.Config.LCPServer, &transaction)
-		if err != nil {
-			lsdStatus[i] = &lcp.LsdStatus{}
+		if err = opds.Store.UpdateTransactionStatus(&(*transactions)[i], lsdStatus[i].StatusCode); err != nil {
+			fmt.Println("Bookshelf : " + err.Error())
 		}
 		publicationOpdsView[i], err = convertToOpdsPublication(&transaction.Publication)
 		if err != nil {
@@ -258,7 +837,12 @@ func (opds *Opds) GenerateBookshelfFeed(credential string) (Root, error) {
 	}
 
 	for i, status := range lsdStatus {
-		root.Publications[i].Links = append(root.Publications[i].Links, publicationAcquisitionLinkChoice("authentified", root.Publications[i].Metadata.Identifier, status.StatusCode, user.HPassphrase, status.StartDate, status.EndDate))
+		link := publicationAcquisitionLinkChoice("authentifiedAndBorrowed", root.Publications[i].Metadata.Identifier, status.StatusCode, user.HPassphrase, status.StartDate, status.EndDate)
+		link.Properties.TransactionType = (*transactions)[i].Type
+		root.Publications[i].Links = append(root.Publications[i].Links, link)
+		if (*transactions)[i].Type == stor.TransactionTypeLoan && (status.StatusCode == lcp.StatusReady || status.StatusCode == lcp.StatusActive) {
+			root.Publications[i].Links = append(root.Publications[i].Links, loanInteractionLinks(root.Publications[i].Metadata.Identifier)...)
+		}
 	}
 
 	return root, nil
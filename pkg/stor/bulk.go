@@ -0,0 +1,141 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BatchSize is the number of records saved in a database transaction by bulk imports,
+// and read at once by bulk exports
+const BatchSize = 500
+
+// errDryRun rolls back the transaction of a dry run
+var errDryRun = errors.New("dry run")
+
+// ImportPublications creates or updates a batch of publications, identified by their UUID, in a single transaction.
+// A publication without UUID is created with a new UUID.
+// Each publication is saved in a savepoint: the returned slice holds the error of each publication, nil if it was saved,
+// and an invalid publication does not prevent the others from being saved.
+// If dryRun is true, the publications are checked against the database but the transaction is rolled back.
+func (s *Store) ImportPublications(publications []*Publication, dryRun bool) ([]error, error) {
+
+	return s.importBatch(len(publications), dryRun, func(tx *gorm.DB, i int) error {
+		publication := publications[i]
+		if err := publication.Validate(); err != nil {
+			return err
+		}
+		if publication.UUID == "" {
+			publication.UUID = uuid.New().String()
+		}
+
+		var existing Publication
+		err := tx.Where("uuid = ?", publication.UUID).First(&existing).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			err = tx.Create(publication).Error
+		case err == nil:
+			publication.ID = existing.ID
+			publication.CreatedAt = existing.CreatedAt
+			err = replacePublication(tx, publication)
+		}
+		if err != nil {
+			return err
+		}
+		return s.indexPublication(tx, publication.ID)
+	})
+}
+
+// ImportUsers creates or updates a batch of users, identified by their UUID, in a single transaction.
+// A user may be imported with a password and passphrase, or with their hashes.
+// When a user is updated without password or passphrase, the current ones are kept.
+// Errors and dry runs are handled as for ImportPublications.
+func (s *Store) ImportUsers(users []*User, dryRun bool) ([]error, error) {
+
+	return s.importBatch(len(users), dryRun, func(tx *gorm.DB, i int) error {
+		user := users[i]
+		if err := user.Validate(); err != nil {
+			return err
+		}
+		if user.Email == "" {
+			return errors.New("missing user email")
+		}
+
+		var existing User
+		if user.UUID != "" {
+			err := tx.Where("uuid = ?", user.UUID).First(&existing).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+
+		// the email is the login of the user
+		var count int64
+		if err := tx.Model(&User{}).Where("email = ? AND uuid <> ?", user.Email, user.UUID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("the email %s is already used by another user", user.Email)
+		}
+
+		if existing.ID == 0 {
+			return tx.Create(user).Error
+		}
+		user.ID = existing.ID
+		user.CreatedAt = existing.CreatedAt
+		user.SessionId = existing.SessionId
+		if user.Password == "" && user.HPassword == "" {
+			user.HPassword = existing.HPassword
+		}
+		if user.Passphrase == "" && user.HPassphrase == "" {
+			user.HPassphrase = existing.HPassphrase
+		}
+		return tx.Save(user).Error
+	})
+}
+
+// importBatch saves n records in a transaction, each in a savepoint
+func (s *Store) importBatch(n int, dryRun bool, save func(tx *gorm.DB, i int) error) ([]error, error) {
+
+	errs := make([]error, n)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		for i := 0; i < n; i++ {
+			if err := tx.SavePoint("record").Error; err != nil {
+				return err
+			}
+			if errs[i] = save(tx, i); errs[i] != nil {
+				if err := tx.RollbackTo("record").Error; err != nil {
+					return err
+				}
+			}
+		}
+		if dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		err = nil
+	}
+	return errs, err
+}
+
+// ListPublicationsAfter returns publications in creation order, starting after a cursor which is the ID of a publication.
+// Unlike pages, the cursor is stable when publications are added or deleted.
+func (s *Store) ListPublicationsAfter(cursor uint, limit int) ([]Publication, error) {
+	var publications []Publication
+	return publications, s.preloadPublication().Where("id > ?", cursor).Order(clause.OrderByColumn{Column: clause.Column{Name: "id"}}).Limit(limit).Find(&publications).Error
+}
+
+// ListUsersAfter returns users in creation order, starting after a cursor which is the ID of a user
+func (s *Store) ListUsersAfter(cursor uint, limit int) ([]User, error) {
+	var users []User
+	return users, s.db.Where("id > ?", cursor).Order("id ASC").Limit(limit).Find(&users).Error
+}
@@ -0,0 +1,93 @@
+package stor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportPublications(t *testing.T) {
+
+	id := uuid.New().String()
+	publications := []*Publication{
+		{UUID: id, Title: "Bulk publication", ContentType: "application/epub+zip"},
+		{UUID: "not-a-uuid", Title: "Invalid publication"},
+		{Title: "Bulk publication without uuid", ContentType: "application/pdf+lcp"},
+	}
+
+	// a dry run saves nothing
+	errs, err := store.ImportPublications(publications, true)
+	assert.NoError(t, err)
+	if assert.Len(t, errs, 3) {
+		assert.NoError(t, errs[0])
+		assert.Error(t, errs[1])
+		assert.NoError(t, errs[2])
+	}
+	_, err = store.GetPublication(id)
+	assert.Error(t, err)
+
+	// valid publications are saved despite the invalid one
+	publications[2].UUID = ""
+	errs, err = store.ImportPublications(publications, false)
+	assert.NoError(t, err)
+	assert.Error(t, errs[1])
+	p, err := store.GetPublication(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Bulk publication", p.Title)
+	created, err := store.GetPublication(publications[2].UUID)
+	assert.NoError(t, err)
+
+	// a publication is updated by uuid
+	errs, err = store.ImportPublications([]*Publication{{UUID: id, Title: "Updated bulk publication", ContentType: "application/epub+zip"}}, false)
+	assert.NoError(t, err)
+	assert.NoError(t, errs[0])
+	p, err = store.GetPublication(id)
+	assert.NoError(t, err)
+	assert.Equal(t, "Updated bulk publication", p.Title)
+
+	// publications are listed after a cursor
+	list, err := store.ListPublicationsAfter(p.ID, 10)
+	assert.NoError(t, err)
+	if assert.NotEmpty(t, list) {
+		assert.Equal(t, created.UUID, list[0].UUID)
+	}
+
+	err = store.DeletePublication(p)
+	assert.NoError(t, err)
+	err = store.DeletePublication(created)
+	assert.NoError(t, err)
+}
+
+func TestImportUsers(t *testing.T) {
+
+	email := uuid.New().String() + "@example.com"
+	users := []*User{
+		{Name: "Bulk user", Email: email, Password: "password", Passphrase: "passphrase"},
+		{Name: "Duplicate user", Email: email, Password: "password", Passphrase: "passphrase"},
+		{Name: "User without email", Password: "password", Passphrase: "passphrase"},
+	}
+	errs, err := store.ImportUsers(users, false)
+	assert.NoError(t, err)
+	if assert.Len(t, errs, 3) {
+		assert.NoError(t, errs[0])
+		assert.Error(t, errs[1])
+		assert.Error(t, errs[2])
+	}
+
+	u, err := store.GetUserByEmail(email)
+	assert.NoError(t, err)
+	hpassword := u.HPassword
+
+	// a user is updated by uuid, keeping their password
+	errs, err = store.ImportUsers([]*User{{UUID: u.UUID, Name: "Updated bulk user", Email: email}}, false)
+	assert.NoError(t, err)
+	assert.NoError(t, errs[0])
+	u, err = store.GetUser(u.UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Updated bulk user", u.Name)
+	assert.Equal(t, hpassword, u.HPassword)
+
+	err = store.DeleteUser(u)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,41 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"gorm.io/gorm"
+)
+
+// Roles of the contributors of a publication, named after the Readium Web Publication Manifest
+const (
+	RoleTranslator  = "translator"
+	RoleEditor      = "editor"
+	RoleIllustrator = "illustrator"
+	RoleNarrator    = "narrator"
+)
+
+// Contributor is a contributor to a publication other than its authors, e.g. its translator or narrator.
+// Contributors are listed in sequence order.
+type Contributor struct {
+	gorm.Model
+	PublicationID uint   `json:"-" gorm:"index"` // implicit foreign key to the related publication
+	Name          string `json:"name"`
+	Role          string `json:"role" validate:"oneof=translator editor illustrator narrator"`
+}
+
+// replaceContributors replaces the contributors of a publication
+func replaceContributors(tx *gorm.DB, publication *Publication) error {
+	if err := tx.Unscoped().Where("publication_id = ?", publication.ID).Delete(&Contributor{}).Error; err != nil {
+		return err
+	}
+	for i := range publication.Contributors {
+		publication.Contributors[i].ID = 0
+		publication.Contributors[i].PublicationID = publication.ID
+	}
+	if len(publication.Contributors) == 0 {
+		return nil
+	}
+	return tx.Create(&publication.Contributors).Error
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"gorm.io/gorm"
+)
+
+// Sizes of a cover image
+const (
+	CoverSizeOriginal  = "original"
+	CoverSizeMedium    = "medium"
+	CoverSizeThumbnail = "thumbnail"
+)
+
+// CoverImage is a size of the cover of a publication, stored in the resources directory.
+// Width and Height are 0 if the image format could not be decoded.
+type CoverImage struct {
+	gorm.Model
+	PublicationID uint   `json:"-" gorm:"index"` // implicit foreign key to the related publication
+	Size          string `json:"size"`
+	Path          string `json:"path"` // relative to the resources directory
+	Type          string `json:"type"`
+	Width         int    `json:"width"`
+	Height        int    `json:"height"`
+}
+
+// SetCovers replaces the cover images of a publication and sets its cover url
+func (s *Store) SetCovers(publication *Publication, coverUrl string, covers []CoverImage) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("publication_id = ?", publication.ID).Delete(&CoverImage{}).Error; err != nil {
+			return err
+		}
+		for i := range covers {
+			covers[i].PublicationID = publication.ID
+		}
+		if len(covers) > 0 {
+			if err := tx.Create(&covers).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(&Publication{}).Where("id = ?", publication.ID).Update("cover_url", coverUrl).Error
+	})
+	if err != nil {
+		return err
+	}
+	publication.CoverUrl = coverUrl
+	publication.Covers = covers
+	return nil
+}
@@ -0,0 +1,50 @@
+package stor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetCovers(t *testing.T) {
+
+	publication := &Publication{
+		UUID:        uuid.New().String(),
+		Title:       "Publication with covers",
+		ContentType: "application/epub+zip",
+	}
+	err := store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	covers := []CoverImage{
+		{Size: CoverSizeOriginal, Path: publication.UUID + "/original-1.png", Type: "image/png", Width: 400, Height: 800},
+		{Size: CoverSizeMedium, Path: publication.UUID + "/medium-1.jpg", Type: "image/jpeg", Width: 300, Height: 600},
+		{Size: CoverSizeThumbnail, Path: publication.UUID + "/thumbnail-1.jpg", Type: "image/jpeg", Width: 120, Height: 240},
+	}
+	err = store.SetCovers(publication, "http://localhost/resources/"+covers[0].Path, covers)
+	assert.NoError(t, err)
+
+	p, err := store.GetPublication(publication.UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost/resources/"+covers[0].Path, p.CoverUrl)
+	assert.Len(t, p.Covers, 3)
+
+	// covers are replaced
+	err = store.SetCovers(publication, "http://localhost/resources/"+publication.UUID+"/original-2.svg",
+		[]CoverImage{{Size: CoverSizeOriginal, Path: publication.UUID + "/original-2.svg", Type: "image/svg+xml"}})
+	assert.NoError(t, err)
+
+	p, err = store.GetPublication(publication.UUID)
+	assert.NoError(t, err)
+	if assert.Len(t, p.Covers, 1) {
+		assert.Equal(t, "image/svg+xml", p.Covers[0].Type)
+	}
+
+	// covers are deleted with the publication
+	err = store.DeletePublication(p)
+	assert.NoError(t, err)
+	var count int64
+	store.db.Model(&CoverImage{}).Where("publication_id = ?", p.ID).Count(&count)
+	assert.Equal(t, int64(0), count)
+}
@@ -0,0 +1,91 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidCursor is returned for a cursor token which cannot be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PublicationCursor returns the opaque cursor token of the position of a publication, by last update then ID
+func PublicationCursor(p *Publication) string {
+	position := strconv.FormatInt(p.UpdatedAt.UnixNano(), 10) + ":" + strconv.FormatUint(uint64(p.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(position))
+}
+
+// decodeCursor returns the last update and ID of the position of a cursor token
+func decodeCursor(token string) (time.Time, uint, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	nanos, id, ok := strings.Cut(string(data), ":")
+	if !ok {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	i, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return time.Time{}, 0, ErrInvalidCursor
+	}
+	// times are compared in the location they are stored in, which is local
+	return time.Unix(0, n), uint(i), nil
+}
+
+// FindPublicationsByCursor returns up to limit publications matching the filters of a query, sorted by last update
+// then ID, starting after the position of an opaque cursor token, or at the first publication if the token is empty.
+// It also returns the token of the next page, empty on the last page.
+// Unlike pages, cursors do not skip or repeat publications when publications are created or deleted while paging.
+// The order of the query applies, ascending by default: a publication updated while paging moves after the cursor
+// and is returned again at the end of the walk, while in descending order it would be skipped.
+func (s *Store) FindPublicationsByCursor(query *PublicationQuery, cursor string, limit int) ([]Publication, string, error) {
+	publications := []Publication{}
+	if limit < 1 {
+		return publications, "", errors.New("invalid pagination")
+	}
+	if (query.Sort != "" && query.Sort != SortUpdated) || (query.Order != "" && query.Order != OrderAsc && query.Order != OrderDesc) {
+		return publications, "", ErrInvalidSort
+	}
+	desc := query.Order == OrderDesc
+
+	match, found, err := s.textMatch(query)
+	if err != nil || !found {
+		return publications, "", err
+	}
+	tx := s.preloadPublication().Where("publications.id IN (?)", s.filterPublications(query, match).Select("publications.id"))
+	if cursor != "" {
+		updatedAt, id, err := decodeCursor(cursor)
+		if err != nil {
+			return publications, "", err
+		}
+		cmp := ">"
+		if desc {
+			cmp = "<"
+		}
+		tx = tx.Where("(publications.updated_at "+cmp+" ? OR (publications.updated_at = ? AND publications.id "+cmp+" ?))", updatedAt, updatedAt, id)
+	}
+
+	// one more publication tells if there is a next page
+	err = tx.Order(clause.OrderBy{Columns: []clause.OrderByColumn{
+		{Column: clause.Column{Table: "publications", Name: "updated_at"}, Desc: desc},
+		{Column: clause.Column{Table: "publications", Name: "id"}, Desc: desc},
+	}}).Limit(limit + 1).Find(&publications).Error
+	if err != nil || len(publications) <= limit {
+		return publications, "", err
+	}
+	publications = publications[:limit]
+	return publications, PublicationCursor(&publications[limit-1]), nil
+}
@@ -0,0 +1,79 @@
+package stor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindPublicationsByCursor(t *testing.T) {
+
+	var created []*Publication
+	for i := 0; i < 5; i++ {
+		publication := &Publication{
+			Title:       "Cursorland " + string(rune('A'+i)),
+			UUID:        uuid.New().String(),
+			ContentType: "application/epub+zip",
+		}
+		err := store.CreatePublication(publication)
+		assert.NoError(t, err)
+		created = append(created, publication)
+	}
+	query := &PublicationQuery{Title: "Cursorland"}
+
+	// least recently updated first
+	publications, next, err := store.FindPublicationsByCursor(query, "", 2)
+	assert.NoError(t, err)
+	if assert.Len(t, publications, 2) {
+		assert.Equal(t, created[0].UUID, publications[0].UUID)
+		assert.Equal(t, created[1].UUID, publications[1].UUID)
+	}
+	assert.NotEmpty(t, next)
+
+	// a publication updated while paging moves after the cursor and is still returned
+	created[0].Title = "Cursorland A, revised"
+	err = store.UpdatePublication(created[0])
+	assert.NoError(t, err)
+	// a new publication does not shift the next page
+	extra := &Publication{Title: "Cursorland F", UUID: uuid.New().String(), ContentType: "application/epub+zip"}
+	err = store.CreatePublication(extra)
+	assert.NoError(t, err)
+	created = append(created, extra)
+
+	uuids := []string{}
+	for next != "" {
+		publications, next, err = store.FindPublicationsByCursor(query, next, 2)
+		assert.NoError(t, err)
+		for _, p := range publications {
+			uuids = append(uuids, p.UUID)
+		}
+	}
+	assert.Equal(t, []string{created[2].UUID, created[3].UUID, created[4].UUID, created[0].UUID, created[5].UUID}, uuids)
+
+	// most recently updated first
+	query.Order = OrderDesc
+	publications, next, err = store.FindPublicationsByCursor(query, "", 4)
+	assert.NoError(t, err)
+	if assert.Len(t, publications, 4) {
+		assert.Equal(t, created[5].UUID, publications[0].UUID)
+		assert.Equal(t, created[0].UUID, publications[1].UUID)
+	}
+	publications, next, err = store.FindPublicationsByCursor(query, next, 4)
+	assert.NoError(t, err)
+	if assert.Len(t, publications, 2) {
+		assert.Equal(t, created[1].UUID, publications[1].UUID)
+	}
+	assert.Empty(t, next)
+
+	// invalid cursor or sort
+	_, _, err = store.FindPublicationsByCursor(query, "not a cursor", 2)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+	_, _, err = store.FindPublicationsByCursor(&PublicationQuery{Sort: SortTitle}, "", 2)
+	assert.ErrorIs(t, err, ErrInvalidSort)
+
+	for _, p := range created {
+		err = store.DeletePublication(p)
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Status of a hold
+const (
+	HoldStatusWaiting = "waiting"
+	HoldStatusReady   = "ready"
+)
+
+// ErrHoldExists is returned when a user places a second hold on the same publication
+var ErrHoldExists = errors.New("a hold is already placed on this publication")
+
+// Hold is a reservation placed by a user on a publication which has no copy available for loan.
+// Holds are served in the order of their creation: when a copy is back, the next waiting hold becomes ready
+// and the copy is kept for its user until ExpiresAt.
+type Hold struct {
+	gorm.Model
+	UserID        uint        `json:"-" gorm:"index"` // implicit foreign key to the related user
+	User          User        `json:"-"`
+	PublicationID uint        `json:"-" gorm:"index"` // implicit foreign key to the related publication
+	Publication   Publication `json:"-"`
+	Status        string      `json:"status" gorm:"index"`
+	ExpiresAt     *time.Time  `json:"expires_at,omitempty"`
+}
+
+// activeHolds selects waiting holds and ready holds which have not expired
+func (s *Store) activeHolds() *gorm.DB {
+	return s.db.Model(Hold{}).Where("status = ? OR (status = ? AND expires_at > ?)", HoldStatusWaiting, HoldStatusReady, time.Now().UTC())
+}
+
+// CreateHold places a waiting hold at the end of the queue of a publication
+func (s *Store) CreateHold(hold *Hold) error {
+	if _, err := s.GetHold(hold.UserID, hold.PublicationID); err == nil {
+		return ErrHoldExists
+	}
+	hold.Status = HoldStatusWaiting
+	hold.ExpiresAt = nil
+	return s.db.Create(hold).Error
+}
+
+// GetHold retrieves the active hold of a user on a publication
+func (s *Store) GetHold(userID, publicationID uint) (*Hold, error) {
+	var hold Hold
+	return &hold, s.activeHolds().Preload("Publication").Where("user_id = ? AND publication_id = ?", userID, publicationID).First(&hold).Error
+}
+
+// FindHoldsByUser retrieves the active holds of a user
+func (s *Store) FindHoldsByUser(userID uint) ([]Hold, error) {
+	var holds []Hold
+	return holds, s.activeHolds().Preload("Publication").Where("user_id = ?", userID).Order("id ASC").Find(&holds).Error
+}
+
+// CountHolds returns the count of active holds on a publication
+func (s *Store) CountHolds(publicationID uint) (int64, error) {
+	var count int64
+	return count, s.activeHolds().Where("publication_id = ?", publicationID).Count(&count).Error
+}
+
+// countReadyHolds returns the count of ready holds on a publication, i.e. the count of copies kept for their users
+func (s *Store) countReadyHolds(publicationID uint) (int64, error) {
+	var count int64
+	return count, s.db.Model(Hold{}).Where("publication_id = ? AND status = ? AND expires_at > ?", publicationID, HoldStatusReady, time.Now().UTC()).Count(&count).Error
+}
+
+// HoldPosition returns the position of a waiting hold in the queue of its publication, starting at 1.
+// It returns 0 if the hold is ready.
+func (s *Store) HoldPosition(hold *Hold) (int64, error) {
+	if hold.Status == HoldStatusReady {
+		return 0, nil
+	}
+	var position int64
+	return position, s.db.Model(Hold{}).Where("publication_id = ? AND status = ? AND id <= ?", hold.PublicationID, HoldStatusWaiting, hold.ID).Count(&position).Error
+}
+
+// DeleteHold deletes a hold, e.g. when it is cancelled by its user
+func (s *Store) DeleteHold(hold *Hold) error {
+	return s.db.Delete(hold).Error
+}
+
+// FulfillHold deletes the active hold of a user on a publication, if any, once the publication is lent to the user
+func (s *Store) FulfillHold(userID, publicationID uint) error {
+	return s.db.Where("user_id = ? AND publication_id = ?", userID, publicationID).Delete(&Hold{}).Error
+}
+
+// PromoteHolds deletes the expired ready holds of a publication, then moves the next waiting holds
+// to ready while copies are available. A ready hold keeps its copy during readyPeriod.
+// It returns the holds which became ready.
+func (s *Store) PromoteHolds(publicationID uint, readyPeriod time.Duration) ([]Hold, error) {
+	var promoted []Hold
+
+	now := time.Now().UTC()
+	err := s.db.Where("publication_id = ? AND status = ? AND expires_at <= ?", publicationID, HoldStatusReady, now).Delete(&Hold{}).Error
+	if err != nil {
+		return promoted, err
+	}
+
+	var publication Publication
+	if err = s.db.First(&publication, publicationID).Error; err != nil {
+		return promoted, err
+	}
+
+	for {
+		available, err := s.AvailableCopies(&publication)
+		if err != nil {
+			return promoted, err
+		}
+		if available == 0 {
+			return promoted, nil
+		}
+		var hold Hold
+		err = s.db.Where("publication_id = ? AND status = ?", publicationID, HoldStatusWaiting).Order("id ASC").First(&hold).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return promoted, nil
+		}
+		if err != nil {
+			return promoted, err
+		}
+		expiresAt := now.Add(readyPeriod).Truncate(time.Second)
+		hold.Status = HoldStatusReady
+		hold.ExpiresAt = &expiresAt
+		if err = s.db.Save(&hold).Error; err != nil {
+			return promoted, err
+		}
+		promoted = append(promoted, hold)
+	}
+}
+
+// ProcessHolds promotes the holds of every publication which has a hold.
+// It is called periodically, as a copy becomes available when a loan expires.
+func (s *Store) ProcessHolds(readyPeriod time.Duration) error {
+	var publicationIDs []uint
+	err := s.db.Model(Hold{}).Distinct("publication_id").Pluck("publication_id", &publicationIDs).Error
+	if err != nil {
+		return err
+	}
+	for _, publicationID := range publicationIDs {
+		if _, err = s.PromoteHolds(publicationID, readyPeriod); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoldQueue(t *testing.T) {
+
+	// three users
+	users := make([]*User, 3)
+	for i := range users {
+		users[i] = &User{
+			UUID:       gofakeit.UUID(),
+			Name:       gofakeit.Name(),
+			Email:      gofakeit.Email(),
+			Password:   "password",
+			TextHint:   "hint",
+			Passphrase: "passphrase",
+		}
+		err := store.CreateUser(users[i])
+		assert.NoError(t, err)
+	}
+
+	// a publication with a single copy, lent to the first user
+	publication := &Publication{
+		Title:  "Test Publication",
+		UUID:   gofakeit.UUID(),
+		Copies: 1,
+	}
+	err := store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	loan := &Transaction{UserID: users[0].ID, PublicationID: publication.ID, LicenceId: gofakeit.UUID(), Type: TransactionTypeLoan}
+	loan.SetPeriod(now, now.AddDate(0, 0, 30))
+	err = store.CreateTransaction(loan)
+	assert.NoError(t, err)
+
+	// the two other users place a hold
+	hold1 := &Hold{UserID: users[1].ID, PublicationID: publication.ID}
+	err = store.CreateHold(hold1)
+	assert.NoError(t, err)
+	hold2 := &Hold{UserID: users[2].ID, PublicationID: publication.ID}
+	err = store.CreateHold(hold2)
+	assert.NoError(t, err)
+
+	// a second hold by the same user is refused
+	err = store.CreateHold(&Hold{UserID: users[1].ID, PublicationID: publication.ID})
+	assert.ErrorIs(t, err, ErrHoldExists)
+
+	count, err := store.CountHolds(publication.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, int(count))
+	position, err := store.HoldPosition(hold2)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, int(position))
+
+	// nothing to promote while the copy is on loan
+	promoted, err := store.PromoteHolds(publication.ID, 72*time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(promoted))
+
+	// the loan is returned: the first hold becomes ready
+	loan.SetPeriod(now, now.Add(-time.Minute))
+	err = store.UpdateTransaction(loan)
+	assert.NoError(t, err)
+	promoted, err = store.PromoteHolds(publication.ID, 72*time.Hour)
+	assert.NoError(t, err)
+	if assert.Equal(t, 1, len(promoted)) {
+		assert.Equal(t, hold1.ID, promoted[0].ID)
+	}
+
+	readyHold, err := store.GetHold(users[1].ID, publication.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, HoldStatusReady, readyHold.Status)
+	assert.NotNil(t, readyHold.ExpiresAt)
+	position, err = store.HoldPosition(hold2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, int(position))
+
+	// the copy is kept for the user whose hold is ready
+	err = store.CheckCopyAvailable(publication, users[2].ID)
+	assert.ErrorIs(t, err, ErrNoCopyAvailable)
+	err = store.CheckCopyAvailable(publication, users[1].ID)
+	assert.NoError(t, err)
+
+	// the ready hold expires: the next hold becomes ready
+	expired := now.Add(-time.Minute)
+	readyHold.ExpiresAt = &expired
+	err = store.db.Save(readyHold).Error
+	assert.NoError(t, err)
+	err = store.ProcessHolds(72 * time.Hour)
+	assert.NoError(t, err)
+
+	_, err = store.GetHold(users[1].ID, publication.ID)
+	assert.Error(t, err)
+	readyHold, err = store.GetHold(users[2].ID, publication.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, HoldStatusReady, readyHold.Status)
+
+	// the hold is fulfilled by a loan
+	err = store.FulfillHold(users[2].ID, publication.ID)
+	assert.NoError(t, err)
+	holds, err := store.FindHoldsByUser(users[2].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(holds))
+
+	// clean up test data
+	err = store.DeleteTransaction(loan)
+	assert.NoError(t, err)
+	err = store.DeletePublication(publication)
+	assert.NoError(t, err)
+	for _, user := range users {
+		err = store.DeleteUser(user)
+		assert.NoError(t, err)
+	}
+}
@@ -14,18 +14,26 @@ import (
 
 // A Publication
 // DatePublished is a string: we do not process its value as a dateTime (or a simpler date, which is more complex to validate)
+// Copies is the number of copies which can be lent simultaneously; 0 means that the number of loans is unlimited.
+// Covers are the cover images stored by pubstore; they are managed with SetCovers.
+// Contributors are the translators, editors, illustrators and narrators of the publication.
+// ISBN is the ISBN-13 of the publication, if known; it identifies publications imported from ONIX feeds.
 type Publication struct {
 	gorm.Model
-	UUID          string      `json:"uuid" validate:"omitempty,uuid4_rfc4122" gorm:"uniqueIndex"`
-	Title         string      `json:"title" gorm:"index"`
-	ContentType   string      `json:"content_type" gorm:"index"`
-	DatePublished string      `json:"date_published"`
-	Description   string      `json:"description"`
-	CoverUrl      string      `json:"cover_url"`
-	Language      []Language  `json:"language" gorm:"many2many:publication_language;"`
-	Publisher     []Publisher `json:"publisher" gorm:"many2many:publication_publisher;"`
-	Author        []Author    `json:"author" gorm:"many2many:publication_author;"`
-	Category      []Category  `json:"category" gorm:"many2many:publication_category;"`
+	UUID          string        `json:"uuid" validate:"omitempty,uuid4_rfc4122" gorm:"uniqueIndex"`
+	ISBN          string        `json:"isbn,omitempty" validate:"omitempty,isbn13" gorm:"index"`
+	Title         string        `json:"title" gorm:"index"`
+	ContentType   string        `json:"content_type" gorm:"index"`
+	DatePublished string        `json:"date_published"`
+	Description   string        `json:"description"`
+	CoverUrl      string        `json:"cover_url"`
+	Copies        int           `json:"copies" validate:"gte=0"`
+	Language      []Language    `json:"language" gorm:"many2many:publication_language;"`
+	Publisher     []Publisher   `json:"publisher" gorm:"many2many:publication_publisher;"`
+	Author        []Author      `json:"author" gorm:"many2many:publication_author;"`
+	Category      []Category    `json:"category" gorm:"many2many:publication_category;"`
+	Contributors  []Contributor `json:"contributors,omitempty" validate:"dive"`
+	Covers        []CoverImage  `json:"-"`
 }
 
 // TODO : remove gorm.Model from these tables
@@ -96,29 +104,92 @@ func (p *Publication) Validate() error {
 
 // CreatePublication creates a new publication
 func (s *Store) CreatePublication(publication *Publication) error {
-	return s.db.Create(publication).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(publication).Error; err != nil {
+			return err
+		}
+		return s.indexPublication(tx, publication.ID)
+	})
 }
 
 // preloadPublication preloads a publication
 func (s *Store) preloadPublication() *gorm.DB {
-	return s.db.Session(&gorm.Session{FullSaveAssociations: true}).Model(&Publication{}).Preload("Author").Preload("Publisher").Preload("Language").Preload("Category")
+	return s.db.Session(&gorm.Session{FullSaveAssociations: true}).Model(&Publication{}).Preload("Author").Preload("Publisher").Preload("Language").Preload("Category").Preload("Covers").Preload("Contributors", orderByID)
 	// return s.db.Model(&Publication{}).Preload("Author").Preload("Publisher").Preload("Language").Preload("Category")
 }
 
+// orderByID keeps the order in which associations were created
+func orderByID(tx *gorm.DB) *gorm.DB {
+	return tx.Order("id")
+}
+
 // GetPublication returns a publication, found by uuid
 func (s *Store) GetPublication(uuid string) (*Publication, error) {
 	var publication Publication
 	return &publication, s.preloadPublication().Where("uuid = ?", uuid).First(&publication).Error
 }
 
+// GetPublicationByISBN returns a publication, found by ISBN-13
+func (s *Store) GetPublicationByISBN(isbn string) (*Publication, error) {
+	var publication Publication
+	return &publication, s.preloadPublication().Where("isbn = ?", isbn).First(&publication).Error
+}
+
 // UpdatePublication updates a publication
 func (s *Store) UpdatePublication(publication *Publication) error {
-	return s.db.Save(publication).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(publication).Error; err != nil {
+			return err
+		}
+		return s.indexPublication(tx, publication.ID)
+	})
+}
+
+// ReplacePublication updates a publication and replaces its languages, publishers, authors, categories and contributors
+// by the ones of the publication, when UpdatePublication only adds associations.
+func (s *Store) ReplacePublication(publication *Publication) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := replacePublication(tx, publication); err != nil {
+			return err
+		}
+		return s.indexPublication(tx, publication.ID)
+	})
+}
+
+func replacePublication(tx *gorm.DB, publication *Publication) error {
+	if err := tx.Omit(clause.Associations).Save(publication).Error; err != nil {
+		return err
+	}
+	pub := &Publication{Model: gorm.Model{ID: publication.ID}}
+	if err := tx.Model(pub).Association("Language").Replace(publication.Language); err != nil {
+		return err
+	}
+	if err := tx.Model(pub).Association("Publisher").Replace(publication.Publisher); err != nil {
+		return err
+	}
+	if err := tx.Model(pub).Association("Author").Replace(publication.Author); err != nil {
+		return err
+	}
+	if err := tx.Model(pub).Association("Category").Replace(publication.Category); err != nil {
+		return err
+	}
+	return replaceContributors(tx, publication)
 }
 
 // DeletePublication deletes a publication
 func (s *Store) DeletePublication(publication *Publication) error {
-	return s.db.Delete(publication).Error
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("publication_id = ?", publication.ID).Delete(&CoverImage{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Unscoped().Where("publication_id = ?", publication.ID).Delete(&Contributor{}).Error; err != nil {
+			return err
+		}
+		if err := s.unindexPublication(tx, publication.ID); err != nil {
+			return err
+		}
+		return tx.Delete(publication).Error
+	})
 }
 
 // ListPublications retrieves all publications
@@ -134,72 +205,71 @@ func (s *Store) ListPublications(page int, pageSize int) ([]Publication, error)
 	return publications, s.preloadPublication().Order(clause.OrderByColumn{Column: clause.Column{Name: "updated_at"}, Desc: true}).Offset(offset).Limit(pageSize).Find(&publications).Error
 }
 
-// FindPublicationsByType retrieves publications by content type
-func (s *Store) FindPublicationsByType(contentType string, page int, pageSize int) ([]Publication, error) {
-	var publications []Publication
+// findPublications returns a page of the publications selected by a scope, sorted by last update,
+// and the total number of selected publications
+func (s *Store) findPublications(scope func(*gorm.DB) *gorm.DB, page int, pageSize int) ([]Publication, int64, error) {
+	publications := []Publication{}
+	var total int64
+
+	// page starts at 1, pageSize >= 1
 	offset := (page - 1) * pageSize
 	if offset < 0 {
-		return publications, errors.New("invalid pagination")
+		return publications, total, errors.New("invalid pagination")
 	}
-	return publications, s.db.Offset(offset).Limit(pageSize).Find(&publications, "content_type= ?", contentType).Error
+	if err := s.db.Model(&Publication{}).Scopes(scope).Count(&total).Error; err != nil {
+		return publications, total, err
+	}
+	return publications, total, s.preloadPublication().Scopes(scope).Order(clause.OrderByColumn{Column: clause.Column{Table: "publications", Name: "updated_at"}, Desc: true}).Offset(offset).Limit(pageSize).Find(&publications).Error
 }
 
-// FindPublicationsByTitle retrieves publications by Title
-func (s *Store) FindPublicationsByTitle(title string, page int, pageSize int) ([]Publication, error) {
-	var publications []Publication
-	offset := (page - 1) * pageSize
-	if offset < 0 {
-		return publications, errors.New("invalid pagination")
-	}
-	return publications, s.preloadPublication().Where("Title LIKE ?", "%"+title+"%").Order(clause.OrderByColumn{Column: clause.Column{Table: "publications", Name: "updated_at"}, Desc: true}).Offset(offset).Limit(pageSize).Find(&publications).Error
+// FindPublicationsByType retrieves publications by content type, and returns their total count
+func (s *Store) FindPublicationsByType(contentType string, page int, pageSize int) ([]Publication, int64, error) {
+	return s.findPublications(func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("publications.content_type = ?", contentType)
+	}, page, pageSize)
 }
 
-// FindPublicationsByCategory retrieves publications by category
-func (s *Store) FindPublicationsByCategory(category string, page int, pageSize int) ([]Publication, error) {
-	var publications []Publication
-	offset := (page - 1) * pageSize
-	if offset < 0 {
-		return publications, errors.New("invalid pagination")
-	}
-	return publications, s.preloadPublication().Joins("JOIN publication_category ON publication_category.publication_id = publications.id").
-		Joins("JOIN categories ON categories.id = publication_category.category_id").
-		Where("categories.name = ?", category).Order(clause.OrderByColumn{Column: clause.Column{Table: "publications", Name: "updated_at"}, Desc: true}).Offset(offset).Limit(pageSize).Find(&publications).Error
+// FindPublicationsByTitle retrieves publications by Title, and returns their total count
+func (s *Store) FindPublicationsByTitle(title string, page int, pageSize int) ([]Publication, int64, error) {
+	return s.findPublications(func(tx *gorm.DB) *gorm.DB {
+		return tx.Where("publications.title LIKE ?", "%"+title+"%")
+	}, page, pageSize)
 }
 
-// FindPublicationsByAuthor retrieves publications by author
-func (s *Store) FindPublicationsByAuthor(author string, page int, pageSize int) ([]Publication, error) {
-	var publications []Publication
-	offset := (page - 1) * pageSize
-	if offset < 0 {
-		return publications, errors.New("invalid pagination")
-	}
-	return publications, s.preloadPublication().Joins("JOIN publication_author ON publication_author.publication_id = publications.id").
-		Joins("JOIN authors ON authors.id = publication_author.author_id").
-		Where("authors.name = ?", author).Order(clause.OrderByColumn{Column: clause.Column{Table: "publications", Name: "updated_at"}, Desc: true}).Offset(offset).Limit(pageSize).Find(&publications).Error
+// FindPublicationsByCategory retrieves publications by category, and returns their total count
+func (s *Store) FindPublicationsByCategory(category string, page int, pageSize int) ([]Publication, int64, error) {
+	return s.findPublications(func(tx *gorm.DB) *gorm.DB {
+		return tx.Joins("JOIN publication_category ON publication_category.publication_id = publications.id").
+			Joins("JOIN categories ON categories.id = publication_category.category_id").
+			Where("categories.name = ?", category)
+	}, page, pageSize)
 }
 
-// FindPublicationsByPublisher retrieves publications by publisher
-func (s *Store) FindPublicationsByPublisher(publisher string, page int, pageSize int) ([]Publication, error) {
-	var publications []Publication
-	offset := (page - 1) * pageSize
-	if offset < 0 {
-		return publications, errors.New("invalid pagination")
-	}
-	return publications, s.preloadPublication().Joins("JOIN publication_publisher ON publication_publisher.publication_id = publications.id").
-		Joins("JOIN publishers ON publishers.id = publication_publisher.publisher_id").
-		Where("publishers.name = ?", publisher).Order(clause.OrderByColumn{Column: clause.Column{Table: "publications", Name: "updated_at"}, Desc: true}).Offset(offset).Limit(pageSize).Find(&publications).Error
+// FindPublicationsByAuthor retrieves publications by author, and returns their total count
+func (s *Store) FindPublicationsByAuthor(author string, page int, pageSize int) ([]Publication, int64, error) {
+	return s.findPublications(func(tx *gorm.DB) *gorm.DB {
+		return tx.Joins("JOIN publication_author ON publication_author.publication_id = publications.id").
+			Joins("JOIN authors ON authors.id = publication_author.author_id").
+			Where("authors.name = ?", author)
+	}, page, pageSize)
 }
 
-// FindPublicationsByLanguage retrieves publications by language
-func (s *Store) FindPublicationsByLanguage(code string, page int, pageSize int) ([]Publication, error) {
-	var publications []Publication
-	offset := (page - 1) * pageSize
-	if offset < 0 {
-		return publications, errors.New("invalid pagination")
-	}
-	return publications, s.preloadPublication().Joins("JOIN publication_language ON publication_language.publication_id = publications.id").
-		Joins("JOIN languages ON languages.id = publication_language.language_id").
-		Where("languages.code = ?", code).Order(clause.OrderByColumn{Column: clause.Column{Table: "publications", Name: "updated_at"}, Desc: true}).Offset(offset).Limit(pageSize).Find(&publications).Error
+// FindPublicationsByPublisher retrieves publications by publisher, and returns their total count
+func (s *Store) FindPublicationsByPublisher(publisher string, page int, pageSize int) ([]Publication, int64, error) {
+	return s.findPublications(func(tx *gorm.DB) *gorm.DB {
+		return tx.Joins("JOIN publication_publisher ON publication_publisher.publication_id = publications.id").
+			Joins("JOIN publishers ON publishers.id = publication_publisher.publisher_id").
+			Where("publishers.name = ?", publisher)
+	}, page, pageSize)
+}
+
+// FindPublicationsByLanguage retrieves publications by language, and returns their total count
+func (s *Store) FindPublicationsByLanguage(code string, page int, pageSize int) ([]Publication, int64, error) {
+	return s.findPublications(func(tx *gorm.DB) *gorm.DB {
+		return tx.Joins("JOIN publication_language ON publication_language.publication_id = publications.id").
+			Joins("JOIN languages ON languages.id = publication_language.language_id").
+			Where("languages.code = ?", code)
+	}, page, pageSize)
 }
 
 // Count returns the publication count
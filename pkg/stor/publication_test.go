@@ -189,8 +189,9 @@ func TestFindByCategory(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Test FindByCategory
-	publications, err := store.FindPublicationsByCategory("Category B", 1, 10)
+	publications, total, err := store.FindPublicationsByCategory("Category B", 1, 10)
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
 
 	// Ensure the correct number of publications is retrieved
 	if len(publications) != 1 {
@@ -240,8 +241,9 @@ func TestGetPublicationByAuthor(t *testing.T) {
 	err = store.CreatePublication(publication2)
 	assert.NoError(t, err)
 
-	publications, err := store.FindPublicationsByAuthor("Author B", 1, 10)
+	publications, total, err := store.FindPublicationsByAuthor("Author B", 1, 10)
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
 	assert.Equal(t, 1, len(publications))
 
 	// Ensure the retrieved publication matches the created publication
@@ -290,8 +292,9 @@ func TestFindByPublisher(t *testing.T) {
 	err = store.CreatePublication(publication2)
 	assert.NoError(t, err)
 
-	publications, err := store.FindPublicationsByPublisher("Publisher B", 1, 10)
+	publications, total, err := store.FindPublicationsByPublisher("Publisher B", 1, 10)
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
 	assert.Equal(t, 1, len(publications))
 
 	// check publishers
@@ -340,8 +343,9 @@ func TestFindByLanguage(t *testing.T) {
 	err = store.CreatePublication(publication2)
 	assert.NoError(t, err)
 
-	publications, err := store.FindPublicationsByLanguage("en", 1, 10)
+	publications, total, err := store.FindPublicationsByLanguage("en", 1, 10)
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
 	assert.Equal(t, 1, len(publications))
 
 	// check languages
@@ -452,13 +456,15 @@ func TestCreate2PublicationsWithSameCategory(t *testing.T) {
 	}
 
 	// find by type
-	publications, err = store.FindPublicationsByType("text/plain", 1, 5)
+	publications, total, err := store.FindPublicationsByType("text/plain", 1, 5)
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
 	assert.Equal(t, 1, len(publications))
 
 	// find by title
-	publications, err = store.FindPublicationsByTitle("Test Publication 1", 1, 5)
+	publications, total, err = store.FindPublicationsByTitle("Test Publication 1", 1, 5)
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
 	assert.Equal(t, 1, len(publications))
 
 	// Clean up test data
@@ -468,3 +474,54 @@ func TestCreate2PublicationsWithSameCategory(t *testing.T) {
 	err = store.DeletePublication(publication2)
 	assert.NoError(t, err)
 }
+
+func TestReplacePublication(t *testing.T) {
+
+	publication := &Publication{
+		UUID:        uuid.New().String(),
+		ISBN:        "9780306406157",
+		Title:       "Publication to replace",
+		ContentType: "application/epub+zip",
+		Author:      []Author{{Name: "Author R1"}, {Name: "Author R2"}},
+		Category:    []Category{{Name: "Category R"}},
+		Contributors: []Contributor{
+			{Name: "Translator R", Role: RoleTranslator},
+			{Name: "Narrator R", Role: RoleNarrator},
+		},
+	}
+	err := store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	p, err := store.GetPublicationByISBN("9780306406157")
+	assert.NoError(t, err)
+	assert.Equal(t, publication.UUID, p.UUID)
+	if assert.Len(t, p.Contributors, 2) {
+		assert.Equal(t, "Translator R", p.Contributors[0].Name)
+		assert.Equal(t, RoleNarrator, p.Contributors[1].Role)
+	}
+
+	p.Title = "Replaced publication"
+	p.Author = []Author{{Name: "Author R3"}}
+	p.Category = nil
+	p.Contributors = []Contributor{{Name: "Illustrator R", Role: RoleIllustrator}}
+	err = store.ReplacePublication(p)
+	assert.NoError(t, err)
+
+	p, err = store.GetPublication(publication.UUID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Replaced publication", p.Title)
+	if assert.Len(t, p.Author, 1) {
+		assert.Equal(t, "Author R3", p.Author[0].Name)
+	}
+	assert.Len(t, p.Category, 0)
+	if assert.Len(t, p.Contributors, 1) {
+		assert.Equal(t, Contributor{Name: "Illustrator R", Role: RoleIllustrator}, Contributor{Name: p.Contributors[0].Name, Role: p.Contributors[0].Role})
+	}
+
+	err = store.DeletePublication(p)
+	assert.NoError(t, err)
+	var count int64
+	err = store.db.Model(&Contributor{}).Where("publication_id = ?", p.ID).Count(&count).Error
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+}
@@ -0,0 +1,263 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PublicationQuery combines filters on publications: a publication must match every filter.
+// Empty filters are ignored.
+type PublicationQuery struct {
+	ContentType string
+	Author      string
+	Publisher   string
+	Language    string // language code
+	Category    string
+	Title       string // part of the title
+	Text        string // full-text search, as in SearchPublications
+	// Sort is SortTitle, SortDatePublished or SortUpdated; by default, publications are sorted by relevance
+	// if the query has a text filter, by last update otherwise
+	Sort string
+	// Order is OrderAsc or OrderDesc; by default, titles are sorted in ascending order and dates in descending order
+	Order string
+}
+
+// Sort keys of a publication query
+const (
+	SortTitle         = "title"
+	SortDatePublished = "date_published"
+	SortUpdated       = "updated_at"
+)
+
+// Sort orders of a publication query
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// ErrInvalidSort is returned for an unknown sort key or order
+var ErrInvalidSort = errors.New("invalid sort: expected title, date_published or updated_at, in asc or desc order")
+
+// FacetCount is a facet value and the number of publications which have it
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// PublicationFacets holds the facet values of a set of publications, sorted by value
+type PublicationFacets struct {
+	ContentType []FacetCount `json:"content_type"`
+	Author      []FacetCount `json:"author"`
+	Publisher   []FacetCount `json:"publisher"`
+	Language    []FacetCount `json:"language"`
+	Category    []FacetCount `json:"category"`
+}
+
+// PublicationResult is a page of the publications matching a query,
+// with the total number of matching publications and their facet values
+type PublicationResult struct {
+	Publications []Publication
+	Total        int64
+	Facets       PublicationFacets
+}
+
+// facetJoins maps the many2many facets to their table, join table, foreign key and value column
+var facetJoins = []struct {
+	table, join, key, column string
+	counts                   func(f *PublicationFacets) *[]FacetCount
+}{
+	{"authors", "publication_author", "author_id", "name", func(f *PublicationFacets) *[]FacetCount { return &f.Author }},
+	{"publishers", "publication_publisher", "publisher_id", "name", func(f *PublicationFacets) *[]FacetCount { return &f.Publisher }},
+	{"languages", "publication_language", "language_id", "code", func(f *PublicationFacets) *[]FacetCount { return &f.Language }},
+	{"categories", "publication_category", "category_id", "name", func(f *PublicationFacets) *[]FacetCount { return &f.Category }},
+}
+
+// QueryPublications returns a page of the publications matching every filter of a query,
+// the total number of matching publications and the facet values of all matching publications.
+// Publications are sorted as set by the query.
+func (s *Store) QueryPublications(query *PublicationQuery, page int, pageSize int) (*PublicationResult, error) {
+	result := &PublicationResult{Publications: []Publication{}}
+	offset := (page - 1) * pageSize
+	if offset < 0 {
+		return result, errors.New("invalid pagination")
+	}
+	order, err := query.orderBy()
+	if err != nil {
+		return result, err
+	}
+
+	match, found, err := s.textMatch(query)
+	if err != nil || !found {
+		return result, err
+	}
+
+	if err := s.filterPublications(query, match).Count(&result.Total).Error; err != nil {
+		return result, err
+	}
+	if result.Total == 0 {
+		return result, nil
+	}
+
+	if match == nil || query.Sort != "" {
+		err = s.preloadPublication().Where("publications.id IN (?)", s.filterPublications(query, match).Select("publications.id")).
+			Order(order).Offset(offset).Limit(pageSize).Find(&result.Publications).Error
+	} else {
+		result.Publications, err = s.rankedPage(query, match, offset, pageSize)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	result.Facets, err = s.countFacets(query, match)
+	return result, err
+}
+
+// orderBy returns the sort clause of a query, on the sort key then on the ID so that pages are stable
+func (query *PublicationQuery) orderBy() (clause.OrderBy, error) {
+	sort := query.Sort
+	if sort == "" {
+		sort = SortUpdated
+	}
+	if sort != SortTitle && sort != SortDatePublished && sort != SortUpdated {
+		return clause.OrderBy{}, ErrInvalidSort
+	}
+	desc := sort != SortTitle
+	switch query.Order {
+	case OrderAsc:
+		desc = false
+	case OrderDesc:
+		desc = true
+	case "":
+	default:
+		return clause.OrderBy{}, ErrInvalidSort
+	}
+	return clause.OrderBy{Columns: []clause.OrderByColumn{
+		{Column: clause.Column{Table: "publications", Name: sort}, Desc: desc},
+		{Column: clause.Column{Table: "publications", Name: "id"}, Desc: desc},
+	}}, nil
+}
+
+// filterPublications returns a query on the publications matching every filter of a query,
+// and its text filter if match is not nil
+func (s *Store) filterPublications(query *PublicationQuery, match *searchMatch) *gorm.DB {
+	tx := s.db.Model(&Publication{})
+	if query.ContentType != "" {
+		tx = tx.Where("publications.content_type = ?", query.ContentType)
+	}
+	if query.Title != "" {
+		tx = tx.Where("publications.title LIKE ?", "%"+query.Title+"%")
+	}
+	values := []string{query.Author, query.Publisher, query.Language, query.Category}
+	for i, f := range facetJoins {
+		if values[i] == "" {
+			continue
+		}
+		sub := s.db.Table(f.join).Select(f.join+".publication_id").
+			Joins("JOIN "+f.table+" ON "+f.table+".id = "+f.join+"."+f.key).
+			Where(f.table+"."+f.column+" = ?", values[i])
+		tx = tx.Where("publications.id IN (?)", sub)
+	}
+	switch {
+	case match == nil:
+	case match.ranked != nil:
+		tx = tx.Where("publications.id IN (?)", s.db.Table("(?) AS matches", match.ranked).Select("matches.publication_id"))
+	default:
+		tx = tx.Where("publications.id IN ?", match.ids)
+	}
+	return tx
+}
+
+// rankedPage returns a page of the publications matching a query, best matches of its text filter first
+func (s *Store) rankedPage(query *PublicationQuery, match *searchMatch, offset, pageSize int) ([]Publication, error) {
+	if match.ranked != nil {
+		publications := []Publication{}
+		return publications, s.preloadPublication().Select("publications.*").
+			Joins("JOIN (?) AS matches ON matches.publication_id = publications.id", match.ranked).
+			Where("publications.id IN (?)", s.filterPublications(query, nil).Select("publications.id")).
+			Order("matches.score DESC, publications.id DESC").Offset(offset).Limit(pageSize).Find(&publications).Error
+	}
+
+	var matching []uint
+	if err := s.filterPublications(query, match).Pluck("publications.id", &matching).Error; err != nil {
+		return nil, err
+	}
+	found := make(map[uint]bool, len(matching))
+	for _, id := range matching {
+		found[id] = true
+	}
+	var ids []uint
+	for _, id := range match.ids {
+		if found[id] {
+			ids = append(ids, id)
+		}
+	}
+	if offset >= len(ids) {
+		return []Publication{}, nil
+	}
+	ids = ids[offset:min(offset+pageSize, len(ids))]
+
+	return s.loadPublications(ids)
+}
+
+// countFacets returns the facet values of the publications matching a query
+func (s *Store) countFacets(query *PublicationQuery, match *searchMatch) (PublicationFacets, error) {
+	var facets PublicationFacets
+
+	err := s.db.Model(&Publication{}).Select("publications.content_type AS value, count(*) AS count").
+		Where("publications.id IN (?)", s.filterPublications(query, match).Select("publications.id")).
+		Group("publications.content_type").Order("value").Scan(&facets.ContentType).Error
+	if err != nil {
+		return facets, err
+	}
+	for _, f := range facetJoins {
+		err = s.db.Table(f.table).Select(f.table+"."+f.column+" AS value, count(*) AS count").
+			Joins("JOIN "+f.join+" ON "+f.join+"."+f.key+" = "+f.table+".id").
+			Where(f.join+".publication_id IN (?)", s.filterPublications(query, match).Select("publications.id")).
+			Group(f.table + "." + f.column).Order("value").Scan(f.counts(&facets)).Error
+		if err != nil {
+			return facets, err
+		}
+	}
+	return facets, nil
+}
+
+// searchMatch selects the publications matching the text filter of a query. Engines stored in the database
+// provide a ranked subquery; the IDs matched by other engines, e.g. a Bleve index, are listed best matches first.
+type searchMatch struct {
+	ranked *gorm.DB
+	ids    []uint
+}
+
+// textMatch returns the publications matching the text filter of a query, or nil if the query has no text filter.
+// found is false if no publication can match the text filter.
+func (s *Store) textMatch(query *PublicationQuery) (match *searchMatch, found bool, err error) {
+	if query.Text == "" {
+		return nil, true, nil
+	}
+	// a text filter without any word matches nothing
+	terms := searchTerms(query.Text)
+	if len(terms) == 0 || s.search == nil {
+		return nil, false, nil
+	}
+	if ranker, ok := s.search.(searchRanker); ok {
+		return &searchMatch{ranked: ranker.Ranked(s.db, terms)}, true, nil
+	}
+	ids, err := s.searchAll(terms)
+	return &searchMatch{ids: ids}, len(ids) > 0, err
+}
+
+// searchAll returns the IDs of all the publications matching search terms, best matches first
+func (s *Store) searchAll(terms []string) ([]uint, error) {
+	_, total, err := s.search.Search(s.db, terms, 0, 1)
+	if err != nil || total == 0 {
+		return nil, err
+	}
+	ids, _, err := s.search.Search(s.db, terms, 0, int(total))
+	return ids, err
+}
@@ -0,0 +1,105 @@
+package stor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryPublications(t *testing.T) {
+
+	// French EPUB in Category A, French PDF in Category A, English EPUB in Category B
+	publication1 := &Publication{
+		Title:       "Les Quatre Saisons de Quetzalie",
+		UUID:        uuid.New().String(),
+		ContentType: "application/epub+zip",
+		Language:    []Language{{Code: "fr"}},
+		Publisher:   []Publisher{{Name: "Publisher A"}},
+		Author:      []Author{{Name: "Author A"}},
+		Category:    []Category{{Name: "Category A"}},
+	}
+	publication2 := &Publication{
+		Title:       "Quetzalie en hiver",
+		UUID:        uuid.New().String(),
+		ContentType: "application/pdf+lcp",
+		Language:    []Language{{Code: "fr"}},
+		Publisher:   []Publisher{{Name: "Publisher A"}},
+		Author:      []Author{{Name: "Author A"}, {Name: "Author B"}},
+		Category:    []Category{{Name: "Category A"}},
+	}
+	publication3 := &Publication{
+		Title:       "Winter in Quetzalie",
+		UUID:        uuid.New().String(),
+		ContentType: "application/epub+zip",
+		Language:    []Language{{Code: "en"}},
+		Publisher:   []Publisher{{Name: "Publisher B"}},
+		Author:      []Author{{Name: "Author B"}},
+		Category:    []Category{{Name: "Category B"}},
+	}
+	for _, p := range []*Publication{publication1, publication2, publication3} {
+		err := store.CreatePublication(p)
+		assert.NoError(t, err)
+	}
+
+	// filters are combined
+	result, err := store.QueryPublications(&PublicationQuery{ContentType: "application/epub+zip", Language: "fr", Category: "Category A"}, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.Total)
+	if assert.Len(t, result.Publications, 1) {
+		assert.Equal(t, publication1.UUID, result.Publications[0].UUID)
+	}
+
+	// facet values are counted in the result set
+	result, err = store.QueryPublications(&PublicationQuery{Text: "quetzalie", Language: "fr"}, 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), result.Total)
+	assert.Len(t, result.Publications, 1)
+	assert.Equal(t, []FacetCount{{"application/epub+zip", 1}, {"application/pdf+lcp", 1}}, result.Facets.ContentType)
+	assert.Equal(t, []FacetCount{{"Author A", 2}, {"Author B", 1}}, result.Facets.Author)
+	assert.Equal(t, []FacetCount{{"Publisher A", 2}}, result.Facets.Publisher)
+	assert.Equal(t, []FacetCount{{"fr", 2}}, result.Facets.Language)
+	assert.Equal(t, []FacetCount{{"Category A", 2}}, result.Facets.Category)
+
+	// a text filter sorts publications by relevance
+	result, err = store.QueryPublications(&PublicationQuery{Text: "winter quetzalie"}, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.Total)
+	result, err = store.QueryPublications(&PublicationQuery{Text: "quetzalie", Author: "Author B"}, 2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), result.Total)
+	assert.Len(t, result.Publications, 1)
+
+	// sorted by title, in ascending order by default
+	result, err = store.QueryPublications(&PublicationQuery{Text: "quetzalie", Sort: SortTitle}, 1, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Publications, 3) {
+		assert.Equal(t, publication1.UUID, result.Publications[0].UUID)
+		assert.Equal(t, publication3.UUID, result.Publications[2].UUID)
+	}
+	result, err = store.QueryPublications(&PublicationQuery{Text: "quetzalie", Sort: SortTitle, Order: OrderDesc}, 1, 1)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Publications, 1) {
+		assert.Equal(t, publication3.UUID, result.Publications[0].UUID)
+	}
+	_, err = store.QueryPublications(&PublicationQuery{Sort: "copies"}, 1, 10)
+	assert.ErrorIs(t, err, ErrInvalidSort)
+
+	// part of the title
+	result, err = store.QueryPublications(&PublicationQuery{Title: "en hiver"}, 1, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Publications, 1) {
+		assert.Equal(t, publication2.UUID, result.Publications[0].UUID)
+	}
+
+	// no match
+	result, err = store.QueryPublications(&PublicationQuery{Text: "quetzalie", Publisher: "Publisher B", Language: "fr"}, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), result.Total)
+	assert.Len(t, result.Publications, 0)
+
+	for _, p := range []*Publication{publication1, publication2, publication3} {
+		err = store.DeletePublication(p)
+		assert.NoError(t, err)
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// SearchEngine is a full-text index of publications.
+// Documents are folded (lower case, without accents) before being indexed, and so are search terms.
+// Index and Remove are called in the database transaction which modifies the publication,
+// so that the index of a database engine stays consistent with the publications.
+type SearchEngine interface {
+	// Migrate creates the index if it does not exist
+	Migrate(db *gorm.DB) error
+	// Index adds or replaces the document of a publication
+	Index(tx *gorm.DB, doc *SearchDocument) error
+	// Remove removes the document of a publication
+	Remove(tx *gorm.DB, publicationID uint) error
+	// Search returns the IDs of the publications matching every term as a word prefix, best matches first,
+	// and the total number of matching publications
+	Search(db *gorm.DB, terms []string, offset, limit int) ([]uint, int64, error)
+	// Count returns the number of indexed publications
+	Count(db *gorm.DB) (int64, error)
+}
+
+// searchRanker is implemented by the search engines stored in the database.
+// Their matches are selected by a subquery which is combined with the filters of a publication query,
+// rather than by a list of IDs.
+type searchRanker interface {
+	// Ranked returns a query selecting the publication_id and score of the publications matching every term,
+	// a better match having a higher score
+	Ranked(db *gorm.DB, terms []string) *gorm.DB
+}
+
+// SearchDocument is the searchable text of a publication
+type SearchDocument struct {
+	PublicationID uint
+	Title         string
+	Author        string
+	Subject       string
+	Description   string
+}
+
+// newSearchEngine returns the search engine of a database dialect.
+// SQLite uses FTS5 if it is compiled in (build tag sqlite_fts5), PostgreSQL uses tsvector,
+// other databases use a portable word prefix search.
+func newSearchEngine(db *gorm.DB, dialect string) SearchEngine {
+	switch dialect {
+	case "sqlite3":
+		// the probe fails with "no such module: fts5" if FTS5 is not compiled in
+		probe := db.Session(&gorm.Session{Logger: db.Logger.LogMode(logger.Silent)})
+		if err := (fts5Engine{}).Migrate(probe); err == nil {
+			return fts5Engine{}
+		}
+		log.Println("SQLite FTS5 is not available, a basic search engine is used")
+	case "postgres":
+		return tsvectorEngine{}
+	}
+	return basicEngine{}
+}
+
+// SetSearchEngine replaces the search engine of the store, e.g. by a Bleve index (see NewBleveEngine),
+// and indexes every publication if the engine is not in sync with publications
+func (s *Store) SetSearchEngine(engine SearchEngine) error {
+	if err := engine.Migrate(s.db); err != nil {
+		return err
+	}
+	s.search = engine
+	return s.checkSearchIndex()
+}
+
+// RebuildSearchIndex indexes every publication
+func (s *Store) RebuildSearchIndex() error {
+	var ids []uint
+	if err := s.db.Model(&Publication{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := s.indexPublication(s.db, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSearchIndex rebuilds the search index if it is not in sync with publications, e.g. after an upgrade
+func (s *Store) checkSearchIndex() error {
+	indexed, err := s.search.Count(s.db)
+	if err != nil {
+		return err
+	}
+	count, err := s.CountPublications()
+	if err != nil {
+		return err
+	}
+	if indexed == count {
+		return nil
+	}
+	log.Printf("Indexing %d publications for search", count)
+	return s.RebuildSearchIndex()
+}
+
+// indexPublication indexes a publication, reading it in a transaction
+func (s *Store) indexPublication(tx *gorm.DB, publicationID uint) error {
+	if s.search == nil {
+		return nil
+	}
+	var p Publication
+	err := tx.Preload("Author").Preload("Category").First(&p, publicationID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.search.Remove(tx, publicationID)
+	}
+	if err != nil {
+		return err
+	}
+	doc := &SearchDocument{
+		PublicationID: p.ID,
+		Title:         fold(p.Title),
+		Description:   fold(p.Description),
+	}
+	var authors, subjects []string
+	for _, a := range p.Author {
+		authors = append(authors, a.Name)
+	}
+	for _, c := range p.Category {
+		subjects = append(subjects, c.Name)
+	}
+	doc.Author = fold(strings.Join(authors, " "))
+	doc.Subject = fold(strings.Join(subjects, " "))
+	return s.search.Index(tx, doc)
+}
+
+// unindexPublication removes a publication from the search index
+func (s *Store) unindexPublication(tx *gorm.DB, publicationID uint) error {
+	if s.search == nil {
+		return nil
+	}
+	return s.search.Remove(tx, publicationID)
+}
+
+// SearchPublications returns the publications whose title, authors, description or subjects
+// contain every word of a query, as a word or word prefix, best matches first.
+// Case and accents are ignored. The total number of matching publications is also returned.
+func (s *Store) SearchPublications(query string, page, pageSize int) ([]Publication, int64, error) {
+	publications := []Publication{}
+	offset := (page - 1) * pageSize
+	if offset < 0 {
+		return publications, 0, errors.New("invalid pagination")
+	}
+	terms := searchTerms(query)
+	if len(terms) == 0 || s.search == nil {
+		return publications, 0, nil
+	}
+
+	ids, total, err := s.search.Search(s.db, terms, offset, pageSize)
+	if err != nil || len(ids) == 0 {
+		return publications, total, err
+	}
+	publications, err = s.loadPublications(ids)
+	return publications, total, err
+}
+
+// loadPublications returns the publications of a list of IDs, in the same order
+func (s *Store) loadPublications(ids []uint) ([]Publication, error) {
+	var publications []Publication
+	if err := s.preloadPublication().Where("publications.id IN ?", ids).Find(&publications).Error; err != nil {
+		return []Publication{}, err
+	}
+	byID := make(map[uint]Publication, len(publications))
+	for _, p := range publications {
+		byID[p.ID] = p
+	}
+	sorted := make([]Publication, 0, len(publications))
+	for _, id := range ids {
+		if p, ok := byID[id]; ok {
+			sorted = append(sorted, p)
+		}
+	}
+	return sorted, nil
+}
+
+// foldTransformer removes accents
+var foldTransformer = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// fold returns a text in lower case, without accents, where words are separated by single spaces
+func fold(s string) string {
+	if folded, _, err := transform.String(foldTransformer, s); err == nil {
+		s = folded
+	}
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// searchTerms returns the folded words of a query
+func searchTerms(query string) []string {
+	return strings.Fields(fold(query))
+}
@@ -0,0 +1,145 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/custom"
+	"github.com/blevesearch/bleve/v2/analysis/tokenizer/whitespace"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"gorm.io/gorm"
+)
+
+// bleveAnalyzer splits folded documents and terms into words; they are already in lower case, without accents
+const bleveAnalyzer = "folded"
+
+// bleveFieldBoosts weighs a match in the title more than a match in authors, subjects or description
+var bleveFieldBoosts = []struct {
+	field string
+	boost float64
+}{
+	{"title", 10},
+	{"author", 5},
+	{"subject", 3},
+	{"description", 1},
+}
+
+// bleveDocument is the document of a publication in a Bleve index
+type bleveDocument struct {
+	ID          float64 `json:"id"`
+	Title       string  `json:"title"`
+	Author      string  `json:"author"`
+	Subject     string  `json:"subject"`
+	Description string  `json:"description"`
+}
+
+// bleveEngine indexes publications in an embedded Bleve index; the ID of a document is the ID of its publication.
+// Unlike database engines, the index is not rolled back with a failed database transaction:
+// it is rebuilt at startup if its number of documents differs from the number of publications.
+type bleveEngine struct {
+	index bleve.Index
+}
+
+// NewBleveEngine opens the Bleve index stored in a directory, or creates it if the directory does not exist.
+// The index is kept in memory if path is empty.
+func NewBleveEngine(path string) (SearchEngine, error) {
+	if path == "" {
+		index, err := bleve.NewMemOnly(bleveMapping())
+		return &bleveEngine{index: index}, err
+	}
+	index, err := bleve.Open(path)
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		index, err = bleve.New(path, bleveMapping())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &bleveEngine{index: index}, nil
+}
+
+// bleveMapping returns the mapping of publication documents
+func bleveMapping() *mapping.IndexMappingImpl {
+	m := bleve.NewIndexMapping()
+	err := m.AddCustomAnalyzer(bleveAnalyzer, map[string]interface{}{
+		"type":      custom.Name,
+		"tokenizer": whitespace.Name,
+	})
+	if err != nil {
+		// the analyzer is built from registered components only
+		panic(err)
+	}
+	m.DefaultAnalyzer = bleveAnalyzer
+
+	doc := bleve.NewDocumentMapping()
+	text := bleve.NewTextFieldMapping()
+	text.Store = false
+	text.IncludeInAll = false
+	for _, f := range bleveFieldBoosts {
+		doc.AddFieldMappingsAt(f.field, text)
+	}
+	id := bleve.NewNumericFieldMapping()
+	id.IncludeInAll = false
+	doc.AddFieldMappingsAt("id", id)
+	m.DefaultMapping = doc
+	return m
+}
+
+func (e *bleveEngine) Migrate(db *gorm.DB) error {
+	return nil
+}
+
+func (e *bleveEngine) Index(tx *gorm.DB, doc *SearchDocument) error {
+	return e.index.Index(strconv.FormatUint(uint64(doc.PublicationID), 10), bleveDocument{
+		ID:          float64(doc.PublicationID),
+		Title:       doc.Title,
+		Author:      doc.Author,
+		Subject:     doc.Subject,
+		Description: doc.Description,
+	})
+}
+
+func (e *bleveEngine) Remove(tx *gorm.DB, publicationID uint) error {
+	return e.index.Delete(strconv.FormatUint(uint64(publicationID), 10))
+}
+
+func (e *bleveEngine) Search(db *gorm.DB, terms []string, offset, limit int) ([]uint, int64, error) {
+	// every term is a prefix query on any field, terms are combined with AND
+	conjuncts := make([]query.Query, len(terms))
+	for i, t := range terms {
+		disjuncts := make([]query.Query, len(bleveFieldBoosts))
+		for j, f := range bleveFieldBoosts {
+			prefix := bleve.NewPrefixQuery(t)
+			prefix.SetField(f.field)
+			prefix.SetBoost(f.boost)
+			disjuncts[j] = prefix
+		}
+		conjuncts[i] = bleve.NewDisjunctionQuery(disjuncts...)
+	}
+	req := bleve.NewSearchRequestOptions(bleve.NewConjunctionQuery(conjuncts...), limit, offset, false)
+	req.SortBy([]string{"-_score", "-id"})
+
+	res, err := e.index.Search(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	ids := make([]uint, 0, len(res.Hits))
+	for _, hit := range res.Hits {
+		id, err := strconv.ParseUint(hit.ID, 10, 64)
+		if err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, int64(res.Total), nil
+}
+
+func (e *bleveEngine) Count(db *gorm.DB) (int64, error) {
+	count, err := e.index.DocCount()
+	return int64(count), err
+}
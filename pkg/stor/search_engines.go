@@ -0,0 +1,163 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package stor
+
+import (
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// searchRanked returns a page of the publication IDs selected by the query of a search engine stored in the database,
+// best matches first, and their total number
+func searchRanked(db *gorm.DB, ranked *gorm.DB, offset, limit int) ([]uint, int64, error) {
+	var total int64
+	if err := db.Table("(?) AS matches", ranked).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var ids []uint
+	err := db.Table("(?) AS matches", ranked).Order("score DESC, publication_id DESC").Limit(limit).Offset(offset).
+		Pluck("publication_id", &ids).Error
+	return ids, total, err
+}
+
+// fts5Engine indexes publications in a SQLite FTS5 table; the rowid of a document is the ID of its publication.
+// Results are ranked by bm25, a match in the title weighing more than a match in authors, subjects or description.
+type fts5Engine struct{}
+
+func (fts5Engine) Migrate(db *gorm.DB) error {
+	return db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS publication_fts USING fts5(title, author, subject, description, tokenize = 'unicode61 remove_diacritics 2')`).Error
+}
+
+func (e fts5Engine) Index(tx *gorm.DB, doc *SearchDocument) error {
+	if err := e.Remove(tx, doc.PublicationID); err != nil {
+		return err
+	}
+	return tx.Exec("INSERT INTO publication_fts (rowid, title, author, subject, description) VALUES (?, ?, ?, ?, ?)",
+		doc.PublicationID, doc.Title, doc.Author, doc.Subject, doc.Description).Error
+}
+
+func (fts5Engine) Remove(tx *gorm.DB, publicationID uint) error {
+	return tx.Exec("DELETE FROM publication_fts WHERE rowid = ?", publicationID).Error
+}
+
+func (e fts5Engine) Search(db *gorm.DB, terms []string, offset, limit int) ([]uint, int64, error) {
+	return searchRanked(db, e.Ranked(db, terms), offset, limit)
+}
+
+func (fts5Engine) Ranked(db *gorm.DB, terms []string) *gorm.DB {
+	// every term is a quoted prefix query, terms are implicitly combined with AND
+	quoted := make([]string, len(terms))
+	for i, t := range terms {
+		quoted[i] = `"` + t + `"*`
+	}
+	// bm25 is lower for better matches
+	return db.Raw("SELECT rowid AS publication_id, -bm25(publication_fts, 10.0, 5.0, 3.0, 1.0) AS score FROM publication_fts WHERE publication_fts MATCH ?",
+		strings.Join(quoted, " "))
+}
+
+func (fts5Engine) Count(db *gorm.DB) (int64, error) {
+	var count int64
+	return count, db.Raw("SELECT count(*) FROM publication_fts").Scan(&count).Error
+}
+
+// tsvectorEngine indexes publications in a PostgreSQL tsvector column with a GIN index.
+// The simple configuration is used, as publications are in many languages; documents are folded beforehand.
+// Results are ranked by ts_rank, with weights A (title), B (authors), C (subjects) and D (description).
+type tsvectorEngine struct{}
+
+func (tsvectorEngine) Migrate(db *gorm.DB) error {
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS publication_search (publication_id bigint PRIMARY KEY, document tsvector NOT NULL)`).Error; err != nil {
+		return err
+	}
+	return db.Exec(`CREATE INDEX IF NOT EXISTS idx_publication_search_document ON publication_search USING GIN (document)`).Error
+}
+
+func (tsvectorEngine) Index(tx *gorm.DB, doc *SearchDocument) error {
+	return tx.Exec(`INSERT INTO publication_search (publication_id, document) VALUES (?,
+		setweight(to_tsvector('simple', ?), 'A') || setweight(to_tsvector('simple', ?), 'B') ||
+		setweight(to_tsvector('simple', ?), 'C') || setweight(to_tsvector('simple', ?), 'D'))
+		ON CONFLICT (publication_id) DO UPDATE SET document = EXCLUDED.document`,
+		doc.PublicationID, doc.Title, doc.Author, doc.Subject, doc.Description).Error
+}
+
+func (tsvectorEngine) Remove(tx *gorm.DB, publicationID uint) error {
+	return tx.Exec("DELETE FROM publication_search WHERE publication_id = ?", publicationID).Error
+}
+
+func (e tsvectorEngine) Search(db *gorm.DB, terms []string, offset, limit int) ([]uint, int64, error) {
+	return searchRanked(db, e.Ranked(db, terms), offset, limit)
+}
+
+func (tsvectorEngine) Ranked(db *gorm.DB, terms []string) *gorm.DB {
+	// terms only hold letters and digits; each one is a prefix query
+	prefixes := make([]string, len(terms))
+	for i, t := range terms {
+		prefixes[i] = t + ":*"
+	}
+	return db.Raw(`SELECT publication_id, ts_rank(document, query) AS score FROM publication_search, to_tsquery('simple', ?) query
+		WHERE document @@ query`, strings.Join(prefixes, " & "))
+}
+
+func (tsvectorEngine) Count(db *gorm.DB) (int64, error) {
+	var count int64
+	return count, db.Raw("SELECT count(*) FROM publication_search").Scan(&count).Error
+}
+
+// basicDocument is a folded document, stored by the basic search engine.
+// Each field starts with a space, so that a word prefix is matched by LIKE '% prefix%'.
+type basicDocument struct {
+	PublicationID uint `gorm:"primaryKey;autoIncrement:false"`
+	Title         string
+	Author        string
+	Subject       string
+	Description   string
+}
+
+// basicEngine is a portable search engine, used when no full-text index is available.
+// It scans every document; results are ranked by the fields in which terms are found.
+type basicEngine struct{}
+
+func (basicEngine) Migrate(db *gorm.DB) error {
+	return db.AutoMigrate(&basicDocument{})
+}
+
+func (basicEngine) Index(tx *gorm.DB, doc *SearchDocument) error {
+	return tx.Save(&basicDocument{
+		PublicationID: doc.PublicationID,
+		Title:         " " + doc.Title,
+		Author:        " " + doc.Author,
+		Subject:       " " + doc.Subject,
+		Description:   " " + doc.Description,
+	}).Error
+}
+
+func (basicEngine) Remove(tx *gorm.DB, publicationID uint) error {
+	return tx.Delete(&basicDocument{}, publicationID).Error
+}
+
+func (e basicEngine) Search(db *gorm.DB, terms []string, offset, limit int) ([]uint, int64, error) {
+	return searchRanked(db, e.Ranked(db, terms), offset, limit)
+}
+
+func (basicEngine) Ranked(db *gorm.DB, terms []string) *gorm.DB {
+	var conditions, scores []string
+	var conditionArgs, scoreArgs []interface{}
+	for _, t := range terms {
+		// LIKE wildcards are not in terms, which only hold letters and digits
+		pattern := "% " + t + "%"
+		conditions = append(conditions, "(title LIKE ? OR author LIKE ? OR subject LIKE ? OR description LIKE ?)")
+		conditionArgs = append(conditionArgs, pattern, pattern, pattern, pattern)
+		scores = append(scores, "(CASE WHEN title LIKE ? THEN 8 ELSE 0 END + CASE WHEN author LIKE ? THEN 4 ELSE 0 END + CASE WHEN subject LIKE ? THEN 2 ELSE 0 END + CASE WHEN description LIKE ? THEN 1 ELSE 0 END)")
+		scoreArgs = append(scoreArgs, pattern, pattern, pattern, pattern)
+	}
+	return db.Raw("SELECT publication_id, "+strings.Join(scores, " + ")+" AS score FROM basic_documents WHERE "+strings.Join(conditions, " AND "),
+		append(scoreArgs, conditionArgs...)...)
+}
+
+func (basicEngine) Count(db *gorm.DB) (int64, error) {
+	var count int64
+	return count, db.Model(&basicDocument{}).Count(&count).Error
+}
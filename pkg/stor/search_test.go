@@ -0,0 +1,136 @@
+package stor
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchPublications(t *testing.T) {
+	checkSearchPublications(t)
+}
+
+func TestBleveSearch(t *testing.T) {
+
+	previous := store.search
+	defer func() { store.search = previous }()
+
+	dir := t.TempDir() + "/index"
+	engine, err := NewBleveEngine(dir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = store.SetSearchEngine(engine)
+	assert.NoError(t, err)
+	checkSearchPublications(t)
+
+	// the index is kept on disk
+	publication := &Publication{Title: "Zanzibarie", UUID: uuid.New().String(), ContentType: "application/epub+zip"}
+	err = store.CreatePublication(publication)
+	assert.NoError(t, err)
+	err = engine.(*bleveEngine).index.Close()
+	assert.NoError(t, err)
+	engine, err = NewBleveEngine(dir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	store.search = engine
+	publications, total, err := store.SearchPublications("zanzib", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Len(t, publications, 1)
+
+	err = store.DeletePublication(publication)
+	assert.NoError(t, err)
+	count, err := engine.Count(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+// checkSearchPublications checks the search engine of the store
+func checkSearchPublications(t *testing.T) {
+
+	publication1 := &Publication{
+		Title:       "Les Misérables de Zanzibarie",
+		UUID:        uuid.New().String(),
+		ContentType: "application/epub+zip",
+		Description: "Un roman",
+		Author:      []Author{{Name: "Author A"}},
+		Category:    []Category{{Name: "Category A"}},
+	}
+	publication2 := &Publication{
+		Title:       "Misery",
+		UUID:        uuid.New().String(),
+		ContentType: "application/epub+zip",
+		Description: "A journey to ZANZIBARIE",
+		Author:      []Author{{Name: "Author B"}},
+		Category:    []Category{{Name: "Category B"}},
+	}
+	err := store.CreatePublication(publication1)
+	assert.NoError(t, err)
+	err = store.CreatePublication(publication2)
+	assert.NoError(t, err)
+
+	// case and accents are ignored
+	publications, total, err := store.SearchPublications("MISERABLES zanzibarie", 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	if assert.Len(t, publications, 1) {
+		assert.Equal(t, publication1.UUID, publications[0].UUID)
+	}
+
+	// words are matched as prefixes, a match in the title ranks first
+	publications, _, err = store.SearchPublications("zanzib", 1, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, publications, 2) {
+		assert.Equal(t, publication1.UUID, publications[0].UUID)
+		assert.Equal(t, publication2.UUID, publications[1].UUID)
+	}
+
+	// authors and subjects are searched
+	publications, _, err = store.SearchPublications("zanzibarie author b", 1, 10)
+	assert.NoError(t, err)
+	if assert.Len(t, publications, 1) {
+		assert.Equal(t, publication2.UUID, publications[0].UUID)
+	}
+	publications, _, err = store.SearchPublications("zanzibarie categ", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, publications, 2)
+
+	// pagination
+	publications, total, err = store.SearchPublications("zanzibarie", 2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	if assert.Len(t, publications, 1) {
+		assert.Equal(t, publication2.UUID, publications[0].UUID)
+	}
+
+	// an updated publication is reindexed
+	publication2.Title = "Misery in Zanzibarie"
+	err = store.UpdatePublication(publication2)
+	assert.NoError(t, err)
+	publications, _, err = store.SearchPublications("misery zanzibarie", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, publications, 1)
+
+	// a deleted publication is not found
+	err = store.DeletePublication(publication1)
+	assert.NoError(t, err)
+	publications, _, err = store.SearchPublications("zanzibarie", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, publications, 1)
+
+	err = store.DeletePublication(publication2)
+	assert.NoError(t, err)
+
+	// an empty query finds nothing
+	publications, _, err = store.SearchPublications(" - ", 1, 10)
+	assert.NoError(t, err)
+	assert.Len(t, publications, 0)
+}
+
+func TestFold(t *testing.T) {
+	assert.Equal(t, "les miserables l ete", fold("  Les Misérables, L'Été "))
+	assert.Equal(t, []string{"cafe", "creme"}, searchTerms("CAFÉ-crème"))
+}
@@ -19,7 +19,8 @@ import (
 
 // Store defines a generic store with a gorm db
 type Store struct {
-	db *gorm.DB
+	db     *gorm.DB
+	search SearchEngine
 }
 
 // Init initializes the database
@@ -68,13 +69,23 @@ func Init(dsn string) (Store, error) {
 
 	// db = db.Session(&gorm.Session{FullSaveAssociations: true})
 
-	err = db.AutoMigrate(&Language{}, &Publisher{}, &Author{}, &Category{}, &Publication{}, &User{}, &Transaction{})
+	err = db.AutoMigrate(&Language{}, &Publisher{}, &Author{}, &Category{}, &Publication{}, &User{}, &Transaction{}, &Hold{}, &CoverImage{}, &Contributor{})
 	if err != nil {
 		log.Printf("Failed performing database automigrate: %v", err)
 		return str, err
 	}
 
 	str.db = db
+
+	str.search = newSearchEngine(db, dialect)
+	if err = str.search.Migrate(db); err != nil {
+		log.Printf("Failed creating the search index: %v", err)
+		return str, err
+	}
+	if err = str.checkSearchIndex(); err != nil {
+		log.Printf("Failed indexing publications: %v", err)
+		return str, err
+	}
 	return str, nil
 }
 
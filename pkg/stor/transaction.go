@@ -5,16 +5,74 @@
 package stor
 
 import (
+	"errors"
+	"time"
+
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Types of transaction
+const (
+	TransactionTypePurchase     = "purchase"
+	TransactionTypeLoan         = "loan"
+	TransactionTypeSubscription = "subscription"
 )
 
+// endedStatuses are the license statuses, set by the License Status Server, of a loan which does not hold a copy anymore
+var endedStatuses = []string{"returned", "revoked", "cancelled", "expired"}
+
+// ErrNoCopyAvailable is returned when all the copies of a publication are on loan
+var ErrNoCopyAvailable = errors.New("no copy of this publication is available for loan")
+
+// ErrNotLoan is returned when a purchase or a subscription is returned or renewed
+var ErrNotLoan = errors.New("only a loan can be returned or renewed")
+
+// ErrLoanOngoing is returned when a user borrows a publication already on loan to them
+var ErrLoanOngoing = errors.New("this publication is already on loan to the user")
+
+// Transaction records the acquisition of a publication by a user, with the metadata of the license issued.
+// StartDate and EndDate bound the rights of the license; they are nil if unconstrained.
+// PrintLimit and CopyLimit are the rights of the license; they are nil if unlimited.
+// Status is the last known status of the license, as set by the License Status Server.
+// StatusUrl is the url of the status document of the license, PublicationUrl the url of the encrypted publication.
 type Transaction struct {
 	gorm.Model
-	UserID        uint // implicit foreign key to the related user
-	User          User
-	PublicationID uint // implicit foreign key to the related publication
-	Publication   Publication
-	LicenceId     string
+	UserID         uint        `json:"-"` // implicit foreign key to the related user
+	User           User        `json:"-"`
+	PublicationID  uint        `json:"-"` // implicit foreign key to the related publication
+	Publication    Publication `json:"-"`
+	LicenceId      string      `json:"licence_id"`
+	Type           string      `json:"type" gorm:"index"`
+	Issued         *time.Time  `json:"issued,omitempty"`
+	PrintLimit     *int        `json:"print_limit,omitempty"`
+	CopyLimit      *int        `json:"copy_limit,omitempty"`
+	StartDate      *time.Time  `json:"start_date,omitempty"`
+	EndDate        *time.Time  `json:"end_date,omitempty"`
+	Status         string      `json:"status,omitempty"`
+	StatusUrl      string      `json:"status_url,omitempty"`
+	PublicationUrl string      `json:"publication_url,omitempty"`
+}
+
+// SetPeriod sets the start and end dates of a transaction; zero dates are considered void
+func (t *Transaction) SetPeriod(start, end time.Time) {
+	t.StartDate = nil
+	t.EndDate = nil
+	if !start.IsZero() {
+		t.StartDate = &start
+	}
+	if !end.IsZero() {
+		t.EndDate = &end
+	}
+}
+
+// UpdateTransactionStatus updates the last known status of a transaction, if it has changed
+func (s *Store) UpdateTransactionStatus(transaction *Transaction, status string) error {
+	if status == "" || status == transaction.Status {
+		return nil
+	}
+	transaction.Status = status
+	return s.db.Model(&Transaction{}).Where("id = ?", transaction.ID).Update("status", status).Error
 }
 
 // CreateTransaction creates a new transaction
@@ -22,9 +80,10 @@ func (s *Store) CreateTransaction(transaction *Transaction) error {
 	return s.db.Create(transaction).Error
 }
 
-// UpdateTransaction updates a transaction
+// UpdateTransaction updates a transaction.
+// The associated user and publication are not updated.
 func (s *Store) UpdateTransaction(transaction *Transaction) error {
-	return s.db.Save(transaction).Error
+	return s.db.Omit(clause.Associations).Save(transaction).Error
 }
 
 // GetTransactionByLicense retrieves a transaction using its licenseID
@@ -45,6 +104,118 @@ func (s *Store) FindTransactionsByUser(userID uint) (*[]Transaction, error) {
 	return &transaction, s.db.Preload("User").Preload("Publication").Where("user_id = ?", userID).Order("created_at DESC").Find(&transaction).Error
 }
 
+// ListTransactions retrieves all transactions, with pagination
+func (s *Store) ListTransactions(page, pageSize int) ([]Transaction, error) {
+	var transactions []Transaction
+	// page starts at 1, pageSize >= 1
+	offset := (page - 1) * pageSize
+	if offset < 0 {
+		return transactions, errors.New("invalid pagination")
+	}
+	// result sorted to assure the same order for each request
+	return transactions, s.db.Preload("User").Preload("Publication").Order("created_at DESC").Order("id DESC").Offset(offset).Limit(pageSize).Find(&transactions).Error
+}
+
+// FindTransactionsByType retrieves transactions of a given type, with pagination
+func (s *Store) FindTransactionsByType(transactionType string, page, pageSize int) ([]Transaction, error) {
+	var transactions []Transaction
+	offset := (page - 1) * pageSize
+	if offset < 0 {
+		return transactions, errors.New("invalid pagination")
+	}
+	return transactions, s.db.Preload("User").Preload("Publication").Where("type = ?", transactionType).Order("created_at DESC").Order("id DESC").Offset(offset).Limit(pageSize).Find(&transactions).Error
+}
+
+// CountTransactionsByType returns the count of transactions of a given type
+func (s *Store) CountTransactionsByType(transactionType string) (int64, error) {
+	var count int64
+	return count, s.db.Model(Transaction{}).Where("type = ?", transactionType).Count(&count).Error
+}
+
+// CountOngoingLoans returns the count of loans of a publication which have not ended yet.
+// A loan has ended when its end date is past, or when its license has been returned, revoked or cancelled,
+// e.g. from a reading application, before its end date.
+func (s *Store) CountOngoingLoans(publicationID uint) (int64, error) {
+	var count int64
+	return count, s.ongoingLoans().Where("publication_id = ?", publicationID).Count(&count).Error
+}
+
+// ongoingLoans returns a query on the loans which have not ended yet
+func (s *Store) ongoingLoans() *gorm.DB {
+	return s.db.Model(Transaction{}).Where("type = ?", TransactionTypeLoan).
+		Where("end_date IS NULL OR end_date > ?", time.Now().UTC()).
+		Where("status IS NULL OR status NOT IN ?", endedStatuses)
+}
+
+// AvailableCopies returns the number of copies of a publication which can still be lent.
+// Copies kept for users whose hold is ready are not available.
+// It returns -1 if the number of copies of the publication is unlimited.
+func (s *Store) AvailableCopies(publication *Publication) (int64, error) {
+	if publication.Copies == 0 {
+		return -1, nil
+	}
+	loans, err := s.CountOngoingLoans(publication.ID)
+	if err != nil {
+		return 0, err
+	}
+	readyHolds, err := s.countReadyHolds(publication.ID)
+	if err != nil {
+		return 0, err
+	}
+	if available := int64(publication.Copies) - loans - readyHolds; available > 0 {
+		return available, nil
+	}
+	return 0, nil
+}
+
+// CheckCopyAvailable returns ErrNoCopyAvailable if no copy of the publication is left for a new loan by a user.
+// A user whose hold is ready can borrow the copy kept for them.
+func (s *Store) CheckCopyAvailable(publication *Publication, userID uint) error {
+	if hold, err := s.GetHold(userID, publication.ID); err == nil && hold.Status == HoldStatusReady {
+		return nil
+	}
+	available, err := s.AvailableCopies(publication)
+	if err != nil {
+		return err
+	}
+	if available == 0 {
+		return ErrNoCopyAvailable
+	}
+	return nil
+}
+
+// CreateLoan creates a loan transaction if a copy of its publication is available for its user,
+// and returns ErrNoCopyAvailable otherwise, or ErrLoanOngoing if the user already has an ongoing loan of the publication.
+// The checks and the creation are done in a database transaction which locks the publication,
+// so that concurrent loans cannot exceed the number of copies.
+func (s *Store) CreateLoan(transaction *Transaction) error {
+	transaction.Type = TransactionTypeLoan
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		// a write on the publication row locks it until the end of the transaction, whatever the database
+		err := tx.Model(&Publication{}).Where("id = ?", transaction.PublicationID).UpdateColumn("copies", gorm.Expr("copies")).Error
+		if err != nil {
+			return err
+		}
+		var publication Publication
+		if err = tx.First(&publication, transaction.PublicationID).Error; err != nil {
+			return err
+		}
+		locked := Store{db: tx, search: s.search}
+		var ongoing int64
+		err = locked.ongoingLoans().Where("publication_id = ? AND user_id = ?", transaction.PublicationID, transaction.UserID).Count(&ongoing).Error
+		if err != nil {
+			return err
+		}
+		if ongoing > 0 {
+			return ErrLoanOngoing
+		}
+		if err = locked.CheckCopyAvailable(&publication, transaction.UserID); err != nil {
+			return err
+		}
+		return tx.Omit(clause.Associations).Create(transaction).Error
+	})
+}
+
 // DeleteTransaction deletes a transaction
 func (s *Store) DeleteTransaction(transaction *Transaction) error {
 	return s.db.Delete(transaction).Error
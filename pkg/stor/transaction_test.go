@@ -5,7 +5,10 @@
 package stor
 
 import (
+	"errors"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/brianvoe/gofakeit/v6"
 	"github.com/stretchr/testify/assert"
@@ -61,7 +64,16 @@ func TestTransactionCRUD(t *testing.T) {
 		UserID:        user.ID,
 		PublicationID: publication.ID,
 		LicenceId:     gofakeit.UUID(),
+		Type:          TransactionTypeLoan,
 	}
+	start := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	transaction.SetPeriod(start, start.AddDate(0, 0, 30))
+	printLimit := 10
+	transaction.Issued = &start
+	transaction.PrintLimit = &printLimit
+	transaction.StatusUrl = "https://lsd.example.com/licenses/1/status"
+	transaction.PublicationUrl = "https://cdn.example.com/publication.epub"
+	transaction.Status = "ready"
 
 	err = store.CreateTransaction(transaction)
 	assert.NoError(t, err)
@@ -74,6 +86,34 @@ func TestTransactionCRUD(t *testing.T) {
 	assert.Equal(t, transaction.UserID, readTransaction.UserID)
 	assert.Equal(t, transaction.PublicationID, readTransaction.PublicationID)
 	assert.Equal(t, transaction.LicenceId, readTransaction.LicenceId)
+	assert.Equal(t, TransactionTypeLoan, readTransaction.Type)
+	if assert.NotNil(t, readTransaction.EndDate) {
+		assert.True(t, transaction.EndDate.Equal(*readTransaction.EndDate))
+	}
+	if assert.NotNil(t, readTransaction.Issued) {
+		assert.True(t, start.Equal(*readTransaction.Issued))
+	}
+	if assert.NotNil(t, readTransaction.PrintLimit) {
+		assert.Equal(t, 10, *readTransaction.PrintLimit)
+	}
+	assert.Nil(t, readTransaction.CopyLimit)
+	assert.Equal(t, transaction.StatusUrl, readTransaction.StatusUrl)
+	assert.Equal(t, transaction.PublicationUrl, readTransaction.PublicationUrl)
+
+	// update the last known status
+	err = store.UpdateTransactionStatus(readTransaction, "active")
+	assert.NoError(t, err)
+	readTransaction, err = store.GetTransactionByLicence(transaction.LicenceId)
+	assert.NoError(t, err)
+	assert.Equal(t, "active", readTransaction.Status)
+
+	// find the transaction by type
+	loans, err := store.FindTransactionsByType(TransactionTypeLoan, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(loans))
+	purchaseCount, err := store.CountTransactionsByType(TransactionTypePurchase)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, int(purchaseCount))
 
 	// update the transaction
 	transaction.LicenceId = gofakeit.UUID()
@@ -115,3 +155,214 @@ func TestTransactionCRUD(t *testing.T) {
 	err = store.DeleteUser(user)
 	assert.NoError(t, err)
 }
+
+func TestAvailableCopies(t *testing.T) {
+
+	user := &User{
+		UUID:       gofakeit.UUID(),
+		Name:       "Jeanne d'Arc",
+		Email:      gofakeit.Email(),
+		Password:   "password",
+		TextHint:   "hint",
+		Passphrase: "passphrase",
+	}
+	err := store.CreateUser(user)
+	assert.NoError(t, err)
+
+	// a publication with 2 copies
+	publication := &Publication{
+		Title:  "Test Publication",
+		UUID:   gofakeit.UUID(),
+		Copies: 2,
+	}
+	err = store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	available, err := store.AvailableCopies(publication)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, int(available))
+
+	// an ongoing loan, a past loan and a purchase
+	now := time.Now().UTC().Truncate(time.Second)
+	ongoing := &Transaction{UserID: user.ID, PublicationID: publication.ID, LicenceId: gofakeit.UUID(), Type: TransactionTypeLoan}
+	ongoing.SetPeriod(now, now.AddDate(0, 0, 30))
+	past := &Transaction{UserID: user.ID, PublicationID: publication.ID, LicenceId: gofakeit.UUID(), Type: TransactionTypeLoan}
+	past.SetPeriod(now.AddDate(0, 0, -60), now.AddDate(0, 0, -30))
+	purchase := &Transaction{UserID: user.ID, PublicationID: publication.ID, LicenceId: gofakeit.UUID(), Type: TransactionTypePurchase}
+	for _, transaction := range []*Transaction{ongoing, past, purchase} {
+		err = store.CreateTransaction(transaction)
+		assert.NoError(t, err)
+	}
+
+	loans, err := store.CountOngoingLoans(publication.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, int(loans))
+	err = store.CheckCopyAvailable(publication, user.ID)
+	assert.NoError(t, err)
+
+	// a loan returned from a reading application before its end date does not hold a copy
+	returned := &Transaction{UserID: user.ID, PublicationID: publication.ID, LicenceId: gofakeit.UUID(), Type: TransactionTypeLoan, Status: "active"}
+	returned.SetPeriod(now, now.AddDate(0, 0, 30))
+	err = store.CreateTransaction(returned)
+	assert.NoError(t, err)
+	loans, err = store.CountOngoingLoans(publication.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, int(loans))
+	for _, status := range []string{"returned", "revoked", "cancelled"} {
+		err = store.UpdateTransactionStatus(returned, status)
+		assert.NoError(t, err)
+		loans, err = store.CountOngoingLoans(publication.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, int(loans), status)
+	}
+
+	// a loan with no end date takes the last copy
+	unlimited := &Transaction{UserID: user.ID, PublicationID: publication.ID, LicenceId: gofakeit.UUID(), Type: TransactionTypeLoan}
+	err = store.CreateTransaction(unlimited)
+	assert.NoError(t, err)
+
+	available, err = store.AvailableCopies(publication)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, int(available))
+	err = store.CheckCopyAvailable(publication, user.ID)
+	assert.ErrorIs(t, err, ErrNoCopyAvailable)
+
+	// no copy count means unlimited loans
+	publication.Copies = 0
+	available, err = store.AvailableCopies(publication)
+	assert.NoError(t, err)
+	assert.Equal(t, -1, int(available))
+
+	// clean up test data
+	for _, transaction := range []*Transaction{ongoing, past, purchase, returned, unlimited} {
+		err = store.DeleteTransaction(transaction)
+		assert.NoError(t, err)
+	}
+	err = store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = store.DeleteUser(user)
+	assert.NoError(t, err)
+}
+
+func TestCreateLoan(t *testing.T) {
+
+	user := &User{
+		UUID:       gofakeit.UUID(),
+		Name:       "Jean Valjean",
+		Email:      gofakeit.Email(),
+		Password:   "password",
+		TextHint:   "hint",
+		Passphrase: "passphrase",
+	}
+	err := store.CreateUser(user)
+	assert.NoError(t, err)
+
+	publication := &Publication{
+		Title:  "Single loan",
+		UUID:   gofakeit.UUID(),
+		Copies: 3,
+	}
+	err = store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	first := &Transaction{UserID: user.ID, PublicationID: publication.ID, LicenceId: gofakeit.UUID()}
+	err = store.CreateLoan(first)
+	assert.NoError(t, err)
+	assert.Equal(t, TransactionTypeLoan, first.Type)
+
+	// a user cannot borrow a second copy of the publication
+	second := &Transaction{UserID: user.ID, PublicationID: publication.ID, LicenceId: gofakeit.UUID()}
+	err = store.CreateLoan(second)
+	assert.ErrorIs(t, err, ErrLoanOngoing)
+
+	// but can borrow it again once the loan is returned
+	err = store.UpdateTransactionStatus(first, "returned")
+	assert.NoError(t, err)
+	err = store.CreateLoan(second)
+	assert.NoError(t, err)
+
+	// clean up test data
+	for _, transaction := range []*Transaction{first, second} {
+		err = store.DeleteTransaction(transaction)
+		assert.NoError(t, err)
+	}
+	err = store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = store.DeleteUser(user)
+	assert.NoError(t, err)
+}
+
+func TestCreateLoanConcurrently(t *testing.T) {
+
+	// a publication with 3 copies, requested by 20 concurrent loans of different users
+	const requests = 20
+	users := make([]*User, requests)
+	for i := range users {
+		users[i] = &User{
+			UUID:       gofakeit.UUID(),
+			Name:       "Jean Valjean",
+			Email:      gofakeit.Email(),
+			Password:   "password",
+			TextHint:   "hint",
+			Passphrase: "passphrase",
+		}
+		err := store.CreateUser(users[i])
+		assert.NoError(t, err)
+	}
+
+	publication := &Publication{
+		Title:  "Concurrent loans",
+		UUID:   gofakeit.UUID(),
+		Copies: 3,
+	}
+	err := store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	results := make([]error, requests)
+	loans := make([]*Transaction, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// a locked database is retried, as a client would do
+			for attempt := 0; attempt < 100; attempt++ {
+				loans[i] = &Transaction{UserID: users[i].ID, PublicationID: publication.ID, LicenceId: gofakeit.UUID()}
+				results[i] = store.CreateLoan(loans[i])
+				if results[i] == nil || errors.Is(results[i], ErrNoCopyAvailable) {
+					return
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for i, err := range results {
+		if err == nil {
+			created++
+			assert.Equal(t, TransactionTypeLoan, loans[i].Type)
+		} else {
+			assert.ErrorIs(t, err, ErrNoCopyAvailable)
+		}
+	}
+	assert.Equal(t, 3, created)
+	count, err := store.CountOngoingLoans(publication.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, int(count))
+
+	// clean up test data
+	for i, err := range results {
+		if err == nil {
+			err = store.DeleteTransaction(loans[i])
+			assert.NoError(t, err)
+		}
+	}
+	err = store.DeletePublication(publication)
+	assert.NoError(t, err)
+	for _, user := range users {
+		err = store.DeleteUser(user)
+		assert.NoError(t, err)
+	}
+}
@@ -59,13 +59,14 @@ func (u *User) BeforeSave(tx *gorm.DB) error {
 	return nil
 }
 
-// BeforeCreate creates user uuid if missing
+// BeforeCreate creates user uuid if missing.
+// Users imported from another pubstore may be created with the hashes of their password and passphrase.
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 
-	if u.Password == "" {
+	if u.Password == "" && u.HPassword == "" {
 		return errors.New("missing user authentication password")
 	}
-	if u.Passphrase == "" {
+	if u.Passphrase == "" && u.HPassphrase == "" {
 		return errors.New("missing user LCP passphrase")
 	}
 	// generate a user UUID if empty
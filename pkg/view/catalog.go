@@ -6,6 +6,8 @@ package view
 
 import (
 	"fmt"
+	"net/url"
+	"strconv"
 
 	"github.com/edrlab/pubstore/pkg/stor"
 )
@@ -18,11 +20,22 @@ type PublicationCatalogView struct {
 	Format    string
 }
 
+// FacetValueView is a facet value of the publications of the catalog
+type FacetValueView struct {
+	Value    string
+	Count    int64
+	Href     string // catalog link which adds the value to the current filters, or removes it if selected
+	Selected bool
+}
+
 type FacetsView struct {
-	Authors    []string
-	Publishers []string
-	Languages  []string
-	Categories []string
+	Formats    []FacetValueView
+	Authors    []FacetValueView
+	Publishers []FacetValueView
+	Languages  []FacetValueView
+	Categories []FacetValueView
+	// Filters are the current filters; their link removes them
+	Filters []FacetValueView
 }
 
 type CatalogView struct {
@@ -30,175 +43,200 @@ type CatalogView struct {
 	Publications   []PublicationCatalogView
 	NbPages        string
 	NbPublications string
+	Pagination     PaginationView
 }
 
-func (view *View) GetCatalogFacetsView() *FacetsView {
-	var facets FacetsView
+// PageLinkView is a link to a page of the catalog
+type PageLinkView struct {
+	Number  int
+	Href    string
+	Current bool
+}
 
-	if authorArray, err := view.Store.GetAuthors(); err != nil {
-		fmt.Println(err)
-		facets.Authors = make([]string, 0)
-	} else {
-		facets.Authors = make([]string, len(authorArray))
-		for i, element := range authorArray {
-			facets.Authors[i] = element.Name
-		}
-	}
+// PaginationView holds the links to the pages of the catalog; First, Prev, Next and Last are empty
+// if there is no such page. Pages are the pages around the current one.
+type PaginationView struct {
+	First string
+	Prev  string
+	Next  string
+	Last  string
+	Pages []PageLinkView
+}
 
-	if publisherArray, err := view.Store.GetPublishers(); err != nil {
-		fmt.Println(err)
-		facets.Publishers = make([]string, 0)
-	} else {
-		facets.Publishers = make([]string, len(publisherArray))
-		for i, element := range publisherArray {
-			facets.Publishers[i] = element.Name
-		}
-	}
+// pageWindow is the number of pages linked before and after the current page
+const pageWindow = 4
 
-	if languageArray, err := view.Store.GetLanguages(); err != nil {
-		fmt.Println(err)
-		facets.Languages = make([]string, 0)
-	} else {
-		facets.Languages = make([]string, len(languageArray))
-		for i, element := range languageArray {
-			facets.Languages[i] = element.Code
-		}
+// CatalogFilter holds the filters of the catalog, which are combined; empty filters are ignored
+type CatalogFilter struct {
+	Format    string
+	Author    string
+	Publisher string
+	Language  string
+	Category  string
+	Text      string
+	// PageSize is the page size given in the query string, if any, which is kept in catalog links
+	PageSize string
+}
+
+// catalogParams are the query parameters of the filters of the catalog
+var catalogParams = []string{"format", "author", "publisher", "language", "category", "q"}
+
+// CatalogFilterFromQuery returns the filters set in the query string of a catalog request
+func CatalogFilterFromQuery(query url.Values) CatalogFilter {
+	text := query.Get("query")
+	if text == "" {
+		text = query.Get("q")
+	}
+	return CatalogFilter{
+		Format:    query.Get("format"),
+		Author:    query.Get("author"),
+		Publisher: query.Get("publisher"),
+		Language:  query.Get("language"),
+		Category:  query.Get("category"),
+		Text:      text,
+		PageSize:  query.Get("pageSize"),
 	}
+}
 
-	if categoryArray, err := view.Store.GetCategories(); err != nil {
-		fmt.Println(err)
-		facets.Categories = make([]string, 0)
-	} else {
-		facets.Categories = make([]string, len(categoryArray))
-		for i, element := range categoryArray {
-			facets.Categories[i] = element.Name
+// Values returns the filters and page size as query parameters
+func (f CatalogFilter) Values() url.Values {
+	values := url.Values{}
+	for i, v := range []string{f.Format, f.Author, f.Publisher, f.Language, f.Category, f.Text} {
+		if v != "" {
+			values.Set(catalogParams[i], v)
 		}
 	}
-
-	return &facets
+	if f.PageSize != "" {
+		values.Set("pageSize", f.PageSize)
+	}
+	return values
 }
 
-func (view *View) GetCatalogPublicationsView(facet string, value string, page int, pageSize int) (*[]PublicationCatalogView, int64) {
+// Href returns the catalog link with the filters set, at a given page (the first page if 0)
+func (f CatalogFilter) Href(page int) string {
+	values := f.Values()
+	if page > 1 {
+		values.Set("page", strconv.Itoa(page))
+	}
+	return catalogHref(values)
+}
 
-	var publications []PublicationCatalogView
-	var pubs []stor.Publication
-	var err error
+// catalogHref returns the catalog link with query parameters
+func catalogHref(values url.Values) string {
+	if len(values) == 0 {
+		return "/catalog"
+	}
+	return "/catalog?" + values.Encode()
+}
 
-	switch facet {
-	case "format":
-		contentType := formatToContentType(value)
-		if pubs, err = view.Store.FindPublicationsByType(contentType, page, pageSize); err != nil {
-			publications = make([]PublicationCatalogView, 0)
+// facetValues returns the views of the values of a facet, given by its query parameter
+func (f CatalogFilter) facetValues(param string, counts []stor.FacetCount, label func(string) string) []FacetValueView {
+	current := f.Values().Get(param)
+	views := make([]FacetValueView, len(counts))
+	for i, c := range counts {
+		value := label(c.Value)
+		values := f.Values()
+		if value == current {
+			values.Del(param)
 		} else {
-			publications = make([]PublicationCatalogView, len(pubs))
-			for i, element := range pubs {
-				var author string
-				if len(element.Author) > 0 {
-					author = element.Author[0].Name
-				}
-				publications[i] = PublicationCatalogView{CoverHref: element.CoverUrl, Title: element.Title, Author: author, UUID: element.UUID, Format: value}
-			}
-		}
-	case "author":
-		if pubs, err = view.Store.FindPublicationsByAuthor(value, page, pageSize); err != nil {
-			publications = make([]PublicationCatalogView, 0)
-		} else {
-			publications = make([]PublicationCatalogView, len(pubs))
-			for i, element := range pubs {
-				publications[i] = PublicationCatalogView{CoverHref: element.CoverUrl, Title: element.Title, Author: element.Author[0].Name, UUID: element.UUID, Format: contentTypeToFormat(element.ContentType)}
-			}
+			values.Set(param, value)
 		}
+		views[i] = FacetValueView{Value: value, Count: c.Count, Href: catalogHref(values), Selected: value == current}
+	}
+	return views
+}
 
-	case "publisher":
-		if pubs, err = view.Store.FindPublicationsByPublisher(value, page, pageSize); err != nil {
-			publications = make([]PublicationCatalogView, 0)
-		} else {
-			publications = make([]PublicationCatalogView, len(pubs))
-			for i, element := range pubs {
-				var author = ""
-				if len(element.Author) > 0 {
-					author = element.Author[0].Name
-				}
-				publications[i] = PublicationCatalogView{CoverHref: element.CoverUrl, Title: element.Title, Author: author, UUID: element.UUID, Format: contentTypeToFormat(element.ContentType)}
-			}
-		}
+// GetCatalogPublicationsView returns a page of the publications matching every filter,
+// the total number of matching publications, and the facet values of these publications.
+func (view *View) GetCatalogPublicationsView(filter CatalogFilter, page int, pageSize int) (*[]PublicationCatalogView, int64, *FacetsView) {
 
-	case "language":
-		if pubs, err = view.Store.FindPublicationsByLanguage(value, page, pageSize); err != nil {
-			publications = make([]PublicationCatalogView, 0)
-		} else {
-			publications = make([]PublicationCatalogView, len(pubs))
-			for i, element := range pubs {
-				var author = ""
-				if len(element.Author) > 0 {
-					author = element.Author[0].Name
-				}
-				publications[i] = PublicationCatalogView{CoverHref: element.CoverUrl, Title: element.Title, Author: author, UUID: element.UUID, Format: contentTypeToFormat(element.ContentType)}
-			}
-		}
+	query := &stor.PublicationQuery{
+		Author:    filter.Author,
+		Publisher: filter.Publisher,
+		Language:  filter.Language,
+		Category:  filter.Category,
+		Text:      filter.Text,
+	}
+	if filter.Format != "" {
+		query.ContentType = formatToContentType(filter.Format)
+	}
 
-	case "category":
-		if pubs, err = view.Store.FindPublicationsByCategory(value, page, pageSize); err != nil {
-			publications = make([]PublicationCatalogView, 0)
-		} else {
-			publications = make([]PublicationCatalogView, len(pubs))
-			for i, element := range pubs {
-				var author = ""
-				if len(element.Author) > 0 {
-					author = element.Author[0].Name
-				}
-				publications[i] = PublicationCatalogView{CoverHref: element.CoverUrl, Title: element.Title, Author: author, UUID: element.UUID, Format: contentTypeToFormat(element.ContentType)}
-			}
+	// the current filters, including the ones which are not facet values, e.g. the text of a search
+	facets := &FacetsView{}
+	for _, param := range catalogParams {
+		values := filter.Values()
+		if value := values.Get(param); value != "" {
+			values.Del(param)
+			facets.Filters = append(facets.Filters, FacetValueView{Value: value, Href: catalogHref(values), Selected: true})
 		}
+	}
 
-	case "search":
-		if pubs, err = view.Store.FindPublicationsByTitle(value, page, pageSize); err != nil {
-			publications = make([]PublicationCatalogView, 0)
-		} else {
-			publications = make([]PublicationCatalogView, len(pubs))
-			for i, element := range pubs {
-				var author = ""
-				if len(element.Author) > 0 {
-					author = element.Author[0].Name
-				}
-				publications[i] = PublicationCatalogView{CoverHref: element.CoverUrl, Title: element.Title, Author: author, UUID: element.UUID, Format: contentTypeToFormat(element.ContentType)}
-			}
-		}
+	result, err := view.Store.QueryPublications(query, page, pageSize)
+	if err != nil {
+		fmt.Println(err)
+		publications := make([]PublicationCatalogView, 0)
+		return &publications, 0, facets
+	}
 
-	default:
-		if pubs, err = view.Store.ListPublications(page, pageSize); err != nil {
-			publications = make([]PublicationCatalogView, 0)
-		} else {
-			publications = make([]PublicationCatalogView, len(pubs))
-			for i, element := range pubs {
-				var author = ""
-				if len(element.Author) > 0 {
-					author = element.Author[0].Name
-				}
-				publications[i] = PublicationCatalogView{CoverHref: element.CoverUrl, Title: element.Title, Author: author, UUID: element.UUID, Format: contentTypeToFormat(element.ContentType)}
-			}
+	publications := make([]PublicationCatalogView, len(result.Publications))
+	for i, element := range result.Publications {
+		var author = ""
+		if len(element.Author) > 0 {
+			author = element.Author[0].Name
 		}
+		publications[i] = PublicationCatalogView{CoverHref: element.CoverUrl, Title: element.Title, Author: author, UUID: element.UUID, Format: contentTypeToFormat(element.ContentType)}
 	}
 
-	return &publications, int64(len(publications))
+	same := func(v string) string { return v }
+	facets.Formats = filter.facetValues("format", result.Facets.ContentType, contentTypeToFormat)
+	facets.Authors = filter.facetValues("author", result.Facets.Author, same)
+	facets.Publishers = filter.facetValues("publisher", result.Facets.Publisher, same)
+	facets.Languages = filter.facetValues("language", result.Facets.Language, same)
+	facets.Categories = filter.facetValues("category", result.Facets.Category, same)
+
+	return &publications, result.Total, facets
 }
 
-func GetCatalogView(pubs *[]PublicationCatalogView, facets *FacetsView) *CatalogView {
+// GetCatalogView returns the view of a page of the catalog, given the total number of publications matching its filters
+func GetCatalogView(pubs *[]PublicationCatalogView, facets *FacetsView, filter CatalogFilter, count int64, page int, pageSize int) *CatalogView {
 
 	var catalogView CatalogView
 
-	catalogView.Authors = facets.Authors
-	catalogView.Categories = facets.Categories
-	catalogView.Languages = facets.Languages
-	catalogView.Publishers = facets.Publishers
+	catalogView.FacetsView = *facets
 	catalogView.Publications = make([]PublicationCatalogView, len(*pubs))
 	for i, element := range *pubs {
 		catalogView.Publications[i] = PublicationCatalogView{CoverHref: element.CoverHref, Title: element.Title, Author: element.Author, UUID: element.UUID, Format: element.Format}
 	}
 
+	nbPages := int((count + int64(pageSize) - 1) / int64(pageSize))
+	if nbPages == 0 {
+		nbPages = 1
+	}
+	catalogView.NbPages = strconv.Itoa(nbPages)
+	catalogView.NbPublications = strconv.FormatInt(count, 10)
+	catalogView.Pagination = getPaginationView(filter, page, nbPages)
+
 	return &catalogView
 }
 
+// getPaginationView returns the links to the pages of the catalog
+func getPaginationView(filter CatalogFilter, page int, nbPages int) PaginationView {
+	var pagination PaginationView
+
+	if page > 1 {
+		pagination.First = filter.Href(1)
+		pagination.Prev = filter.Href(min(page-1, nbPages))
+	}
+	if page < nbPages {
+		pagination.Next = filter.Href(page + 1)
+		pagination.Last = filter.Href(nbPages)
+	}
+	for i := max(1, page-pageWindow); i <= min(nbPages, page+pageWindow); i++ {
+		pagination.Pages = append(pagination.Pages, PageLinkView{Number: i, Href: filter.Href(i), Current: i == page})
+	}
+	return pagination
+}
+
 func contentTypeToFormat(contentType string) string {
 	switch contentType {
 	case "application/epub+zip":
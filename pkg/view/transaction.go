@@ -1,6 +1,7 @@
 package view
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/edrlab/pubstore/pkg/lcp"
@@ -10,6 +11,7 @@ import (
 type TransactionView struct {
 	// TransactionID             string
 	// TransactionDate           time.Time
+	TransactionType           string
 	PublicationUUID           string
 	PublicationTitle          string
 	PublicationAuthor         string
@@ -21,9 +23,43 @@ type TransactionView struct {
 	LicenseStatusMessage      string
 	LicenseStatusCode         string
 	LicenseEndPotentialRights string
+	// Renewable indicates a loan with an end date, which can be extended
+	Renewable bool
 }
 
-func (view *View) GetTransactionViewFromTransactionStor(transaction *stor.Transaction) *TransactionView {
+// GetTransactionViewFromTransactionStor converts a transaction; its recorded status is displayed if the status document cannot be fetched
+func (view *View) GetTransactionViewFromTransactionStor(ctx context.Context, transaction *stor.Transaction) *TransactionView {
+
+	lsdStatus, err := view.LCP.GetStatusDocument(ctx, transaction)
+	if err != nil {
+		fmt.Println("LSD STATUS Error from (" + transaction.LicenceId + "): " + err.Error())
+		lsdStatus = lcp.RecordedStatus(transaction, err)
+	}
+	if err = view.Store.UpdateTransactionStatus(transaction, lsdStatus.StatusCode); err != nil {
+		fmt.Println("Failed to update the transaction status: " + err.Error())
+	}
+	return view.newTransactionView(transaction, lsdStatus)
+}
+
+// GetTransactionViewsFromTransactionsStor converts a list of transactions; their status documents are fetched concurrently
+func (view *View) GetTransactionViewsFromTransactionsStor(ctx context.Context, transactions []stor.Transaction) []*TransactionView {
+
+	lsdStatus, err := view.LCP.GetStatusDocuments(ctx, transactions)
+	if err != nil {
+		fmt.Println("LSD STATUS Error: " + err.Error())
+	}
+
+	transactionsView := make([]*TransactionView, len(transactions))
+	for i := range transactions {
+		if err = view.Store.UpdateTransactionStatus(&transactions[i], lsdStatus[i].StatusCode); err != nil {
+			fmt.Println("Failed to update the transaction status: " + err.Error())
+		}
+		transactionsView[i] = view.newTransactionView(&transactions[i], lsdStatus[i])
+	}
+	return transactionsView
+}
+
+func (view *View) newTransactionView(transaction *stor.Transaction, lsdStatus *lcp.LsdStatus) *TransactionView {
 
 	var publicationAuthor string
 	publication, err := view.Store.GetPublication(transaction.Publication.UUID)
@@ -31,14 +67,8 @@ func (view *View) GetTransactionViewFromTransactionStor(transaction *stor.Transa
 		publicationAuthor = publication.Author[0].Name
 	}
 
-	// TODO: avoid fetching the Status Document in this function
-	lsdStatus, err := lcp.GetStatusDocument(view.Config.LCPServer, transaction)
-	if err != nil {
-		fmt.Println("LSD STATUS Error from (" + transaction.LicenceId + ")")
-		lsdStatus = &lcp.LsdStatus{}
-	}
-
 	return &TransactionView{
+		TransactionType:           transaction.Type,
 		PublicationUUID:           transaction.Publication.UUID,
 		PublicationTitle:          transaction.Publication.Title,
 		PublicationAuthor:         publicationAuthor,
@@ -50,5 +80,6 @@ func (view *View) GetTransactionViewFromTransactionStor(transaction *stor.Transa
 		LicenseStatusMessage:      lsdStatus.StatusMessage,
 		LicenseStatusCode:         lsdStatus.StatusCode,
 		LicenseEndPotentialRights: lsdStatus.EndPotentialRights.Format("2006-01-02 15:04:05"),
+		Renewable:                 transaction.Type == stor.TransactionTypeLoan && transaction.EndDate != nil,
 	}
 }
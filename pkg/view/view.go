@@ -2,17 +2,20 @@ package view
 
 import (
 	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/lcp"
 	"github.com/edrlab/pubstore/pkg/stor"
 )
 
 type View struct {
 	*conf.Config
 	*stor.Store
+	LCP lcp.LicenseProvider
 }
 
-func Init(c *conf.Config, s *stor.Store) View {
+func Init(c *conf.Config, s *stor.Store, lp lcp.LicenseProvider) View {
 	return View{
 		Config: c,
 		Store:  s,
+		LCP:    lp,
 	}
 }
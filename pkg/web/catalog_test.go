@@ -0,0 +1,60 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalogPagination(t *testing.T) {
+
+	// the templates are found from the root of the project
+	defaultRootDir := web.Config.RootDir
+	web.Config.RootDir = "../.."
+	Init(web.Config, web.Store, web.View, web.LCP)
+	defer func() {
+		web.Config.RootDir = defaultRootDir
+		Init(web.Config, web.Store, web.View, web.LCP)
+	}()
+
+	r := chi.NewRouter()
+	r.Group(web.Router)
+
+	// the publications of a publisher of their own are isolated from the rest of the catalog
+	publisher := gofakeit.UUID()
+	var publications []*stor.Publication
+	for i := 0; i < 3; i++ {
+		publication := &stor.Publication{Title: gofakeit.Sentence(3), UUID: gofakeit.UUID(), ContentType: "application/epub+zip", Publisher: []stor.Publisher{{Name: publisher}}}
+		err := web.Store.CreatePublication(publication)
+		assert.NoError(t, err)
+		publications = append(publications, publication)
+	}
+
+	getPage := func(page string) string {
+		req := httptest.NewRequest("GET", "/catalog?publisher="+url.QueryEscape(publisher)+"&pageSize=1&page="+page, nil)
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+		return recorder.Body.String()
+	}
+
+	assert.Contains(t, getPage("2"), "Page 2 of 3")
+	// a page past the last one shows the last page, whatever its number
+	assert.Contains(t, getPage("4"), "Page 3 of 3")
+	assert.Contains(t, getPage("1500"), "Page 3 of 3")
+	assert.Contains(t, getPage("99999999999999999"), "Page 3 of 3")
+	// an invalid page shows the first page
+	assert.Contains(t, getPage("0"), "Page 1 of 3")
+	assert.Contains(t, getPage("abc"), "Page 1 of 3")
+
+	for _, publication := range publications {
+		err := web.Store.DeletePublication(publication)
+		assert.NoError(t, err)
+	}
+}
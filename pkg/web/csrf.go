@@ -0,0 +1,42 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+)
+
+// csrfField is the name of the form field which holds the CSRF token
+const csrfField = "csrf_token"
+
+// csrfToken returns the token which protects the forms of the session of a request against cross-site request forgery,
+// or an empty string if the request has no session.
+// The token is derived from the session ID, which is only known to the browser of the user.
+func csrfToken(r *http.Request) string {
+	cookie, err := r.Cookie("session")
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, []byte(cookie.Value))
+	mac.Write([]byte("pubstore csrf"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CSRFMiddleware rejects the POST requests whose form does not hold the CSRF token of the session
+func (web *Web) CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			token := csrfToken(r)
+			if token == "" || !hmac.Equal([]byte(r.PostFormValue(csrfField)), []byte(token)) {
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
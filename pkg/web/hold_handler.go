@@ -0,0 +1,90 @@
+// Copyright 2023 European Digital Reading Lab. All rights reserved.
+// Use of this source code is governed by a BSD-style license
+// specified in the Github project LICENSE file.
+
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/go-chi/chi/v5"
+)
+
+// placeHold places a hold on a publication which has no copy available, then redirects to the publication page
+func (web *Web) placeHold(w http.ResponseWriter, r *http.Request) {
+
+	pubUUID := chi.URLParam(r, "id")
+	errMessage := "Hold failed: "
+
+	user := web.getUserByCookie(r)
+	if user == nil {
+		acquisitionFailure(w, r, pubUUID, errMessage+"unknown user")
+		return
+	}
+	publication, err := web.GetPublication(pubUUID)
+	if err != nil {
+		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+		return
+	}
+
+	// a hold is useless if a copy can be borrowed
+	err = web.CheckCopyAvailable(publication, user.ID)
+	if err == nil {
+		acquisitionFailure(w, r, pubUUID, errMessage+"a copy is available for loan")
+		return
+	}
+	if !errors.Is(err, stor.ErrNoCopyAvailable) {
+		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+		return
+	}
+
+	hold := &stor.Hold{
+		UserID:        user.ID,
+		PublicationID: publication.ID,
+	}
+	if err = web.CreateHold(hold); err != nil {
+		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+		return
+	}
+
+	http.Redirect(w, r, "/catalog/publication/"+pubUUID, http.StatusFound)
+}
+
+// cancelHold cancels the hold of the user on a publication, then redirects to the publication page
+func (web *Web) cancelHold(w http.ResponseWriter, r *http.Request) {
+
+	pubUUID := chi.URLParam(r, "id")
+	errMessage := "Hold cancellation failed: "
+
+	user := web.getUserByCookie(r)
+	if user == nil {
+		acquisitionFailure(w, r, pubUUID, errMessage+"unknown user")
+		return
+	}
+	publication, err := web.GetPublication(pubUUID)
+	if err != nil {
+		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+		return
+	}
+	hold, err := web.GetHold(user.ID, publication.ID)
+	if err != nil {
+		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+		return
+	}
+	if err = web.DeleteHold(hold); err != nil {
+		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+		return
+	}
+
+	// a copy kept for the user goes to the next hold in line
+	if hold.Status == stor.HoldStatusReady {
+		if _, err = web.PromoteHolds(publication.ID, web.Config.HoldReadyPeriod()); err != nil {
+			acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+			return
+		}
+	}
+
+	http.Redirect(w, r, "/catalog/publication/"+pubUUID, http.StatusFound)
+}
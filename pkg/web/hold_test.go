@@ -0,0 +1,67 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHold(t *testing.T) {
+
+	r := chi.NewRouter()
+	r.Group(web.Router)
+
+	borrower, borrowerCookie := createSignedInUser(t)
+	holder, holderCookie := createSignedInUser(t)
+	publication := &stor.Publication{Title: "Hold", UUID: gofakeit.UUID(), ContentType: "application/epub+zip", Copies: 1}
+	err := web.Store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	// the only copy is borrowed
+	recorder := postForm(r, "/catalog/publication/"+publication.UUID+"/loan", borrowerCookie, sessionToken(borrowerCookie))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// holds are placed and cancelled with forms protected against cross-site request forgery
+	for _, action := range []string{"hold", "hold/cancel"} {
+		req := httptest.NewRequest("GET", "/catalog/publication/"+publication.UUID+"/"+action, nil)
+		req.AddCookie(holderCookie)
+		recorder = httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+		recorder = postForm(r, "/catalog/publication/"+publication.UUID+"/"+action, holderCookie, "")
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+	}
+	_, err = web.Store.GetHold(holder.ID, publication.ID)
+	assert.Error(t, err)
+
+	token := sessionToken(holderCookie)
+
+	recorder = postForm(r, "/catalog/publication/"+publication.UUID+"/hold", holderCookie, token)
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Equal(t, "/catalog/publication/"+publication.UUID, recorder.Header().Get("Location"))
+	_, err = web.Store.GetHold(holder.ID, publication.ID)
+	assert.NoError(t, err)
+
+	recorder = postForm(r, "/catalog/publication/"+publication.UUID+"/hold/cancel", holderCookie, token)
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Equal(t, "/catalog/publication/"+publication.UUID, recorder.Header().Get("Location"))
+	_, err = web.Store.GetHold(holder.ID, publication.ID)
+	assert.Error(t, err)
+
+	transaction, err := web.Store.GetTransactionByUserAndPublication(borrower.ID, publication.ID)
+	if assert.NoError(t, err) {
+		err = web.Store.DeleteTransaction(transaction)
+		assert.NoError(t, err)
+	}
+	err = web.Store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = web.Store.DeleteUser(borrower)
+	assert.NoError(t, err)
+	err = web.Store.DeleteUser(holder)
+	assert.NoError(t, err)
+}
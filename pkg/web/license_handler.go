@@ -6,6 +6,7 @@ package web
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -18,110 +19,199 @@ import (
 	"github.com/go-chi/chi/v5"
 )
 
-// createLicense sends a request to the License Server and returns the license to the caller
-func (web *Web) createLicense(w http.ResponseWriter, r *http.Request) {
+// createLicense returns a handler which sends a request to the License Server and returns the license to the caller.
+// transactionType is stor.TransactionTypePurchase || stor.TransactionTypeLoan || stor.TransactionTypeSubscription.
+func (web *Web) createLicense(transactionType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		pubUUID := chi.URLParam(r, "id")
+
+		// rights depend on the type of transaction
+		print, copy, duration := web.Config.DefaultRights(transactionType)
+		var start, end time.Time
+
+		// the buyer of a publication may choose its rights;
+		// loans and subscriptions get the configured rights, starting now
+		if transactionType == stor.TransactionTypePurchase {
+			if p, err := strconv.Atoi(r.URL.Query().Get("printRights")); err == nil {
+				print = p
+			}
+			if c, err := strconv.Atoi(r.URL.Query().Get("copyRights")); err == nil {
+				copy = c
+			}
+		}
+		if duration > 0 {
+			start = time.Now().UTC().Truncate(time.Second)
+			end = start.AddDate(0, 0, duration)
+		}
 
-	// get request params
-	pubUUID := chi.URLParam(r, "id")
-	printParam := r.URL.Query().Get("printRights")
-	copyParam := r.URL.Query().Get("copyRights")
-	startParam := r.URL.Query().Get("startDate")
-	endParam := r.URL.Query().Get("endDate")
-
-	licenseReq := lcp.LicenseRequest{}
-
-	// sanitize params
-	var print, copy int
-	var start, end time.Time
-	var err error
-	if print, err = strconv.Atoi(printParam); err != nil {
-		fmt.Println(err.Error())
-		print = web.Config.PrintLimit
-	}
-	if copy, err = strconv.Atoi(copyParam); err != nil {
-		fmt.Println(err.Error())
-		copy = web.Config.CopyLimit
-	}
-	// start & end params may be empty strings. In this case their time representation keep a zero value
-	if startParam != "" {
-		start, err = time.Parse(time.RFC3339, startParam)
+		// get user information
+		user := web.getUserByCookie(r)
+
+		errMessage := "License acquisition failed: "
+
+		publication, err := web.GetPublication(pubUUID)
 		if err != nil {
-			fmt.Println(err.Error())
+			acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+			return
 		}
-	}
-	if endParam != "" {
-		end, err = time.Parse(time.RFC3339, endParam)
+
+		transaction := &stor.Transaction{
+			UserID:        user.ID,
+			PublicationID: publication.ID,
+			Type:          transactionType,
+		}
+
+		// a loan requires an available copy, which is reserved before the license is generated
+		if transactionType == stor.TransactionTypeLoan {
+			if err = web.CreateLoan(transaction); err != nil {
+				acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+				return
+			}
+		}
+		// the reserved copy is released if the license cannot be generated
+		fail := func(err error) {
+			if transaction.ID != 0 {
+				if deleteErr := web.DeleteTransaction(transaction); deleteErr != nil {
+					fmt.Println(deleteErr.Error())
+				}
+			}
+			acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+		}
+
+		licenseReq := lcp.NewLicenseRequest(pubUUID, user, print, copy, start, end)
+
+		licence, err := web.LCP.GenerateLicense(r.Context(), licenseReq)
 		if err != nil {
-			fmt.Println(err.Error())
+			fail(err)
+			return
 		}
+
+		err = lcp.RecordLicense(transaction, licence)
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		if transaction.ID != 0 {
+			err = web.UpdateTransaction(transaction)
+		} else {
+			err = web.CreateTransaction(transaction)
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		// the hold placed by the user, if any, is fulfilled
+		if transactionType == stor.TransactionTypeLoan {
+			if err = web.FulfillHold(user.ID, publication.ID); err != nil {
+				fmt.Println(err.Error())
+			}
+		}
+
+		// return the license to the caller
+		w.Header().Set("Content-Disposition", "attachment; filename="+publication.Title+".lcpl")
+		w.Header().Set("Content-Type", "application/vnd.readium.lcp.license.v1.0+json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(licence)))
+
+		io.Copy(w, bytes.NewReader(licence))
 	}
+}
 
-	// get user information
-	user := web.getUserByCookie(r)
+func acquisitionFailure(w http.ResponseWriter, r *http.Request, pubID string, message string) {
+	http.Redirect(w, r, fmt.Sprintf("/catalog/publication/%s?err=%s", pubID, url.QueryEscape(message)), http.StatusFound)
+}
 
-	licenseReq.PublicationID = pubUUID
+// returnLicense returns a loan to the License Server before its end, then redirects to the bookshelf
+func (web *Web) returnLicense(w http.ResponseWriter, r *http.Request) {
 
-	// negative values for print and copy are considered void (therefore unconstrained)
-	if print >= 0 {
-		licenseReq.Print = &print
+	pubUUID := chi.URLParam(r, "id")
+	errMessage := "License return failed: "
+
+	transaction, err := web.getUserLoan(r, pubUUID)
+	if err != nil {
+		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+		return
 	}
-	if copy >= 0 {
-		licenseReq.Copy = &copy
+
+	lsd, err := web.LCP.ReturnLicense(r.Context(), transaction)
+	if err != nil {
+		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+		return
 	}
-	// zero start and end are considered void (therefore unconstrained)
-	if !start.IsZero() {
-		licenseReq.Start = &start
+
+	// the loan ends now
+	now := time.Now().UTC().Truncate(time.Second)
+	transaction.EndDate = &now
+	transaction.Status = lsd.Status
+	err = web.UpdateTransaction(transaction)
+	if err != nil {
+		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
+		return
 	}
-	if !end.IsZero() {
-		licenseReq.End = &end
+
+	// the copy goes to the next hold in line
+	if _, err = web.PromoteHolds(transaction.PublicationID, web.Config.HoldReadyPeriod()); err != nil {
+		fmt.Println(err.Error())
 	}
-	licenseReq.UserID = user.UUID
-	licenseReq.UserName = user.Name
-	licenseReq.UserEmail = user.Email
-	licenseReq.UserEncrypted = []string{"email"}
-	licenseReq.TextHint = user.TextHint
-	licenseReq.PassHash = user.HPassphrase
 
-	errMessage := "License acquisition failed: "
+	http.Redirect(w, r, "/user/bookshelf", http.StatusFound)
+}
+
+// renewLicense extends a loan by the loan duration, within its maximum duration, then redirects to the bookshelf
+func (web *Web) renewLicense(w http.ResponseWriter, r *http.Request) {
 
-	licence, err := lcp.GenerateLicense(web.Config.LCPServer, licenseReq)
+	pubUUID := chi.URLParam(r, "id")
+	errMessage := "License renewal failed: "
+
+	transaction, err := web.getUserLoan(r, pubUUID)
 	if err != nil {
 		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
 		return
 	}
 
-	licenseId, pubTitle, _, _, _, _, _, err := lcp.ParseLicense(licence)
+	_, _, duration := web.Config.DefaultRights(stor.TransactionTypeLoan)
+	end, err := lcp.RenewalEnd(transaction, time.Time{}, duration, web.Config.MaxDuration(stor.TransactionTypeLoan))
 	if err != nil {
 		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
 		return
 	}
 
-	publication, err := web.GetPublication(pubUUID)
+	lsd, err := web.LCP.RenewLicense(r.Context(), transaction, end)
 	if err != nil {
 		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
 		return
 	}
 
-	transaction := &stor.Transaction{
-		UserID:        user.ID,
-		PublicationID: publication.ID,
-		LicenceId:     licenseId,
-	}
-
-	err = web.CreateTransaction(transaction)
+	transaction.EndDate = &end
+	transaction.Status = lsd.Status
+	err = web.UpdateTransaction(transaction)
 	if err != nil {
 		acquisitionFailure(w, r, pubUUID, errMessage+err.Error())
 		return
 	}
 
-	// return the license to the caller
-	w.Header().Set("Content-Disposition", "attachment; filename="+pubTitle+".lcpl")
-	w.Header().Set("Content-Type", "application/vnd.readium.lcp.license.v1.0+json")
-	w.Header().Set("Content-Length", strconv.Itoa(len(licence)))
-
-	io.Copy(w, bytes.NewReader(licence))
+	http.Redirect(w, r, "/user/bookshelf", http.StatusFound)
 }
 
-func acquisitionFailure(w http.ResponseWriter, r *http.Request, pubID string, message string) {
-	http.Redirect(w, r, fmt.Sprintf("/catalog/publication/%s?err=%s", pubID, url.QueryEscape(message)), http.StatusFound)
+// getUserLoan retrieves the last transaction of the authenticated user on a publication, which must be a loan
+func (web *Web) getUserLoan(r *http.Request, pubUUID string) (*stor.Transaction, error) {
+
+	user := web.getUserByCookie(r)
+	if user == nil {
+		return nil, errors.New("unknown user")
+	}
+	publication, err := web.GetPublication(pubUUID)
+	if err != nil {
+		return nil, err
+	}
+	transaction, err := web.GetTransactionByUserAndPublication(user.ID, publication.ID)
+	if err != nil {
+		return nil, err
+	}
+	if transaction.Type != stor.TransactionTypeLoan {
+		return nil, stor.ErrNotLoan
+	}
+	return transaction, nil
 }
@@ -0,0 +1,234 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brianvoe/gofakeit/v6"
+	"github.com/edrlab/pubstore/pkg/lcp"
+	"github.com/edrlab/pubstore/pkg/stor"
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+// createSignedInUser creates a user with a session, and returns the session cookie
+func createSignedInUser(t *testing.T) (*stor.User, *http.Cookie) {
+	user := &stor.User{
+		UUID:       gofakeit.UUID(),
+		Name:       "Pierre ler",
+		Email:      gofakeit.Email(),
+		Password:   "password",
+		TextHint:   "hint",
+		Passphrase: "passphrase",
+		SessionId:  gofakeit.UUID(),
+	}
+	err := web.Store.CreateUser(user)
+	assert.NoError(t, err)
+	return user, &http.Cookie{Name: "session", Value: user.SessionId}
+}
+
+// postForm posts a form holding a CSRF token in the session of a cookie
+func postForm(r http.Handler, path string, cookie *http.Cookie, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(url.Values{csrfField: {token}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	return recorder
+}
+
+// sessionToken returns the CSRF token of a session
+func sessionToken(cookie *http.Cookie) string {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	return csrfToken(req)
+}
+
+func TestLoanRights(t *testing.T) {
+
+	r := chi.NewRouter()
+	r.Group(web.Router)
+
+	defaultRights := web.Config.Rights
+	defer func() { web.Config.Rights = defaultRights }()
+	print, copy, duration := 5, 100, 14
+	web.Config.Rights.Loan.PrintLimit = &print
+	web.Config.Rights.Loan.CopyLimit = &copy
+	web.Config.Rights.Loan.Duration = &duration
+
+	user, cookie := createSignedInUser(t)
+	publication := &stor.Publication{Title: "Loan rights", UUID: gofakeit.UUID(), ContentType: "application/epub+zip"}
+	err := web.Store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	// rights and dates requested by the patron are ignored
+	recorder := postForm(r, "/catalog/publication/"+publication.UUID+"/loan?printRights=1000&copyRights=1000&endDate=2099-01-01T00:00:00Z", cookie, sessionToken(cookie))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// a second copy cannot be borrowed while the loan is ongoing
+	recorder = postForm(r, "/catalog/publication/"+publication.UUID+"/loan", cookie, sessionToken(cookie))
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("Location"), url.QueryEscape(stor.ErrLoanOngoing.Error()))
+
+	transaction, err := web.Store.GetTransactionByUserAndPublication(user.ID, publication.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, stor.TransactionTypeLoan, transaction.Type)
+		if assert.NotNil(t, transaction.PrintLimit) && assert.NotNil(t, transaction.CopyLimit) {
+			assert.Equal(t, 5, *transaction.PrintLimit)
+			assert.Equal(t, 100, *transaction.CopyLimit)
+		}
+		if assert.NotNil(t, transaction.EndDate) {
+			assert.WithinDuration(t, time.Now().AddDate(0, 0, 14), *transaction.EndDate, time.Minute)
+		}
+		err = web.Store.DeleteTransaction(transaction)
+		assert.NoError(t, err)
+	}
+
+	err = web.Store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = web.Store.DeleteUser(user)
+	assert.NoError(t, err)
+}
+
+func TestRenewLicense(t *testing.T) {
+
+	r := chi.NewRouter()
+	r.Group(web.Router)
+
+	defaultRights := web.Config.Rights
+	defer func() { web.Config.Rights = defaultRights }()
+	duration := 14
+	web.Config.Rights.Loan.Duration = &duration
+
+	user, cookie := createSignedInUser(t)
+	publication := &stor.Publication{Title: "Loan renewal", UUID: gofakeit.UUID(), ContentType: "application/epub+zip"}
+	err := web.Store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	token := sessionToken(cookie)
+	recorder := postForm(r, "/catalog/publication/"+publication.UUID+"/loan", cookie, token)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// loans, renewals and returns are forms protected against cross-site request forgery
+	for _, action := range []string{"loan", "renew", "return"} {
+		req := httptest.NewRequest("GET", "/catalog/publication/"+publication.UUID+"/"+action, nil)
+		req.AddCookie(cookie)
+		recorder = httptest.NewRecorder()
+		r.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+		recorder = postForm(r, "/catalog/publication/"+publication.UUID+"/"+action, cookie, "")
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+		recorder = postForm(r, "/catalog/publication/"+publication.UUID+"/"+action, cookie, strings.Repeat("0", 64))
+		assert.Equal(t, http.StatusForbidden, recorder.Code)
+	}
+
+	renew := func() string {
+		recorder := postForm(r, "/catalog/publication/"+publication.UUID+"/renew?endDate=2099-01-01T00:00:00Z", cookie, token)
+		assert.Equal(t, http.StatusFound, recorder.Code)
+		return recorder.Header().Get("Location")
+	}
+
+	// the loan is extended by its duration, whatever the end date requested by the patron,
+	// up to three times its duration
+	assert.Equal(t, "/user/bookshelf", renew())
+	transaction, err := web.Store.GetTransactionByUserAndPublication(user.ID, publication.ID)
+	if assert.NoError(t, err) && assert.NotNil(t, transaction.EndDate) {
+		assert.WithinDuration(t, time.Now().AddDate(0, 0, 28), *transaction.EndDate, time.Minute)
+	}
+	assert.Equal(t, "/user/bookshelf", renew())
+	assert.Contains(t, renew(), "err=")
+	transaction, err = web.Store.GetTransactionByUserAndPublication(user.ID, publication.ID)
+	if assert.NoError(t, err) && assert.NotNil(t, transaction.EndDate) {
+		assert.WithinDuration(t, time.Now().AddDate(0, 0, 42), *transaction.EndDate, time.Minute)
+		err = web.Store.DeleteTransaction(transaction)
+		assert.NoError(t, err)
+	}
+
+	err = web.Store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = web.Store.DeleteUser(user)
+	assert.NoError(t, err)
+}
+
+func TestUnlimitedLoan(t *testing.T) {
+
+	r := chi.NewRouter()
+	r.Group(web.Router)
+
+	defaultRights := web.Config.Rights
+	defer func() { web.Config.Rights = defaultRights }()
+	duration := 0
+	web.Config.Rights.Loan.Duration = &duration
+
+	user, cookie := createSignedInUser(t)
+	publication := &stor.Publication{Title: "Unlimited loan", UUID: gofakeit.UUID(), ContentType: "application/epub+zip"}
+	err := web.Store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	recorder := postForm(r, "/catalog/publication/"+publication.UUID+"/loan", cookie, sessionToken(cookie))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// the loan has no end date and cannot be renewed
+	transaction, err := web.Store.GetTransactionByUserAndPublication(user.ID, publication.ID)
+	if assert.NoError(t, err) {
+		assert.Nil(t, transaction.EndDate)
+	}
+	recorder = postForm(r, "/catalog/publication/"+publication.UUID+"/renew", cookie, sessionToken(cookie))
+	assert.Equal(t, http.StatusFound, recorder.Code)
+	assert.Contains(t, recorder.Header().Get("Location"), url.QueryEscape(lcp.ErrRenewalUnlimited.Error()))
+
+	transaction, err = web.Store.GetTransactionByUserAndPublication(user.ID, publication.ID)
+	if assert.NoError(t, err) {
+		assert.Nil(t, transaction.EndDate)
+		err = web.Store.DeleteTransaction(transaction)
+		assert.NoError(t, err)
+	}
+
+	err = web.Store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = web.Store.DeleteUser(user)
+	assert.NoError(t, err)
+}
+
+func TestRenewPurchase(t *testing.T) {
+
+	r := chi.NewRouter()
+	r.Group(web.Router)
+
+	user, cookie := createSignedInUser(t)
+	publication := &stor.Publication{Title: "Purchase", UUID: gofakeit.UUID(), ContentType: "application/epub+zip"}
+	err := web.Store.CreatePublication(publication)
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest("GET", "/catalog/publication/"+publication.UUID+"/buy", nil)
+	req.AddCookie(cookie)
+	recorder := httptest.NewRecorder()
+	r.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	// a purchase can be neither renewed nor returned
+	for _, action := range []string{"renew", "return"} {
+		recorder = postForm(r, "/catalog/publication/"+publication.UUID+"/"+action, cookie, sessionToken(cookie))
+		assert.Equal(t, http.StatusFound, recorder.Code)
+		assert.Contains(t, recorder.Header().Get("Location"), url.QueryEscape(stor.ErrNotLoan.Error()))
+	}
+
+	transaction, err := web.Store.GetTransactionByUserAndPublication(user.ID, publication.ID)
+	if assert.NoError(t, err) {
+		assert.Equal(t, stor.TransactionTypePurchase, transaction.Type)
+		assert.Nil(t, transaction.EndDate)
+		assert.NotEqual(t, lcp.StatusReturned, transaction.Status)
+		err = web.Store.DeleteTransaction(transaction)
+		assert.NoError(t, err)
+	}
+
+	err = web.Store.DeletePublication(publication)
+	assert.NoError(t, err)
+	err = web.Store.DeleteUser(user)
+	assert.NoError(t, err)
+}
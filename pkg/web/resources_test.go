@@ -0,0 +1,45 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResources(t *testing.T) {
+
+	r := chi.NewRouter()
+	r.Group(web.Router)
+
+	defaultResources := web.Config.Resources
+	defer func() { web.Config.Resources = defaultResources }()
+	web.Config.Resources = t.TempDir()
+	err := os.MkdirAll(filepath.Join(web.Config.Resources, "pub"), 0o755)
+	assert.NoError(t, err)
+	err = os.WriteFile(filepath.Join(web.Config.Resources, "pub", "cover.jpg"), []byte("cover"), 0o644)
+	assert.NoError(t, err)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder
+	}
+
+	// files are cached indefinitely
+	recorder := get("/resources/pub/cover.jpg")
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "cover", recorder.Body.String())
+	assert.Contains(t, recorder.Header().Get("Cache-Control"), "immutable")
+
+	// directories are not listed, and missing files are not cached
+	for _, path := range []string{"/resources/", "/resources/pub", "/resources/pub/", "/resources/pub/missing.jpg", "/resources/../sign_test.go"} {
+		recorder = get(path)
+		assert.Equal(t, http.StatusNotFound, recorder.Code, path)
+		assert.Empty(t, recorder.Header().Get("Cache-Control"), path)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
 	"path/filepath"
@@ -22,9 +23,10 @@ type Web struct {
 	*conf.Config
 	*stor.Store
 	*view.View
+	LCP lcp.LicenseProvider
 }
 
-func Init(c *conf.Config, s *stor.Store, v *view.View) Web {
+func Init(c *conf.Config, s *stor.Store, v *view.View, lp lcp.LicenseProvider) Web {
 
 	// Configure goview to retrieve views at the proper location
 	gvConf := goview.DefaultConfig
@@ -36,6 +38,7 @@ func Init(c *conf.Config, s *stor.Store, v *view.View) Web {
 		Config: c,
 		Store:  s,
 		View:   v,
+		LCP:    lp,
 	}
 }
 
@@ -54,10 +57,14 @@ func (web *Web) publicationFreshLicenceHandler(w http.ResponseWriter, r *http.Re
 	var licenceBytes []byte
 	var publicationTitle string
 
-	licenceBytes, err = lcp.GetFreshLicense(web.Config.LCPServer, transaction)
+	licenceBytes, err = web.LCP.GetFreshLicense(r.Context(), transaction)
 
 	if err == nil {
-		_, publicationTitle, _, _, _, _, _, err = lcp.ParseLicense(licenceBytes)
+		var license *lcp.License
+		if license, err = lcp.ParseLicense(licenceBytes); err == nil {
+			publication, _ := license.Link("publication")
+			publicationTitle = publication.Title
+		}
 	}
 
 	if err != nil {
@@ -88,16 +95,14 @@ func (web *Web) bookshelfHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var transactionsView []*view.TransactionView = make([]*view.TransactionView, len(*transactions))
-	for i, transactionStor := range *transactions {
-		transactionsView[i] = web.View.GetTransactionViewFromTransactionStor(&transactionStor)
-	}
+	transactionsView := web.View.GetTransactionViewsFromTransactionsStor(r.Context(), *transactions)
 
 	goviewModel := goview.M{
 		"pageTitle":           "pubstore - bookshelf",
 		"userIsAuthenticated": true,
 		"userName":            user.Name,
 		"transactions":        transactionsView,
+		"csrfToken":           csrfToken(r),
 	}
 
 	err = goview.Render(w, http.StatusOK, "bookshelf", goviewModel)
@@ -110,60 +115,32 @@ func (web *Web) catalogHandler(w http.ResponseWriter, r *http.Request) {
 	// Implementation for the catalog handler
 	// This function will handle the "/catalog" route
 
-	format := r.URL.Query().Get("format")
-	author := r.URL.Query().Get("author")
-	language := r.URL.Query().Get("language")
-	publisher := r.URL.Query().Get("publisher")
-	category := r.URL.Query().Get("category")
+	// facet filters and the text of a search are combined
+	filter := view.CatalogFilterFromQuery(r.URL.Query())
 	page := r.URL.Query().Get("page")
 	pageSize := r.URL.Query().Get("pageSize")
 
-	q := r.URL.Query().Get("q")
-	query := r.URL.Query().Get("query")
-	queryStr := query
-	if len(query) == 0 {
-		queryStr = q
-	}
-
 	pageInt, _ := strconv.Atoi(page)
-	if pageInt < 1 || pageInt > 1000 {
+	if pageInt < 1 {
 		pageInt = 1
 	}
+	// keeps the offset of the query in range; such a page is past the last one anyway
+	if pageInt > math.MaxInt32 {
+		pageInt = math.MaxInt32
+	}
 	pageSizeInt, _ := strconv.Atoi(pageSize)
 	if pageSizeInt < 1 || pageSizeInt > 1000 {
 		pageSizeInt = web.Config.PageSize
 	}
 
-	var facet string = ""
-	var value string = ""
-	if len(queryStr) > 0 {
-		facet = "search"
-		value = queryStr
-	} else if len(format) > 0 {
-		facet = "format"
-		value = format
-	} else if len(author) > 0 {
-		facet = "author"
-		value = author
-	} else if len(publisher) > 0 {
-		facet = "publisher"
-		value = publisher
-	} else if len(language) > 0 {
-		facet = "language"
-		value = language
-	} else if len(category) > 0 {
-		facet = "category"
-		value = category
+	pubsView, count, facetsView := web.View.GetCatalogPublicationsView(filter, pageInt, pageSizeInt)
+	// a page past the last one shows the last page
+	if lastPage := int((count + int64(pageSizeInt) - 1) / int64(pageSizeInt)); lastPage > 0 && pageInt > lastPage {
+		pageInt = lastPage
+		pubsView, count, facetsView = web.View.GetCatalogPublicationsView(filter, pageInt, pageSizeInt)
 	}
+	catalogView := view.GetCatalogView(pubsView, facetsView, filter, count, pageInt, pageSizeInt)
 
-	facetsView := web.View.GetCatalogFacetsView()
-	pubsView, count := web.View.GetCatalogPublicationsView(facet, value, pageInt, pageSizeInt)
-	catalogView := view.GetCatalogView(pubsView, facetsView)
-
-	var pageRange []string = make([]string, pageInt)
-	for i := 0; i < pageInt; i++ {
-		pageRange[i] = fmt.Sprintf("%d", i+1)
-	}
 	userStor := web.getUserByCookie(r)
 	userName := ""
 	if userStor != nil {
@@ -174,16 +151,17 @@ func (web *Web) catalogHandler(w http.ResponseWriter, r *http.Request) {
 		"pageTitle":           "pubstore - catalog",
 		"userIsAuthenticated": web.userIsAuthenticated(r),
 		"userName":            userName,
-		"currentFacetType":    facet,
-		"currentFacetValue":   value,
+		"filters":             (*catalogView).Filters,
 		"currentPageSize":     fmt.Sprintf("%d", pageSizeInt),
 		"currentPage":         fmt.Sprintf("%d", pageInt),
-		"pageRange":           pageRange,
-		"publicationCount":    fmt.Sprintf("%d", count),
+		"nbPages":             (*catalogView).NbPages,
+		"pagination":          (*catalogView).Pagination,
+		"publicationCount":    (*catalogView).NbPublications,
 		"authors":             (*catalogView).Authors,
 		"publishers":          (*catalogView).Publishers,
 		"languages":           (*catalogView).Languages,
 		"categories":          (*catalogView).Categories,
+		"formats":             (*catalogView).Formats,
 		"publications":        (*catalogView).Publications,
 	}
 
@@ -211,7 +189,7 @@ func (web *Web) publicationHandler(w http.ResponseWriter, r *http.Request) {
 			userName = userStor.Name
 			transaction, err := web.Store.GetTransactionByUserAndPublication(userStor.ID, publicationStor.ID)
 			if err == nil {
-				viewTransaction = *web.View.GetTransactionViewFromTransactionStor(transaction)
+				viewTransaction = *web.View.GetTransactionViewFromTransactionStor(r.Context(), transaction)
 				if viewTransaction.LicenseStatusCode == "ready" || viewTransaction.LicenseStatusCode == "active" {
 					licenseOK = true
 				}
@@ -219,6 +197,29 @@ func (web *Web) publicationHandler(w http.ResponseWriter, r *http.Request) {
 		}
 
 		publicationView := web.View.GetPublicationViewFromPublicationStor(publicationStor)
+
+		// -1 means that the number of copies is unlimited
+		availableCopies, err := web.Store.AvailableCopies(publicationStor)
+		if err != nil {
+			availableCopies = -1
+		}
+
+		// hold placed by the user, if any; position 0 means that a copy is kept for the user
+		holdFound := false
+		var holdPosition int64
+		var holdExpiresAt string
+		if userStor != nil {
+			if hold, err := web.Store.GetHold(userStor.ID, publicationStor.ID); err == nil {
+				holdFound = true
+				holdPosition, _ = web.Store.HoldPosition(hold)
+				if hold.ExpiresAt != nil {
+					holdExpiresAt = hold.ExpiresAt.Format("02 Jan 2006")
+				}
+			}
+		}
+		// loans get the configured rights
+		loanPrint, loanCopy, loanDuration := web.Config.DefaultRights(stor.TransactionTypeLoan)
+
 		goviewModel := goview.M{
 			"pageTitle":             fmt.Sprintf("pubstore - %s", publicationView.Title),
 			"host":                  strings.Split(web.Config.PublicBaseUrl, "://")[1],
@@ -238,6 +239,15 @@ func (web *Web) publicationHandler(w http.ResponseWriter, r *http.Request) {
 			"categories":            publicationView.Category,
 			"licenseFound":          bool(viewTransaction.PublicationUUID != ""),
 			"transaction":           viewTransaction,
+			"copies":                publicationStor.Copies,
+			"availableCopies":       availableCopies,
+			"holdFound":             holdFound,
+			"holdPosition":          holdPosition,
+			"holdExpiresAt":         holdExpiresAt,
+			"loanPrint":             loanPrint,
+			"loanCopy":              loanCopy,
+			"loanDuration":          loanDuration,
+			"csrfToken":             csrfToken(r),
 		}
 		err = goview.Render(w, http.StatusOK, "publication", goviewModel)
 		if err != nil {
@@ -246,6 +256,30 @@ func (web *Web) publicationHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// resourcesHandler serves the files of the resources directory; directories are not listed.
+// File names change with their content, therefore files can be cached indefinitely.
+func (web *Web) resourcesHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/resources")
+	if strings.HasSuffix(name, "/") {
+		http.NotFound(w, r)
+		return
+	}
+	// http.Dir cleans the name, which cannot leave the resources directory
+	f, err := http.Dir(web.Config.Resources).Open(name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
 func (web *Web) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Check if the user is authenticated
@@ -266,8 +300,7 @@ func (web *Web) Router(r chi.Router) {
 	r.Handle("/static/*", http.StripPrefix("/static/", http.FileServer(filesDir)))
 
 	// Serve resources from a configurable directory (used for cover images)
-	//r.Handle("/resources/*", http.StripPrefix("/resources/", http.FileServer(http.Dir(web.Config.Resources))))
-	//fmt.Println("Resources fetched from ", web.Config.Resources)
+	r.Handle("/resources/*", http.HandlerFunc(web.resourcesHandler))
 
 	// Public Routes
 	r.Group(func(r chi.Router) {
@@ -311,10 +344,15 @@ func (web *Web) Router(r chi.Router) {
 	// Require Authentication
 	r.Group(func(r chi.Router) {
 		r.Use(web.AuthMiddleware)
+		r.Use(web.CSRFMiddleware)
 		// r.Get("/user/infos", userInfos)
 		r.Get("/user/bookshelf", web.bookshelfHandler)
-		r.Get("/catalog/publication/{id}/buy", web.createLicense)
-		r.Get("/catalog/publication/{id}/loan", web.createLicense)
+		r.Get("/catalog/publication/{id}/buy", web.createLicense(stor.TransactionTypePurchase))
+		r.Post("/catalog/publication/{id}/loan", web.createLicense(stor.TransactionTypeLoan))
 		r.Get("/catalog/publication/{id}/license", web.publicationFreshLicenceHandler)
+		r.Post("/catalog/publication/{id}/return", web.returnLicense)
+		r.Post("/catalog/publication/{id}/renew", web.renewLicense)
+		r.Post("/catalog/publication/{id}/hold", web.placeHold)
+		r.Post("/catalog/publication/{id}/hold/cancel", web.cancelHold)
 	})
 }
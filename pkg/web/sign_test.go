@@ -10,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/edrlab/pubstore/pkg/conf"
+	"github.com/edrlab/pubstore/pkg/lcp"
 	"github.com/edrlab/pubstore/pkg/stor"
 	"github.com/edrlab/pubstore/pkg/view"
 	"github.com/go-chi/chi/v5"
@@ -29,8 +30,13 @@ func TestMain(m *testing.M) {
 		panic("Database setup failed.")
 	}
 
-	view := view.Init(&config, &store)
-	web = Init(&config, &store, &view)
+	lp, err := lcp.NewFake("http://localhost:8080")
+	if err != nil {
+		panic("License provider setup failed.")
+	}
+
+	view := view.Init(&config, &store, lp)
+	web = Init(&config, &store, &view, lp)
 
 	// Run the tests
 	exitCode := m.Run()
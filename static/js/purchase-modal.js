@@ -17,12 +17,6 @@ const backToPresentationButtonLoan = document.querySelector("#backButtonLoan");
 
 let form = document.querySelector(".modal-form-options");
 
-let startDateLocal = document.querySelector('#startDateLocal');
-let endDateLocal = document.querySelector('#endDateLocal');
-let startDateISO = document.querySelector('#startDate');
-let endDateISO = document.querySelector('#endDate');
-
-
 const createModal = () => {
     modalWindow.style.display = 'block';
     modalWindow.style.opacity = "1";
@@ -38,7 +32,8 @@ purchaseButton.addEventListener('click', (e) => {
     loanForm.style.display = "none";
 })
 
-loanButton.addEventListener('click', (e) => {
+// the borrow button is absent when no copy is available
+loanButton?.addEventListener('click', (e) => {
     e.preventDefault();
     createModal()
     buyForm.style.display = "none";
@@ -94,11 +89,3 @@ modal.addEventListener('click', (e) => {
     e.stopPropagation();
 });
 
-
-startDateLocal.addEventListener('change', (e) => {
-    startDateISO.value = new Date(startDateLocal.value).toISOString();
-});
-
-endDateLocal.addEventListener('change', (e) => {
-    endDateISO.value = new Date(endDateLocal.value).toISOString();
-});
\ No newline at end of file